@@ -0,0 +1,24 @@
+package chunky
+
+import (
+	"io"
+
+	"github.com/matthewmueller/chunky/internal/archives"
+)
+
+// Archive is a tar or zip archive that's uploaded like a directory by passing
+// it as the upload's source. Close it once the upload is done.
+type Archive = archives.FS
+
+// OpenArchive opens a tar, tar.gz, tar.zst or zip file to upload
+func OpenArchive(path string) (*Archive, error) {
+	return archives.Open(path)
+}
+
+// ReadArchive reads a tar, tar.gz, tar.zst or zip stream to upload. Tarballs
+// are uploaded as they're streamed, so a .chunkyignore file inside them isn't
+// used. Zip streams can't be read in order, so they're copied to a temporary
+// file first.
+func ReadArchive(r io.Reader) (*Archive, error) {
+	return archives.Read(r)
+}
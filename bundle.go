@@ -0,0 +1,166 @@
+package chunky
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+
+	"github.com/matthewmueller/chunky/internal/commits"
+	"github.com/matthewmueller/chunky/repos"
+	"github.com/matthewmueller/chunky/repos/bundle"
+)
+
+type CreateBundle struct {
+	From repos.Repo
+	To   io.Writer
+
+	// Revisions to bundle along with their history (default: latest). Revisions
+	// that are tags bundle the tag too.
+	Revisions []string
+
+	// Base revisions that the importing repository already has. Their history
+	// is left out of the bundle and they're required to import it. (optional)
+	Base []string
+
+	// Concurrency is the number of packs to read concurrently (default: num cpus * 2)
+	Concurrency *int
+	concurrency int
+}
+
+func (in *CreateBundle) validate() (err error) {
+	if in.From == nil {
+		err = errors.Join(err, errors.New("missing 'from' repository"))
+	}
+	if in.To == nil {
+		err = errors.Join(err, errors.New("missing 'to' writer"))
+	}
+
+	// Default to the latest revision
+	if len(in.Revisions) == 0 {
+		in.Revisions = []string{"latest"}
+	}
+
+	// Set the concurrency if provided
+	if in.Concurrency != nil {
+		in.concurrency = *in.Concurrency
+		if in.concurrency <= 0 {
+			err = errors.Join(err, errors.New("invalid concurrency"))
+		}
+	} else {
+		in.concurrency = DefaultConcurrency
+	}
+
+	return err
+}
+
+// CreateBundle writes revisions along with their packs and tags to a single
+// file that can be imported into another repository or read like one
+func (c *Client) CreateBundle(ctx context.Context, in *CreateBundle) error {
+	if err := in.validate(); err != nil {
+		return err
+	}
+
+	writer := bundle.Create(in.To)
+	copier := newCopier(c.log, in.From, writer)
+
+	// Leave out the history of the base revisions
+	for _, base := range in.Base {
+		commitId, err := copier.skipHistory(ctx, base)
+		if err != nil {
+			return err
+		}
+		writer.Prerequisites = append(writer.Prerequisites, commitId)
+	}
+
+	if err := copier.copy(ctx, false, in.Revisions, in.concurrency); err != nil {
+		return err
+	}
+	return writer.Close()
+}
+
+// Mark the history of a revision and the packs it refers to as already copied,
+// returning the revision's commit ID
+func (c *copier) skipHistory(ctx context.Context, revision string) (commitId string, err error) {
+	history, err := commits.Log(ctx, c.from, revision)
+	if err != nil {
+		return "", fmt.Errorf("chunky: unable to read base %q: %w", revision, err)
+	}
+	for _, commit := range history {
+		c.add(path.Join("commits", commit.ID()))
+		for _, pack := range commit.Packs() {
+			c.add(path.Join("packs", pack.ID))
+		}
+	}
+	return history[0].ID(), nil
+}
+
+type ImportBundle struct {
+	From *bundle.Repo
+	To   repos.Repo
+
+	// User importing the tags, checked against the repository's tag policy
+	// (default: current user)
+	User string
+	// Force imports tags even if the repository's policy protects them. The
+	// override is appended to the tag's history instead of replacing it.
+	Force bool
+
+	// Concurrency is the number of packs to import concurrently (default: num cpus * 2)
+	Concurrency *int
+	concurrency int
+}
+
+func (in *ImportBundle) validate() (err error) {
+	if in.From == nil {
+		err = errors.Join(err, errors.New("missing 'from' bundle"))
+	}
+	if in.To == nil {
+		err = errors.Join(err, errors.New("missing 'to' repository"))
+	}
+	if err2 := defaultUser(&in.User); err2 != nil {
+		err = errors.Join(err, err2)
+	}
+
+	// Set the concurrency if provided
+	if in.Concurrency != nil {
+		in.concurrency = *in.Concurrency
+		if in.concurrency <= 0 {
+			err = errors.Join(err, errors.New("invalid concurrency"))
+		}
+	} else {
+		in.concurrency = DefaultConcurrency
+	}
+
+	return err
+}
+
+// ImportBundle verifies a bundle and copies its revisions, packs and tags into
+// a repository. The repository must already have the bundle's base revisions
+// and its tag policy must allow the tag changes.
+func (c *Client) ImportBundle(ctx context.Context, in *ImportBundle) error {
+	if err := in.validate(); err != nil {
+		return err
+	}
+
+	// Check every file before importing anything
+	if err := in.From.Verify(); err != nil {
+		return err
+	}
+
+	copier := newCopier(c.log, in.From, in.To)
+	if err := copier.listDestination(ctx); err != nil {
+		return err
+	}
+	if err := copier.protectTags(ctx, in.User, in.Force); err != nil {
+		return err
+	}
+	for _, commitId := range in.From.Manifest.Prerequisites {
+		if !copier.has(path.Join("commits", commitId)) {
+			return fmt.Errorf("chunky: bundle requires commit %q, which the repository doesn't have", commitId)
+		}
+	}
+
+	return copier.copy(ctx, true, nil, in.concurrency)
+}
@@ -2,6 +2,7 @@ package chunky
 
 import (
 	"context"
+	"crypto/ed25519"
 	"errors"
 	"io"
 
@@ -23,6 +24,15 @@ type Cat struct {
 	MaxCacheSize string
 	maxCacheSize int
 
+	// CacheDir keeps downloaded packs on disk, so later runs don't download them
+	// again. Packs are cached by their ID, so each repository needs its own
+	// directory (optional)
+	CacheDir repos.FS
+
+	// MaxCacheDirSize is the maximum size of the packs in CacheDir (default: 2GiB)
+	MaxCacheDirSize string
+	maxCacheDirSize int
+
 	// LimitDownload is the maximum download speed per second (default: unlimited)
 	LimitDownload string
 	limitDownload int
@@ -30,6 +40,10 @@ type Cat struct {
 	// Concurrency is the number of concurrent downloads (default: num cpus * 2)
 	Concurrency *int
 	concurrency int
+
+	// TrustedKeys, when set, refuse revisions that aren't signed by one of the
+	// keys
+	TrustedKeys []ed25519.PublicKey
 }
 
 func (in *Cat) validate() (err error) {
@@ -58,6 +72,17 @@ func (in *Cat) validate() (err error) {
 		in.maxCacheSize = 512 * miB
 	}
 
+	if in.MaxCacheDirSize != "" {
+		maxCacheDirSize, err2 := humanize.ParseBytes(in.MaxCacheDirSize)
+		if err2 != nil {
+			err = errors.Join(err, errors.New("invalid max cache dir size"))
+		} else {
+			in.maxCacheDirSize = int(maxCacheDirSize)
+		}
+	} else {
+		in.maxCacheDirSize = DefaultMaxCacheDirSize
+	}
+
 	if in.LimitDownload != "" {
 		limitDownload, err2 := humanize.ParseBytes(in.LimitDownload)
 		if err2 != nil {
@@ -90,18 +115,30 @@ func (c *Client) Cat(ctx context.Context, in *Cat) error {
 
 	// Create a cached pack reader with the specified max cache size
 	pr := packs.NewCachedReader(c.log, lru.New[*packs.Pack](c.log, in.maxCacheSize))
+	if in.CacheDir != nil {
+		pr.Disk = packs.NewDiskCache(c.log, in.CacheDir, in.maxCacheDirSize)
+	}
 
 	// Set the download limit if provided
 	if in.LimitDownload != "" {
 		pr.Limiter = rate.New(in.limitDownload)
 	}
 
-	download := downloads.New(pr)
+	download := downloads.New(c.log, pr)
 
 	// Set the concurrency if provided
 	if in.Concurrency != nil {
 		download.Concurrency = *in.Concurrency
 	}
 
+	// Verify the revision first if we have trusted keys
+	if len(in.TrustedKeys) > 0 {
+		commit, err := verifyRevision(ctx, in.From, in.Revision, in.TrustedKeys)
+		if err != nil {
+			return err
+		}
+		return download.CatCommit(ctx, in.To, in.From, commit, in.Path)
+	}
+
 	return download.Cat(ctx, in.To, in.From, in.Revision, in.Path)
 }
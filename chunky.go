@@ -3,11 +3,12 @@ package chunky
 import (
 	"context"
 	"errors"
-	"fmt"
-	"io/fs"
 	"log/slog"
+	"path"
 	"runtime"
+	"strings"
 
+	"github.com/matthewmueller/chunky/internal/caches"
 	"github.com/matthewmueller/chunky/internal/commits"
 	"github.com/matthewmueller/chunky/internal/tags"
 	"github.com/matthewmueller/chunky/repos"
@@ -15,6 +16,7 @@ import (
 
 const kiB = 1024
 const miB = 1024 * kiB
+const giB = 1024 * miB
 
 // DefaultConcurrency returns the default number of concurrent downloads
 var DefaultConcurrency = runtime.NumCPU() * 2
@@ -53,6 +55,107 @@ func (c *Client) FindCommit(ctx context.Context, in *FindCommit) (*Commit, error
 	return commits.Read(ctx, in.Repo, in.Revision)
 }
 
+type Log struct {
+	Repo     repos.Repo
+	Revision string
+}
+
+func (in *Log) validate() (err error) {
+	if in.Repo == nil {
+		err = errors.Join(err, errors.New("missing 'repo'"))
+	}
+	if in.Revision == "" {
+		err = errors.Join(err, errors.New("missing 'revision'"))
+	}
+	return err
+}
+
+// Log returns the history of a revision by following its parents, newest first
+func (c *Client) Log(ctx context.Context, in *Log) ([]*Commit, error) {
+	if err := in.validate(); err != nil {
+		return nil, err
+	}
+	return commits.Log(ctx, in.Repo, in.Revision)
+}
+
+type FileHistory struct {
+	Repo     repos.Repo
+	Revision string
+	Path     string
+
+	// Cache keeps a local copy of the commits, so they're only downloaded once
+	// (optional)
+	Cache repos.FS
+}
+
+func (in *FileHistory) validate() (err error) {
+	if in.Repo == nil {
+		err = errors.Join(err, errors.New("missing 'repo'"))
+	}
+	if in.Revision == "" {
+		err = errors.Join(err, errors.New("missing 'revision'"))
+	}
+	if in.Path == "" {
+		err = errors.Join(err, errors.New("missing 'path'"))
+	}
+	return err
+}
+
+// File in a commit
+type File = commits.File
+
+// FileChange is a revision where a file was added, modified or removed
+type FileChange struct {
+	Commit *Commit
+	// Status is "added", "modified" or "removed"
+	Status string
+	// File is the file in the revision, or nil if it was removed
+	File *File
+}
+
+// FileHistory returns the revisions that changed a file by following the
+// parents of a revision, newest first
+func (c *Client) FileHistory(ctx context.Context, in *FileHistory) ([]*FileChange, error) {
+	if err := in.validate(); err != nil {
+		return nil, err
+	}
+	fpath := strings.TrimPrefix(path.Clean("/"+in.Path), "/")
+
+	// Look up commits in the local cache before downloading them
+	cached := func(string) (*Commit, bool) { return nil, false }
+	if in.Cache != nil {
+		cache, err := caches.Download(ctx, in.Repo, in.Cache)
+		if err != nil {
+			return nil, err
+		}
+		cached = cache.Commit
+	}
+
+	// Report whatever history we were able to read, even if the chain is broken
+	history, logErr := commits.LogCached(ctx, in.Repo, in.Revision, cached)
+	var changes []*FileChange
+	for i, commit := range history {
+		// Without the parent we can't tell what the oldest commit changed
+		if i+1 == len(history) && logErr != nil {
+			break
+		}
+		file, ok := commit.File(fpath)
+		var prev *File
+		if i+1 < len(history) {
+			prev, _ = history[i+1].File(fpath)
+		}
+		switch {
+		case ok && prev == nil:
+			changes = append(changes, &FileChange{Commit: commit, Status: "added", File: file})
+		case ok && prev.Id != file.Id:
+			changes = append(changes, &FileChange{Commit: commit, Status: "modified", File: file})
+		case !ok && prev != nil:
+			changes = append(changes, &FileChange{Commit: commit, Status: "removed"})
+		}
+	}
+	return changes, logErr
+}
+
 type ListTags struct {
 	Repo repos.Repo
 }
@@ -80,43 +183,8 @@ func (c *Client) ListTags(ctx context.Context, in *ListTags) (allTags []*Tag, er
 	for _, tag := range tags {
 		allTags = append(allTags, &Tag{
 			Name:    tag.Name,
-			Commits: tag.Commits,
+			Commits: tag.Commits(),
 		})
 	}
 	return allTags, nil
 }
-
-type TagRevision struct {
-	Repo     repos.Repo
-	Tag      string
-	Revision string
-}
-
-// TagRevision tags a revision
-func (c *Client) TagRevision(ctx context.Context, in *TagRevision) error {
-	// Check that the commit exists
-	commit, err := commits.Read(ctx, in.Repo, in.Revision)
-	if err != nil {
-		return fmt.Errorf("cli: unable to read commit for %s: %w", in.Revision, err)
-	}
-
-	// If the tag already exists, append the commit to the end of the file
-	tag, err := tags.Read(ctx, in.Repo, in.Tag)
-	if err != nil {
-		if !errors.Is(err, fs.ErrNotExist) {
-			return fmt.Errorf("cli: unable to read tag for %s: %w", in.Tag, err)
-		}
-		tag = &tags.Tag{
-			Name: in.Tag,
-		}
-	}
-
-	// Append the commit to the tag
-	tag.Commits = append(tag.Commits, commit.ID())
-
-	// Upload the tag file
-	fromCh := make(chan *repos.File, 1)
-	fromCh <- tag.File()
-	close(fromCh)
-	return in.Repo.Upload(ctx, fromCh)
-}
@@ -0,0 +1,392 @@
+package chunky_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"github.com/matthewmueller/chunky"
+	"github.com/matthewmueller/chunky/repos/local"
+	"github.com/matthewmueller/logs"
+	"github.com/matthewmueller/virt"
+	"golang.org/x/sys/unix"
+)
+
+func TestOwnerAndXattrs(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("changing ownership requires root")
+	}
+	is := is.New(t)
+	log := logs.Default()
+	chky := chunky.New(log)
+	ctx := context.Background()
+
+	fromDir := t.TempDir()
+	fpath := filepath.Join(fromDir, "a.txt")
+	is.NoErr(os.WriteFile(fpath, []byte("a"), 0644))
+	is.NoErr(os.Lchown(fpath, 1234, 5678))
+	hasXattrs := true
+	if err := unix.Lsetxattr(fpath, "user.chunky", []byte("test"), 0); err != nil {
+		if !errors.Is(err, unix.ENOTSUP) {
+			is.NoErr(err)
+		}
+		hasXattrs = false
+	}
+	to := local.New(virt.OS(t.TempDir()))
+
+	err := chky.Upload(ctx, &chunky.Upload{
+		From:           virt.OS(fromDir),
+		To:             to,
+		Cache:          virt.OS(t.TempDir()),
+		PreserveOwner:  true,
+		NumericOwner:   true,
+		PreserveXattrs: true,
+	})
+	is.NoErr(err)
+
+	// Without the options, the file is owned by the current user
+	dir := t.TempDir()
+	err = chky.Download(ctx, &chunky.Download{
+		From:     to,
+		To:       virt.OS(dir),
+		Revision: "latest",
+	})
+	is.NoErr(err)
+	stat, err := os.Lstat(filepath.Join(dir, "a.txt"))
+	is.NoErr(err)
+	is.Equal(stat.Sys().(*syscall.Stat_t).Uid, uint32(os.Geteuid()))
+
+	// With the options, the ownership and xattrs are restored
+	dir = t.TempDir()
+	err = chky.Download(ctx, &chunky.Download{
+		From:           to,
+		To:             virt.OS(dir),
+		Revision:       "latest",
+		PreserveOwner:  true,
+		NumericOwner:   true,
+		PreserveXattrs: true,
+	})
+	is.NoErr(err)
+	stat, err = os.Lstat(filepath.Join(dir, "a.txt"))
+	is.NoErr(err)
+	is.Equal(stat.Sys().(*syscall.Stat_t).Uid, uint32(1234))
+	is.Equal(stat.Sys().(*syscall.Stat_t).Gid, uint32(5678))
+	if hasXattrs {
+		value := make([]byte, 16)
+		n, err := unix.Lgetxattr(filepath.Join(dir, "a.txt"), "user.chunky", value)
+		is.NoErr(err)
+		is.Equal(string(value[:n]), "test")
+	}
+}
+
+func TestHardLinks(t *testing.T) {
+	is := is.New(t)
+	log := logs.Default()
+	chky := chunky.New(log)
+	ctx := context.Background()
+
+	fromDir := t.TempDir()
+	is.NoErr(os.MkdirAll(filepath.Join(fromDir, "b"), 0755))
+	is.NoErr(os.WriteFile(filepath.Join(fromDir, "a.txt"), []byte("shared"), 0644))
+	is.NoErr(os.Link(filepath.Join(fromDir, "a.txt"), filepath.Join(fromDir, "b", "c.txt")))
+	to := local.New(virt.OS(t.TempDir()))
+
+	err := chky.Upload(ctx, &chunky.Upload{
+		From:  virt.OS(fromDir),
+		To:    to,
+		Cache: virt.OS(t.TempDir()),
+	})
+	is.NoErr(err)
+
+	commit, err := chky.FindCommit(ctx, &chunky.FindCommit{
+		Repo:     to,
+		Revision: "latest",
+	})
+	is.NoErr(err)
+	file, ok := commit.File("b/c.txt")
+	is.True(ok)
+	is.Equal(file.Link, "a.txt")
+	is.Equal(commit.Size(), uint64(len("shared")))
+
+	// Hard links are recreated on filesystems that support them
+	dir := t.TempDir()
+	err = chky.Download(ctx, &chunky.Download{
+		From:     to,
+		To:       virt.OS(dir),
+		Revision: "latest",
+	})
+	is.NoErr(err)
+	a, err := os.Stat(filepath.Join(dir, "a.txt"))
+	is.NoErr(err)
+	c, err := os.Stat(filepath.Join(dir, "b", "c.txt"))
+	is.NoErr(err)
+	is.True(os.SameFile(a, c))
+
+	// Otherwise they're copied
+	dir = t.TempDir()
+	err = chky.Download(ctx, &chunky.Download{
+		From:     to,
+		To:       noLinkFS{virt.OS(dir)},
+		Revision: "latest",
+	})
+	is.NoErr(err)
+	a, err = os.Stat(filepath.Join(dir, "a.txt"))
+	is.NoErr(err)
+	c, err = os.Stat(filepath.Join(dir, "b", "c.txt"))
+	is.NoErr(err)
+	is.True(!os.SameFile(a, c))
+	data, err := os.ReadFile(filepath.Join(dir, "b", "c.txt"))
+	is.NoErr(err)
+	is.Equal(string(data), "shared")
+}
+
+// noLinkFS is a filesystem that doesn't support hard links
+type noLinkFS struct {
+	virt.OS
+}
+
+func (noLinkFS) Link(oldname, newname string) error {
+	return errors.ErrUnsupported
+}
+
+func TestSparseFile(t *testing.T) {
+	is := is.New(t)
+	log := logs.Default()
+	chky := chunky.New(log)
+	ctx := context.Background()
+
+	// 64MiB file with 1MiB of data in the middle
+	fromDir := t.TempDir()
+	fpath := filepath.Join(fromDir, "disk.img")
+	file, err := os.Create(fpath)
+	is.NoErr(err)
+	_, err = file.WriteAt(makeData(1*mib), 32*mib)
+	is.NoErr(err)
+	is.NoErr(file.Truncate(64 * mib))
+	is.NoErr(file.Close())
+	to := local.New(virt.OS(t.TempDir()))
+
+	err = chky.Upload(ctx, &chunky.Upload{
+		From:  virt.OS(fromDir),
+		To:    to,
+		Cache: virt.OS(t.TempDir()),
+	})
+	is.NoErr(err)
+
+	dir := t.TempDir()
+	err = chky.Download(ctx, &chunky.Download{
+		From:     to,
+		To:       virt.OS(dir),
+		Revision: "latest",
+	})
+	is.NoErr(err)
+
+	expected, err := os.ReadFile(fpath)
+	is.NoErr(err)
+	actual, err := os.ReadFile(filepath.Join(dir, "disk.img"))
+	is.NoErr(err)
+	is.Equal(len(actual), 64*mib)
+	is.True(bytes.Equal(actual, expected))
+
+	// The holes aren't allocated
+	stat, err := os.Stat(filepath.Join(dir, "disk.img"))
+	is.NoErr(err)
+	is.True(stat.Sys().(*syscall.Stat_t).Blocks*512 < 16*mib)
+}
+
+func TestSymlinkModTime(t *testing.T) {
+	is := is.New(t)
+	log := logs.Discard()
+	chky := chunky.New(log)
+	ctx := context.Background()
+
+	fromDir := t.TempDir()
+	is.NoErr(os.WriteFile(filepath.Join(fromDir, "a.txt"), []byte("a"), 0644))
+	is.NoErr(os.Symlink("a.txt", filepath.Join(fromDir, "link.txt")))
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	is.NoErr(unix.Lutimes(filepath.Join(fromDir, "link.txt"), []unix.Timeval{
+		unix.NsecToTimeval(modTime.UnixNano()),
+		unix.NsecToTimeval(modTime.UnixNano()),
+	}))
+	to := local.New(virt.OS(t.TempDir()))
+	cache := virt.OS(t.TempDir())
+	err := chky.Upload(ctx, &chunky.Upload{
+		From:  virt.OS(fromDir),
+		To:    to,
+		Cache: cache,
+	})
+	is.NoErr(err)
+
+	// Touching the symlink uploads its new time, even though it's cached
+	time.Sleep(time.Second)
+	modTime = time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+	is.NoErr(unix.Lutimes(filepath.Join(fromDir, "link.txt"), []unix.Timeval{
+		unix.NsecToTimeval(modTime.UnixNano()),
+		unix.NsecToTimeval(modTime.UnixNano()),
+	}))
+	err = chky.Upload(ctx, &chunky.Upload{
+		From:  virt.OS(fromDir),
+		To:    to,
+		Cache: cache,
+	})
+	is.NoErr(err)
+
+	dir := t.TempDir()
+	err = chky.Download(ctx, &chunky.Download{
+		From:     to,
+		To:       virt.OS(dir),
+		Revision: "latest",
+	})
+	is.NoErr(err)
+	stat, err := os.Lstat(filepath.Join(dir, "link.txt"))
+	is.NoErr(err)
+	is.True(stat.Mode()&fs.ModeSymlink != 0)
+	is.True(stat.ModTime().Equal(modTime))
+	// The target keeps its own time
+	stat, err = os.Stat(filepath.Join(dir, "link.txt"))
+	is.NoErr(err)
+	is.True(!stat.ModTime().Equal(modTime))
+}
+
+func TestCatSparseFile(t *testing.T) {
+	is := is.New(t)
+	log := logs.Discard()
+	chky := chunky.New(log)
+	ctx := context.Background()
+
+	// 8MiB file with 1MiB of data in the middle and a hole at the end
+	fromDir := t.TempDir()
+	fpath := filepath.Join(fromDir, "disk.img")
+	file, err := os.Create(fpath)
+	is.NoErr(err)
+	_, err = file.WriteAt(makeData(1*mib), 4*mib)
+	is.NoErr(err)
+	is.NoErr(file.Truncate(8 * mib))
+	is.NoErr(file.Close())
+	expected, err := os.ReadFile(fpath)
+	is.NoErr(err)
+	to := local.New(virt.OS(t.TempDir()))
+	err = chky.Upload(ctx, &chunky.Upload{
+		From:  virt.OS(fromDir),
+		To:    to,
+		Cache: virt.OS(t.TempDir()),
+	})
+	is.NoErr(err)
+
+	// Pipes can't seek, so the holes are written as zeros
+	pr, pw, err := os.Pipe()
+	is.NoErr(err)
+	done := make(chan []byte)
+	go func() {
+		data, _ := io.ReadAll(pr)
+		done <- data
+	}()
+	err = chky.Cat(ctx, &chunky.Cat{
+		From:     to,
+		To:       pw,
+		Revision: "latest",
+		Path:     "disk.img",
+	})
+	is.NoErr(err)
+	is.NoErr(pw.Close())
+	actual := <-done
+	is.NoErr(pr.Close())
+	is.Equal(len(actual), 8*mib)
+	is.True(bytes.Equal(actual, expected))
+
+	// Files opened for appending keep what's already there
+	outPath := filepath.Join(t.TempDir(), "out")
+	is.NoErr(os.WriteFile(outPath, []byte("prefix\n"), 0644))
+	out, err := os.OpenFile(outPath, os.O_WRONLY|os.O_APPEND, 0)
+	is.NoErr(err)
+	err = chky.Cat(ctx, &chunky.Cat{
+		From:     to,
+		To:       out,
+		Revision: "latest",
+		Path:     "disk.img",
+	})
+	is.NoErr(err)
+	is.NoErr(out.Close())
+	actual, err = os.ReadFile(outPath)
+	is.NoErr(err)
+	is.Equal(len(actual), 8*mib+len("prefix\n"))
+	is.True(bytes.Equal(actual, append([]byte("prefix\n"), expected...)))
+}
+
+func TestSpecialFiles(t *testing.T) {
+	is := is.New(t)
+	log := logs.Discard()
+	chky := chunky.New(log)
+	ctx := context.Background()
+
+	fromDir := t.TempDir()
+	is.NoErr(os.WriteFile(filepath.Join(fromDir, "a.txt"), []byte("a"), 0644))
+	is.NoErr(unix.Mkfifo(filepath.Join(fromDir, "pipe"), 0600))
+	hasDevice := os.Geteuid() == 0
+	if hasDevice {
+		is.NoErr(unix.Mknod(filepath.Join(fromDir, "null"), unix.S_IFCHR|0666, int(unix.Mkdev(1, 3))))
+	}
+
+	// Special files are skipped by default
+	to := local.New(virt.OS(t.TempDir()))
+	err := chky.Upload(ctx, &chunky.Upload{
+		From:  virt.OS(fromDir),
+		To:    to,
+		Cache: virt.OS(t.TempDir()),
+	})
+	is.NoErr(err)
+	commit, err := chky.FindCommit(ctx, &chunky.FindCommit{Repo: to, Revision: "latest"})
+	is.NoErr(err)
+	_, ok := commit.File("pipe")
+	is.True(!ok)
+	_, ok = commit.File("a.txt")
+	is.True(ok)
+
+	// Or fail the upload
+	err = chky.Upload(ctx, &chunky.Upload{
+		From:         virt.OS(fromDir),
+		To:           local.New(virt.OS(t.TempDir())),
+		Cache:        virt.OS(t.TempDir()),
+		SpecialFiles: chunky.FailSpecialFiles,
+	})
+	is.True(err != nil)
+	is.True(strings.Contains(err.Error(), `special file "`))
+
+	// Or get recorded and recreated
+	to = local.New(virt.OS(t.TempDir()))
+	err = chky.Upload(ctx, &chunky.Upload{
+		From:         virt.OS(fromDir),
+		To:           to,
+		Cache:        virt.OS(t.TempDir()),
+		SpecialFiles: chunky.RecordSpecialFiles,
+	})
+	is.NoErr(err)
+	dir := t.TempDir()
+	err = chky.Download(ctx, &chunky.Download{
+		From:     to,
+		To:       virt.OS(dir),
+		Revision: "latest",
+	})
+	is.NoErr(err)
+	stat, err := os.Lstat(filepath.Join(dir, "pipe"))
+	is.NoErr(err)
+	is.True(stat.Mode()&fs.ModeNamedPipe != 0)
+	is.Equal(stat.Mode().Perm(), fs.FileMode(0600))
+	if hasDevice {
+		stat, err := os.Lstat(filepath.Join(dir, "null"))
+		is.NoErr(err)
+		is.True(stat.Mode()&fs.ModeCharDevice != 0)
+		rdev := uint64(stat.Sys().(*syscall.Stat_t).Rdev)
+		is.Equal(unix.Major(rdev), uint32(1))
+		is.Equal(unix.Minor(rdev), uint32(3))
+	}
+}
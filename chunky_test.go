@@ -1,15 +1,33 @@
 package chunky_test
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
 	"io/fs"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
 	"time"
 
 	"github.com/matryer/is"
 	"github.com/matthewmueller/chunky"
+	"github.com/matthewmueller/chunky/internal/packs"
+	"github.com/matthewmueller/chunky/repos"
+	"github.com/matthewmueller/chunky/repos/bundle"
 	"github.com/matthewmueller/chunky/repos/local"
 	"github.com/matthewmueller/logs"
 	"github.com/matthewmueller/virt"
@@ -102,7 +120,7 @@ func TestLargeSmallFile(t *testing.T) {
 	is.NoErr(err)
 	is.Equal(stat.Size(), int64(len(largeData)))
 	is.Equal(stat.Mode(), fs.FileMode(0644))
-	is.True(stat.ModTime().After(modTime))
+	is.Equal(stat.ModTime().Unix(), modTime.Unix())
 
 	data, err = os.ReadFile(filepath.Join(dir, "small.txt"))
 	is.NoErr(err)
@@ -111,7 +129,584 @@ func TestLargeSmallFile(t *testing.T) {
 	is.NoErr(err)
 	is.Equal(stat.Size(), int64(len(smallData)))
 	is.Equal(stat.Mode(), fs.FileMode(0644))
-	is.True(stat.ModTime().After(modTime))
+	is.Equal(stat.ModTime().Unix(), modTime.Unix())
+}
+
+// countingRepo counts the number of times each path is downloaded
+type countingRepo struct {
+	repos.Repo
+	mu        sync.Mutex
+	downloads map[string]int
+}
+
+func (c *countingRepo) Download(ctx context.Context, toCh chan<- *repos.File, paths ...string) error {
+	c.mu.Lock()
+	c.downloads[path.Join(paths...)]++
+	c.mu.Unlock()
+	return c.Repo.Download(ctx, toCh, paths...)
+}
+
+func TestDownloadPacksOnce(t *testing.T) {
+	is := is.New(t)
+	log := logs.Discard()
+	chky := chunky.New(log)
+	ctx := context.Background()
+
+	// Spread the files across many packs
+	tree := virt.Tree{}
+	for i := 0; i < 40; i++ {
+		tree[fmt.Sprintf("file%02d.txt", i)] = &virt.File{Data: bytes.Repeat([]byte{byte('a' + i%26)}, 300), Mode: 0644}
+	}
+	to := local.New(virt.OS(t.TempDir()))
+	err := chky.Upload(ctx, &chunky.Upload{
+		From:         tree,
+		To:           to,
+		Cache:        virt.OS(t.TempDir()),
+		MinChunkSize: "512B",
+		MaxChunkSize: "1KiB",
+		MaxPackSize:  "2KiB",
+	})
+	is.NoErr(err)
+
+	// Each pack is downloaded once, even when the packs don't fit in the cache
+	repo := &countingRepo{Repo: to, downloads: map[string]int{}}
+	concurrency := 3
+	outDir := t.TempDir()
+	err = chky.Download(ctx, &chunky.Download{
+		From:         repo,
+		To:           virt.OS(outDir),
+		Revision:     "latest",
+		MaxCacheSize: "1B",
+		Concurrency:  &concurrency,
+	})
+	is.NoErr(err)
+	packCount := 0
+	for fpath, count := range repo.downloads {
+		if strings.HasPrefix(fpath, "packs/") {
+			packCount++
+			is.Equal(count, 1) // pack downloaded more than once
+		}
+	}
+	is.True(packCount > 5)
+	for name, file := range tree {
+		data, err := os.ReadFile(filepath.Join(outDir, name))
+		is.NoErr(err)
+		is.Equal(data, file.Data)
+	}
+}
+
+func TestDownloadPackCache(t *testing.T) {
+	is := is.New(t)
+	log := logs.Discard()
+	chky := chunky.New(log)
+	ctx := context.Background()
+
+	to := local.New(virt.OS(t.TempDir()))
+	err := chky.Upload(ctx, &chunky.Upload{
+		From: virt.Tree{
+			"a.txt": &virt.File{Data: []byte("a"), Mode: 0644},
+		},
+		To:    to,
+		Cache: virt.OS(t.TempDir()),
+	})
+	is.NoErr(err)
+
+	// Packs are only downloaded by the first run
+	packCache := virt.OS(t.TempDir())
+	countPacks := func(downloads map[string]int) (count int) {
+		for fpath, n := range downloads {
+			if strings.HasPrefix(fpath, "packs/") {
+				count += n
+			}
+		}
+		return count
+	}
+	for _, expect := range []int{1, 0} {
+		repo := &countingRepo{Repo: to, downloads: map[string]int{}}
+		buf := new(bytes.Buffer)
+		err = chky.Cat(ctx, &chunky.Cat{
+			From:     repo,
+			To:       buf,
+			Revision: "latest",
+			Path:     "a.txt",
+			CacheDir: packCache,
+		})
+		is.NoErr(err)
+		is.Equal(buf.String(), "a")
+		is.Equal(countPacks(repo.downloads), expect) // cat run
+		outDir := t.TempDir()
+		err = chky.Download(ctx, &chunky.Download{
+			From:     repo,
+			To:       virt.OS(outDir),
+			Revision: "latest",
+			CacheDir: packCache,
+		})
+		is.NoErr(err)
+		is.Equal(countPacks(repo.downloads), expect) // download run
+		data, err := os.ReadFile(filepath.Join(outDir, "a.txt"))
+		is.NoErr(err)
+		is.Equal(string(data), "a")
+	}
+}
+
+func TestDownloadNoModTime(t *testing.T) {
+	is := is.New(t)
+	log := logs.Default()
+	chky := chunky.New(log)
+	ctx := context.Background()
+
+	modTime := time.Now().Add(-24 * time.Hour)
+	from := virt.Tree{
+		"a.txt": &virt.File{
+			Data:    []byte("a"),
+			Mode:    0644,
+			ModTime: modTime,
+		},
+	}
+	to := local.New(virt.OS(t.TempDir()))
+
+	err := chky.Upload(ctx, &chunky.Upload{
+		From:  from,
+		To:    to,
+		Cache: virt.OS(t.TempDir()),
+	})
+	is.NoErr(err)
+
+	dir := t.TempDir()
+	preserveModTime := false
+	err = chky.Download(ctx, &chunky.Download{
+		From:            to,
+		To:              virt.OS(dir),
+		Revision:        "latest",
+		PreserveModTime: &preserveModTime,
+	})
+	is.NoErr(err)
+
+	stat, err := os.Stat(filepath.Join(dir, "a.txt"))
+	is.NoErr(err)
+	is.True(stat.ModTime().After(modTime.Add(time.Hour)))
+}
+
+func TestDirectories(t *testing.T) {
+	is := is.New(t)
+	log := logs.Default()
+	chky := chunky.New(log)
+	ctx := context.Background()
+
+	fromDir := t.TempDir()
+	is.NoErr(os.MkdirAll(filepath.Join(fromDir, "storage", "logs"), 0755))
+	is.NoErr(os.MkdirAll(filepath.Join(fromDir, "private"), 0755))
+	is.NoErr(os.WriteFile(filepath.Join(fromDir, "private", "a.txt"), []byte("a"), 0644))
+	is.NoErr(os.Chmod(filepath.Join(fromDir, "storage", "logs"), 0750))
+	is.NoErr(os.Chmod(filepath.Join(fromDir, "private"), 0700))
+	modTime := time.Now().Add(-24 * time.Hour)
+	is.NoErr(os.Chtimes(filepath.Join(fromDir, "private"), modTime, modTime))
+	to := local.New(virt.OS(t.TempDir()))
+
+	err := chky.Upload(ctx, &chunky.Upload{
+		From:  virt.OS(fromDir),
+		To:    to,
+		Cache: virt.OS(t.TempDir()),
+	})
+	is.NoErr(err)
+
+	dir := t.TempDir()
+	err = chky.Download(ctx, &chunky.Download{
+		From:     to,
+		To:       virt.OS(dir),
+		Revision: "latest",
+	})
+	is.NoErr(err)
+
+	stat, err := os.Stat(filepath.Join(dir, "storage", "logs"))
+	is.NoErr(err)
+	is.True(stat.IsDir())
+	is.Equal(stat.Mode().Perm(), fs.FileMode(0750))
+
+	stat, err = os.Stat(filepath.Join(dir, "private"))
+	is.NoErr(err)
+	is.True(stat.IsDir())
+	is.Equal(stat.Mode().Perm(), fs.FileMode(0700))
+	is.Equal(stat.ModTime().Unix(), modTime.Unix())
+
+	data, err := os.ReadFile(filepath.Join(dir, "private", "a.txt"))
+	is.NoErr(err)
+	is.Equal(string(data), "a")
+}
+
+func TestDirectoryModTimeChanged(t *testing.T) {
+	is := is.New(t)
+	log := logs.Default()
+	chky := chunky.New(log)
+	ctx := context.Background()
+
+	fromDir := t.TempDir()
+	is.NoErr(os.MkdirAll(filepath.Join(fromDir, "logs"), 0755))
+	modTime := time.Now().Add(-48 * time.Hour)
+	is.NoErr(os.Chtimes(filepath.Join(fromDir, "logs"), modTime, modTime))
+	to := local.New(virt.OS(t.TempDir()))
+	cache := virt.OS(t.TempDir())
+
+	err := chky.Upload(ctx, &chunky.Upload{
+		From:  virt.OS(fromDir),
+		To:    to,
+		Cache: cache,
+	})
+	is.NoErr(err)
+
+	// Touching the directory uploads its new modification time, even though
+	// the directory is in the cache
+	time.Sleep(time.Second)
+	modTime = time.Now().Add(-24 * time.Hour)
+	is.NoErr(os.Chtimes(filepath.Join(fromDir, "logs"), modTime, modTime))
+	err = chky.Upload(ctx, &chunky.Upload{
+		From:  virt.OS(fromDir),
+		To:    to,
+		Cache: cache,
+	})
+	is.NoErr(err)
+
+	dir := t.TempDir()
+	err = chky.Download(ctx, &chunky.Download{
+		From:     to,
+		To:       virt.OS(dir),
+		Revision: "latest",
+	})
+	is.NoErr(err)
+	stat, err := os.Stat(filepath.Join(dir, "logs"))
+	is.NoErr(err)
+	is.Equal(stat.ModTime().Unix(), modTime.Unix())
+}
+
+func TestTouchedFileModTime(t *testing.T) {
+	is := is.New(t)
+	log := logs.Discard()
+	chky := chunky.New(log)
+	ctx := context.Background()
+
+	fromDir := t.TempDir()
+	is.NoErr(os.WriteFile(filepath.Join(fromDir, "a.txt"), []byte("a"), 0644))
+	is.NoErr(os.WriteFile(filepath.Join(fromDir, "large.bin"), makeData(4*kib), 0644))
+	modTime := time.Now().Add(-48 * time.Hour)
+	for _, name := range []string{"a.txt", "large.bin"} {
+		is.NoErr(os.Chtimes(filepath.Join(fromDir, name), modTime, modTime))
+	}
+	to := local.New(virt.OS(t.TempDir()))
+	cache := virt.OS(t.TempDir())
+	upload := func() {
+		err := chky.Upload(ctx, &chunky.Upload{
+			From:         virt.OS(fromDir),
+			To:           to,
+			Cache:        cache,
+			MinChunkSize: "512B",
+			MaxChunkSize: "1KiB",
+		})
+		is.NoErr(err)
+	}
+	upload()
+
+	// Touching the files uploads their new modification times, even though
+	// their data is reused from the earlier upload
+	time.Sleep(time.Second)
+	modTime = time.Now().Add(-24 * time.Hour)
+	for _, name := range []string{"a.txt", "large.bin"} {
+		is.NoErr(os.Chtimes(filepath.Join(fromDir, name), modTime, modTime))
+	}
+	upload()
+
+	dir := t.TempDir()
+	err := chky.Download(ctx, &chunky.Download{
+		From:     to,
+		To:       virt.OS(dir),
+		Revision: "latest",
+	})
+	is.NoErr(err)
+	for _, name := range []string{"a.txt", "large.bin"} {
+		stat, err := os.Stat(filepath.Join(dir, name))
+		is.NoErr(err)
+		is.Equal(stat.ModTime().Unix(), modTime.Unix())
+	}
+}
+
+// changingFS changes a file's data and modification time each time it's
+// opened, up to a number of changes
+type changingFS struct {
+	virt.Tree
+	mu      sync.Mutex
+	path    string
+	changes int
+	opens   int
+}
+
+func (c *changingFS) Open(name string) (fs.File, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if name == c.path {
+		c.opens++
+		version := min(c.opens, c.changes+1)
+		c.Tree[name] = &virt.File{
+			Data:    changingData(version),
+			Mode:    0644,
+			ModTime: time.Unix(int64(version), 0),
+		}
+	}
+	return c.Tree.Open(name)
+}
+
+func (c *changingFS) Lstat(name string) (fs.FileInfo, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Tree.Lstat(name)
+}
+
+// Data that's chunked, since small files are read in one go
+func changingData(version int) []byte {
+	return bytes.Repeat([]byte{byte('a' + version)}, 4*kib)
+}
+
+func TestModifiedFiles(t *testing.T) {
+	is := is.New(t)
+	log := logs.Discard()
+	chky := chunky.New(log)
+	ctx := context.Background()
+
+	newFS := func(changes int) *changingFS {
+		return &changingFS{
+			Tree: virt.Tree{
+				"a.txt":   &virt.File{Data: []byte("a"), Mode: 0644},
+				"log.txt": &virt.File{Data: changingData(0), Mode: 0644},
+			},
+			path:    "log.txt",
+			changes: changes,
+		}
+	}
+
+	// Files are retried until they stop changing
+	to := local.New(virt.OS(t.TempDir()))
+	err := chky.Upload(ctx, &chunky.Upload{
+		From:         newFS(1),
+		To:           to,
+		Cache:        virt.OS(t.TempDir()),
+		MinChunkSize: "512B",
+		MaxChunkSize: "1KiB",
+	})
+	is.NoErr(err)
+	buf := new(bytes.Buffer)
+	err = chky.Cat(ctx, &chunky.Cat{
+		From:     to,
+		To:       buf,
+		Revision: "latest",
+		Path:     "log.txt",
+	})
+	is.NoErr(err)
+	is.Equal(buf.Bytes(), changingData(2))
+
+	// Files that keep changing fail the upload
+	err = chky.Upload(ctx, &chunky.Upload{
+		From:         newFS(100),
+		To:           local.New(virt.OS(t.TempDir())),
+		Cache:        virt.OS(t.TempDir()),
+		MinChunkSize: "512B",
+		MaxChunkSize: "1KiB",
+	})
+	is.True(err != nil)
+	is.True(strings.Contains(err.Error(), "modified during upload"))
+
+	// Unless they're skipped
+	to = local.New(virt.OS(t.TempDir()))
+	err = chky.Upload(ctx, &chunky.Upload{
+		From:          newFS(100),
+		To:            to,
+		Cache:         virt.OS(t.TempDir()),
+		MinChunkSize:  "512B",
+		MaxChunkSize:  "1KiB",
+		ModifiedFiles: chunky.SkipModifiedFiles,
+	})
+	is.NoErr(err)
+	commit, err := chky.FindCommit(ctx, &chunky.FindCommit{Repo: to, Revision: "latest"})
+	is.NoErr(err)
+	_, ok := commit.File("log.txt")
+	is.True(!ok)
+	_, ok = commit.File("a.txt")
+	is.True(ok)
+
+	// Or fail right away
+	err = chky.Upload(ctx, &chunky.Upload{
+		From:          newFS(1),
+		To:            local.New(virt.OS(t.TempDir())),
+		Cache:         virt.OS(t.TempDir()),
+		MinChunkSize:  "512B",
+		MaxChunkSize:  "1KiB",
+		ModifiedFiles: chunky.FailModifiedFiles,
+	})
+	is.True(err != nil)
+	is.True(strings.Contains(err.Error(), "modified during upload"))
+}
+
+// countingFS counts the number of times each file is opened
+type countingFS struct {
+	virt.OS
+	mu    sync.Mutex
+	opens map[string]int
+}
+
+func (c *countingFS) Open(name string) (fs.File, error) {
+	c.mu.Lock()
+	c.opens[name]++
+	c.mu.Unlock()
+	return c.OS.Open(name)
+}
+
+func TestUnchangedFilesNotRead(t *testing.T) {
+	is := is.New(t)
+	log := logs.Discard()
+	chky := chunky.New(log)
+	ctx := context.Background()
+
+	dir := t.TempDir()
+	past := time.Now().Add(-time.Hour)
+	for name, data := range map[string][]byte{
+		"a.txt":     []byte("a.txt"),
+		"b.txt":     []byte("b.txt"),
+		"large.bin": makeData(4 * kib),
+	} {
+		fpath := filepath.Join(dir, name)
+		is.NoErr(os.WriteFile(fpath, data, 0644))
+		is.NoErr(os.Chtimes(fpath, past, past))
+	}
+	to := local.New(virt.OS(t.TempDir()))
+	cache := virt.OS(t.TempDir())
+	upload := func(forceRehash bool) map[string]int {
+		from := &countingFS{OS: virt.OS(dir), opens: map[string]int{}}
+		err := chky.Upload(ctx, &chunky.Upload{
+			From:         from,
+			To:           to,
+			Cache:        cache,
+			ForceRehash:  forceRehash,
+			MinChunkSize: "512B",
+			MaxChunkSize: "1KiB",
+		})
+		is.NoErr(err)
+		return from.opens
+	}
+
+	// The first upload reads every file once, including files that are chunked
+	opens := upload(false)
+	is.Equal(opens["a.txt"], 1)
+	is.Equal(opens["b.txt"], 1)
+	is.Equal(opens["large.bin"], 1)
+
+	// Unchanged files aren't read again
+	opens = upload(false)
+	is.Equal(opens["a.txt"], 0)
+	is.Equal(opens["b.txt"], 0)
+
+	// Changed files are read
+	fpath := filepath.Join(dir, "b.txt")
+	is.NoErr(os.WriteFile(fpath, []byte("bb"), 0644))
+	is.NoErr(os.Chtimes(fpath, past, past))
+	opens = upload(false)
+	is.Equal(opens["a.txt"], 0)
+	is.Equal(opens["b.txt"], 1)
+
+	// Unless every file is rehashed
+	opens = upload(true)
+	is.Equal(opens["a.txt"], 1)
+	is.Equal(opens["b.txt"], 1)
+
+	buf := new(bytes.Buffer)
+	err := chky.Cat(ctx, &chunky.Cat{
+		From:     to,
+		To:       buf,
+		Revision: "latest",
+		Path:     "b.txt",
+	})
+	is.NoErr(err)
+	is.Equal(buf.String(), "bb")
+}
+
+func TestUnchangedLargeFileEmptyCache(t *testing.T) {
+	is := is.New(t)
+	log := logs.Discard()
+	chky := chunky.New(log)
+	ctx := context.Background()
+
+	from := virt.Tree{
+		"large.bin": &virt.File{Data: makeData(64 * kib), Mode: 0644},
+	}
+	repoDir := t.TempDir()
+	to := local.New(virt.OS(repoDir))
+	upload := func() int {
+		err := chky.Upload(ctx, &chunky.Upload{
+			From:         from,
+			To:           to,
+			Cache:        virt.OS(t.TempDir()),
+			MinChunkSize: "512B",
+			MaxChunkSize: "1KiB",
+		})
+		is.NoErr(err)
+		packList, err := os.ReadDir(filepath.Join(repoDir, "packs"))
+		is.NoErr(err)
+		return len(packList)
+	}
+
+	// Re-uploading an unchanged large file with an empty cache doesn't add its
+	// blobs again
+	numPacks := upload()
+	time.Sleep(time.Second)
+	is.Equal(upload(), numPacks)
+}
+
+func TestChangedFilesSameSizeAndModTime(t *testing.T) {
+	is := is.New(t)
+	log := logs.Discard()
+	chky := chunky.New(log)
+	ctx := context.Background()
+
+	to := local.New(virt.OS(t.TempDir()))
+	cache := virt.OS(t.TempDir())
+	cat := func() string {
+		buf := new(bytes.Buffer)
+		err := chky.Cat(ctx, &chunky.Cat{
+			From:     to,
+			To:       buf,
+			Revision: "latest",
+			Path:     "a.txt",
+		})
+		is.NoErr(err)
+		return buf.String()
+	}
+
+	// Files without inodes are always hashed
+	modTime := time.Now().Add(-time.Hour)
+	for _, data := range []string{"aaaa", "bbbb"} {
+		err := chky.Upload(ctx, &chunky.Upload{
+			From: virt.Tree{
+				"a.txt": &virt.File{Data: []byte(data), ModTime: modTime},
+			},
+			To:    to,
+			Cache: cache,
+		})
+		is.NoErr(err)
+		is.Equal(cat(), data)
+		time.Sleep(time.Second)
+	}
+
+	// Files with inodes notice the status change
+	dir := t.TempDir()
+	fpath := filepath.Join(dir, "a.txt")
+	for _, data := range []string{"cccc", "dddd"} {
+		is.NoErr(os.WriteFile(fpath, []byte(data), 0644))
+		is.NoErr(os.Chtimes(fpath, modTime, modTime))
+		err := chky.Upload(ctx, &chunky.Upload{
+			From:  virt.OS(dir),
+			To:    to,
+			Cache: cache,
+		})
+		is.NoErr(err)
+		is.Equal(cat(), data)
+		time.Sleep(time.Second)
+	}
 }
 
 func TestPaths(t *testing.T) {
@@ -178,3 +773,1302 @@ func TestPaths(t *testing.T) {
 	is.Equal(string(data), "d")
 
 }
+
+func TestMessageAndMeta(t *testing.T) {
+	is := is.New(t)
+	log := logs.Default()
+	chky := chunky.New(log)
+	ctx := context.Background()
+
+	from := virt.Tree{
+		".git/HEAD":            &virt.File{Data: []byte("ref: refs/heads/main\n"), Mode: 0644},
+		".git/refs/heads/main": &virt.File{Data: []byte("3f786850e387550fdab836ed7e6dc881de23001b\n"), Mode: 0644},
+		"a.txt":                &virt.File{Data: []byte("a"), Mode: 0644},
+	}
+	to := local.New(virt.OS(t.TempDir()))
+
+	err := chky.Upload(ctx, &chunky.Upload{
+		From:    from,
+		To:      to,
+		Cache:   virt.OS(t.TempDir()),
+		Message: "deploy the thing",
+		Meta: map[string]string{
+			"env":        "prod",
+			"git.branch": "release",
+		},
+	})
+	is.NoErr(err)
+
+	commit, err := chky.FindCommit(ctx, &chunky.FindCommit{
+		Repo:     to,
+		Revision: "latest",
+	})
+	is.NoErr(err)
+	is.Equal(commit.Message(), "deploy the thing")
+	is.Equal(commit.Meta()["env"], "prod")
+	is.Equal(commit.Meta()["git.commit"], "3f786850e387550fdab836ed7e6dc881de23001b")
+	// Explicit metadata takes precedence over the captured git metadata
+	is.Equal(commit.Meta()["git.branch"], "release")
+	is.True(commit.HasMeta("env", "prod"))
+	is.True(!commit.HasMeta("env", "dev"))
+}
+
+func TestInvalidTagNames(t *testing.T) {
+	is := is.New(t)
+	log := logs.Discard()
+	chky := chunky.New(log)
+	ctx := context.Background()
+
+	to := local.New(virt.OS(t.TempDir()))
+	err := chky.Upload(ctx, &chunky.Upload{
+		From:  virt.Tree{"a.txt": &virt.File{Data: []byte("a"), Mode: 0644}},
+		To:    to,
+		Cache: virt.OS(t.TempDir()),
+		Tags:  []string{"v1"},
+	})
+	is.NoErr(err)
+
+	// Tags can't replace another tag's signature or be stored outside the tags
+	for _, name := range []string{"v1.sig", "releases/v1", `releases\v1`, ".."} {
+		err = chky.TagRevision(ctx, &chunky.TagRevision{
+			Repo:     to,
+			Tag:      name,
+			Revision: "latest",
+		})
+		is.True(err != nil)
+		err = chky.Upload(ctx, &chunky.Upload{
+			From:  virt.Tree{"a.txt": &virt.File{Data: []byte("a"), Mode: 0644}},
+			To:    to,
+			Cache: virt.OS(t.TempDir()),
+			Tags:  []string{name},
+		})
+		is.True(err != nil)
+	}
+	tags, err := chky.ListTags(ctx, &chunky.ListTags{Repo: to})
+	is.NoErr(err)
+	is.Equal(len(tags), 2)
+}
+
+func TestRepoWithoutRemove(t *testing.T) {
+	is := is.New(t)
+	log := logs.Discard()
+	chky := chunky.New(log)
+	ctx := context.Background()
+
+	// Repositories don't need to support removing paths
+	to := &countingRepo{Repo: local.New(virt.OS(t.TempDir())), downloads: map[string]int{}}
+	err := chky.Upload(ctx, &chunky.Upload{
+		From:  virt.Tree{"a.txt": &virt.File{Data: []byte("a"), Mode: 0644}},
+		To:    to,
+		Cache: virt.OS(t.TempDir()),
+		Tags:  []string{"v1"},
+	})
+	is.NoErr(err)
+
+	// Tags can't be deleted from them though
+	err = chky.DeleteTag(ctx, &chunky.DeleteTag{Repo: to, Tag: "v1"})
+	is.True(errors.Is(err, errors.ErrUnsupported))
+}
+
+func TestTagLogRollbackDelete(t *testing.T) {
+	is := is.New(t)
+	log := logs.Default()
+	chky := chunky.New(log)
+	ctx := context.Background()
+
+	from := virt.Tree{
+		"a.txt": &virt.File{Data: []byte("a"), Mode: 0644},
+	}
+	to := local.New(virt.OS(t.TempDir()))
+	err := chky.Upload(ctx, &chunky.Upload{
+		From:  from,
+		To:    to,
+		Cache: virt.OS(t.TempDir()),
+	})
+	is.NoErr(err)
+
+	first, err := chky.FindCommit(ctx, &chunky.FindCommit{Repo: to, Revision: "latest"})
+	is.NoErr(err)
+	// Commit IDs have second precision
+	time.Sleep(time.Second)
+	err = chky.Upload(ctx, &chunky.Upload{
+		From:  virt.Tree{"a.txt": &virt.File{Data: []byte("aa"), Mode: 0644}},
+		To:    to,
+		Cache: virt.OS(t.TempDir()),
+	})
+	is.NoErr(err)
+
+	// Nothing to roll back to yet
+	err = chky.TagRevision(ctx, &chunky.TagRevision{
+		Repo:     to,
+		Tag:      "prod",
+		Revision: first.ID(),
+	})
+	is.NoErr(err)
+	_, err = chky.RollbackTag(ctx, &chunky.RollbackTag{Repo: to, Tag: "prod"})
+	is.True(err != nil)
+
+	// Move the tag to build up some history
+	err = chky.TagRevision(ctx, &chunky.TagRevision{
+		Repo:     to,
+		Tag:      "prod",
+		Revision: "latest",
+	})
+	is.NoErr(err)
+	entries, err := chky.TagLog(ctx, &chunky.TagLog{Repo: to, Tag: "prod"})
+	is.NoErr(err)
+	is.Equal(len(entries), 2)
+	second := entries[0].Commit
+
+	// Rolling back records who rolled back and keeps the bad move
+	commit, err := chky.RollbackTag(ctx, &chunky.RollbackTag{Repo: to, Tag: "prod", User: "alice"})
+	is.NoErr(err)
+	is.Equal(commit.ID(), first.ID())
+	entries, err = chky.TagLog(ctx, &chunky.TagLog{Repo: to, Tag: "prod"})
+	is.NoErr(err)
+	is.Equal(len(entries), 3)
+	is.Equal(entries[0].Commit.ID(), first.ID())
+	is.Equal(entries[0].User, "alice")
+	is.True(entries[0].Rollback)
+	is.True(!entries[0].Forced)
+	is.True(!entries[0].MovedAt.IsZero())
+	is.Equal(entries[1].Commit.ID(), second.ID())
+	is.True(!entries[1].Rollback)
+
+	// Revisions go back through the rollbacks too
+	commit, err = chky.FindCommit(ctx, &chunky.FindCommit{Repo: to, Revision: "prod@{1}"})
+	is.NoErr(err)
+	is.Equal(commit.ID(), second.ID())
+
+	// Delete the tag
+	err = chky.DeleteTag(ctx, &chunky.DeleteTag{Repo: to, Tag: "prod"})
+	is.NoErr(err)
+	allTags, err := chky.ListTags(ctx, &chunky.ListTags{Repo: to})
+	is.NoErr(err)
+	for _, tag := range allTags {
+		is.True(tag.Name != "prod")
+	}
+	err = chky.DeleteTag(ctx, &chunky.DeleteTag{Repo: to, Tag: "prod"})
+	is.True(errors.Is(err, fs.ErrNotExist))
+}
+
+func TestUploadTagHistory(t *testing.T) {
+	is := is.New(t)
+	log := logs.Default()
+	chky := chunky.New(log)
+	ctx := context.Background()
+
+	from := virt.Tree{
+		"a.txt": &virt.File{Data: []byte("a"), Mode: 0644},
+	}
+	to := local.New(virt.OS(t.TempDir()))
+	cache := virt.OS(t.TempDir())
+
+	for range 2 {
+		err := chky.Upload(ctx, &chunky.Upload{
+			From:  from,
+			To:    to,
+			Cache: cache,
+			User:  "tester",
+			Tags:  []string{"prod"},
+		})
+		is.NoErr(err)
+	}
+
+	// Uploading again shouldn't erase the tag's history
+	for _, name := range []string{"prod", "latest"} {
+		entries, err := chky.TagLog(ctx, &chunky.TagLog{Repo: to, Tag: name})
+		is.NoErr(err)
+		is.Equal(len(entries), 2)
+		is.Equal(entries[0].User, "tester")
+		is.True(!entries[0].MovedAt.IsZero())
+	}
+
+	// Previous positions can be addressed as revisions
+	commit, err := chky.FindCommit(ctx, &chunky.FindCommit{Repo: to, Revision: "latest@{1}"})
+	is.NoErr(err)
+	is.True(commit != nil)
+	_, err = chky.FindCommit(ctx, &chunky.FindCommit{Repo: to, Revision: "latest@{2}"})
+	is.True(errors.Is(err, fs.ErrNotExist))
+}
+
+func TestProtectedTags(t *testing.T) {
+	is := is.New(t)
+	log := logs.Default()
+	chky := chunky.New(log)
+	ctx := context.Background()
+
+	from := virt.Tree{
+		"a.txt": &virt.File{Data: []byte("a"), Mode: 0644},
+	}
+	to := local.New(virt.OS(t.TempDir()))
+	cache := virt.OS(t.TempDir())
+
+	err := chky.ProtectTags(ctx, &chunky.ProtectTags{
+		Repo: to,
+		Rule: &chunky.TagRule{Pattern: "v*", Immutable: true},
+	})
+	is.NoErr(err)
+
+	err = chky.Upload(ctx, &chunky.Upload{
+		From:  from,
+		To:    to,
+		Cache: cache,
+		User:  "ci",
+		Tags:  []string{"v1.0.0"},
+	})
+	is.NoErr(err)
+
+	// Retagging a release fails
+	err = chky.TagRevision(ctx, &chunky.TagRevision{
+		Repo:     to,
+		Tag:      "v1.0.0",
+		Revision: "latest",
+		User:     "ci",
+	})
+	is.True(errors.Is(err, chunky.ErrProtected))
+	err = chky.Upload(ctx, &chunky.Upload{
+		From:  from,
+		To:    to,
+		Cache: cache,
+		User:  "ci",
+		Tags:  []string{"v1.0.0"},
+	})
+	is.True(errors.Is(err, chunky.ErrProtected))
+
+	// Unless it's forced, which is recorded in the history
+	err = chky.TagRevision(ctx, &chunky.TagRevision{
+		Repo:     to,
+		Tag:      "v1.0.0",
+		Revision: "latest",
+		User:     "ci",
+		Force:    true,
+	})
+	is.NoErr(err)
+	entries, err := chky.TagLog(ctx, &chunky.TagLog{Repo: to, Tag: "v1.0.0"})
+	is.NoErr(err)
+	is.Equal(len(entries), 2)
+	is.True(entries[0].Forced)
+	is.True(!entries[1].Forced)
+
+	rules, err := chky.ListTagRules(ctx, &chunky.ListTagRules{Repo: to})
+	is.NoErr(err)
+	is.Equal(len(rules), 1)
+	is.Equal(rules[0].Pattern, "v*")
+}
+
+func TestSignedRevisions(t *testing.T) {
+	is := is.New(t)
+	log := logs.Default()
+	chky := chunky.New(log)
+	ctx := context.Background()
+
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	is.NoErr(err)
+	otherPub, _, err := ed25519.GenerateKey(rand.Reader)
+	is.NoErr(err)
+
+	from := virt.Tree{
+		"a.txt": &virt.File{Data: []byte("a"), Mode: 0644},
+	}
+	to := local.New(virt.OS(t.TempDir()))
+	err = chky.Upload(ctx, &chunky.Upload{
+		From:       from,
+		To:         to,
+		Cache:      virt.OS(t.TempDir()),
+		Tags:       []string{"prod"},
+		SigningKey: key,
+	})
+	is.NoErr(err)
+
+	// Download with a trusted key
+	dir := t.TempDir()
+	err = chky.Download(ctx, &chunky.Download{
+		From:        to,
+		To:          virt.OS(dir),
+		Revision:    "prod",
+		TrustedKeys: []ed25519.PublicKey{pub},
+	})
+	is.NoErr(err)
+	data, err := os.ReadFile(filepath.Join(dir, "a.txt"))
+	is.NoErr(err)
+	is.Equal(string(data), "a")
+
+	// Download with an untrusted key
+	err = chky.Download(ctx, &chunky.Download{
+		From:        to,
+		To:          virt.OS(t.TempDir()),
+		Revision:    "prod",
+		TrustedKeys: []ed25519.PublicKey{otherPub},
+	})
+	is.True(errors.Is(err, chunky.ErrUnsigned))
+
+	// Moving the tag without signing it removes the tag's old signature
+	err = chky.TagRevision(ctx, &chunky.TagRevision{
+		Repo:     to,
+		Tag:      "prod",
+		Revision: "latest",
+	})
+	is.NoErr(err)
+	_, err = repos.Download(ctx, to, "tags/prod.sig")
+	is.True(errors.Is(err, fs.ErrNotExist))
+	err = chky.Cat(ctx, &chunky.Cat{
+		From:        to,
+		To:          io.Discard,
+		Revision:    "prod",
+		Path:        "a.txt",
+		TrustedKeys: []ed25519.PublicKey{pub},
+	})
+	is.True(errors.Is(err, chunky.ErrUnsigned))
+
+	// The commit itself is still signed
+	commit, err := chky.Verify(ctx, &chunky.Verify{
+		Repo:        to,
+		Revision:    "latest",
+		TrustedKeys: []ed25519.PublicKey{pub},
+	})
+	is.NoErr(err)
+	_, err = chky.Verify(ctx, &chunky.Verify{
+		Repo:        to,
+		Revision:    commit.ID(),
+		TrustedKeys: []ed25519.PublicKey{pub},
+	})
+	is.NoErr(err)
+
+	// Uploading without signing removes the old signatures of the moved tags
+	err = chky.TagRevision(ctx, &chunky.TagRevision{
+		Repo:       to,
+		Tag:        "prod",
+		Revision:   "latest",
+		SigningKey: key,
+	})
+	is.NoErr(err)
+	_, err = repos.Download(ctx, to, "tags/prod.sig")
+	is.NoErr(err)
+	time.Sleep(time.Second)
+	err = chky.Upload(ctx, &chunky.Upload{
+		From:  from,
+		To:    to,
+		Cache: virt.OS(t.TempDir()),
+		Tags:  []string{"prod"},
+	})
+	is.NoErr(err)
+	for _, sigPath := range []string{"tags/prod.sig", "tags/latest.sig"} {
+		_, err = repos.Download(ctx, to, sigPath)
+		is.True(errors.Is(err, fs.ErrNotExist))
+	}
+}
+
+func TestCopy(t *testing.T) {
+	is := is.New(t)
+	log := logs.Discard()
+	chky := chunky.New(log)
+	ctx := context.Background()
+
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	is.NoErr(err)
+
+	// Chunked files refer to blobs in other packs
+	from := local.New(virt.OS(t.TempDir()))
+	err = chky.Upload(ctx, &chunky.Upload{
+		From: virt.Tree{
+			"a.txt":     &virt.File{Data: []byte("a"), Mode: 0644},
+			"large.txt": &virt.File{Data: makeData(8 * kib), Mode: 0644},
+		},
+		To:           from,
+		Cache:        virt.OS(t.TempDir()),
+		SigningKey:   key,
+		MinChunkSize: "512B",
+		MaxChunkSize: "1KiB",
+		MaxPackSize:  "2KiB",
+	})
+	is.NoErr(err)
+	// Commit IDs have second precision
+	time.Sleep(time.Second)
+	err = chky.Upload(ctx, &chunky.Upload{
+		From: virt.Tree{
+			"a.txt": &virt.File{Data: []byte("aa"), Mode: 0644},
+		},
+		To:         from,
+		Cache:      virt.OS(t.TempDir()),
+		Tags:       []string{"prod"},
+		SigningKey: key,
+	})
+	is.NoErr(err)
+	err = chky.ProtectTags(ctx, &chunky.ProtectTags{
+		Repo: from,
+		Rule: &chunky.TagRule{Pattern: "prod", Immutable: true},
+	})
+	is.NoErr(err)
+
+	// Copy a revision along with its history
+	to := local.New(virt.OS(t.TempDir()))
+	err = chky.Copy(ctx, &chunky.Copy{
+		From:      from,
+		To:        to,
+		Revisions: []string{"prod"},
+	})
+	is.NoErr(err)
+	history, err := chky.Log(ctx, &chunky.Log{Repo: to, Revision: "prod"})
+	is.NoErr(err)
+	is.Equal(len(history), 2)
+	commit, err := chky.Verify(ctx, &chunky.Verify{
+		Repo:        to,
+		Revision:    "prod",
+		TrustedKeys: []ed25519.PublicKey{pub},
+	})
+	is.NoErr(err)
+	is.Equal(commit.ID(), history[0].ID())
+	dir := t.TempDir()
+	err = chky.Download(ctx, &chunky.Download{
+		From:     to,
+		To:       virt.OS(dir),
+		Revision: history[1].ID(),
+	})
+	is.NoErr(err)
+	data, err := os.ReadFile(filepath.Join(dir, "large.txt"))
+	is.NoErr(err)
+	is.Equal(data, makeData(8*kib))
+
+	// Only the tag was copied, not the policy
+	rules, err := chky.ListTagRules(ctx, &chunky.ListTagRules{Repo: to})
+	is.NoErr(err)
+	is.Equal(len(rules), 0)
+
+	// Copying again doesn't transfer any packs
+	counting := &countingRepo{Repo: from, downloads: map[string]int{}}
+	err = chky.Copy(ctx, &chunky.Copy{
+		From:      counting,
+		To:        to,
+		Revisions: []string{"prod"},
+	})
+	is.NoErr(err)
+	for fpath := range counting.downloads {
+		is.True(!strings.HasPrefix(fpath, "packs/"))
+	}
+
+	// Mirror everything
+	mirror := local.New(virt.OS(t.TempDir()))
+	err = chky.Copy(ctx, &chunky.Copy{
+		From: from,
+		To:   mirror,
+		All:  true,
+	})
+	is.NoErr(err)
+	allTags, err := chky.ListTags(ctx, &chunky.ListTags{Repo: mirror})
+	is.NoErr(err)
+	is.Equal(len(allTags), 2)
+	err = chky.TagRevision(ctx, &chunky.TagRevision{
+		Repo:     mirror,
+		Tag:      "prod",
+		Revision: history[1].ID(),
+	})
+	is.True(errors.Is(err, chunky.ErrProtected))
+
+	// The destination's policy protects its tags from copies
+	err = chky.TagRevision(ctx, &chunky.TagRevision{
+		Repo:     from,
+		Tag:      "prod",
+		Revision: history[1].ID(),
+		Force:    true,
+	})
+	is.NoErr(err)
+	err = chky.UnprotectTags(ctx, &chunky.UnprotectTags{Repo: from, Pattern: "prod"})
+	is.NoErr(err)
+	err = chky.Copy(ctx, &chunky.Copy{
+		From: from,
+		To:   mirror,
+		All:  true,
+	})
+	is.True(errors.Is(err, chunky.ErrProtected))
+	rules, err = chky.ListTagRules(ctx, &chunky.ListTagRules{Repo: mirror})
+	is.NoErr(err)
+	is.Equal(len(rules), 1)
+
+	// Unless the copy is forced, which is recorded in the tag's history
+	err = chky.Copy(ctx, &chunky.Copy{
+		From:  from,
+		To:    mirror,
+		All:   true,
+		User:  "alice",
+		Force: true,
+	})
+	is.NoErr(err)
+	entries, err := chky.TagLog(ctx, &chunky.TagLog{Repo: mirror, Tag: "prod"})
+	is.NoErr(err)
+	is.Equal(len(entries), 2)
+	is.Equal(entries[0].Commit.ID(), history[1].ID())
+	is.Equal(entries[0].User, "alice")
+	is.True(entries[0].Forced)
+	rules, err = chky.ListTagRules(ctx, &chunky.ListTagRules{Repo: mirror})
+	is.NoErr(err)
+	is.Equal(len(rules), 1)
+}
+
+func TestBundle(t *testing.T) {
+	is := is.New(t)
+	log := logs.Discard()
+	chky := chunky.New(log)
+	ctx := context.Background()
+
+	from := local.New(virt.OS(t.TempDir()))
+	err := chky.Upload(ctx, &chunky.Upload{
+		From: virt.Tree{
+			"a.txt": &virt.File{Data: []byte("a"), Mode: 0644},
+		},
+		To:    from,
+		Cache: virt.OS(t.TempDir()),
+	})
+	is.NoErr(err)
+	first, err := chky.FindCommit(ctx, &chunky.FindCommit{Repo: from, Revision: "latest"})
+	is.NoErr(err)
+	// Commit IDs have second precision
+	time.Sleep(time.Second)
+	err = chky.Upload(ctx, &chunky.Upload{
+		From: virt.Tree{
+			"a.txt": &virt.File{Data: []byte("a"), Mode: 0644},
+			"b.txt": &virt.File{Data: []byte("b"), Mode: 0644},
+		},
+		To:    from,
+		Cache: virt.OS(t.TempDir()),
+		Tags:  []string{"prod"},
+	})
+	is.NoErr(err)
+
+	createBundle := func(base ...string) *bundle.Repo {
+		buf := new(bytes.Buffer)
+		err := chky.CreateBundle(ctx, &chunky.CreateBundle{
+			From:      from,
+			To:        buf,
+			Revisions: []string{"prod"},
+			Base:      base,
+		})
+		is.NoErr(err)
+		repo, err := bundle.Read(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		is.NoErr(err)
+		return repo
+	}
+
+	// Bundles can be read like a repository
+	full := createBundle()
+	dir := t.TempDir()
+	err = chky.Download(ctx, &chunky.Download{
+		From:     full,
+		To:       virt.OS(dir),
+		Revision: "prod",
+	})
+	is.NoErr(err)
+	data, err := os.ReadFile(filepath.Join(dir, "b.txt"))
+	is.NoErr(err)
+	is.Equal(string(data), "b")
+
+	// Incremental bundles leave out the base's history
+	incremental := createBundle(first.ID())
+	is.Equal(incremental.Manifest.Prerequisites, []string{first.ID()})
+	for fpath := range incremental.Manifest.Files {
+		is.True(fpath != path.Join("commits", first.ID()))
+	}
+
+	// Importing requires the base
+	to := local.New(virt.OS(t.TempDir()))
+	err = chky.ImportBundle(ctx, &chunky.ImportBundle{From: incremental, To: to})
+	is.True(err != nil)
+	is.True(strings.Contains(err.Error(), "requires commit"))
+
+	// Import the base and then the incremental bundle
+	err = chky.Copy(ctx, &chunky.Copy{From: from, To: to, Revisions: []string{first.ID()}})
+	is.NoErr(err)
+	err = chky.ImportBundle(ctx, &chunky.ImportBundle{From: incremental, To: to})
+	is.NoErr(err)
+	history, err := chky.Log(ctx, &chunky.Log{Repo: to, Revision: "prod"})
+	is.NoErr(err)
+	is.Equal(len(history), 2)
+	buf := new(bytes.Buffer)
+	err = chky.Cat(ctx, &chunky.Cat{From: to, To: buf, Revision: "prod", Path: "b.txt"})
+	is.NoErr(err)
+	is.Equal(buf.String(), "b")
+}
+
+func TestExport(t *testing.T) {
+	is := is.New(t)
+	log := logs.Discard()
+	chky := chunky.New(log)
+	ctx := context.Background()
+
+	fromDir := t.TempDir()
+	is.NoErr(os.MkdirAll(filepath.Join(fromDir, "docs"), 0750))
+	is.NoErr(os.WriteFile(filepath.Join(fromDir, "docs", "a.txt"), []byte("a"), 0600))
+	is.NoErr(os.WriteFile(filepath.Join(fromDir, "large.bin"), makeData(300*kib), 0755))
+	is.NoErr(os.Symlink("docs/a.txt", filepath.Join(fromDir, "link.txt")))
+	modTime := time.Now().Add(-24 * time.Hour).Truncate(time.Second)
+	is.NoErr(os.Chtimes(filepath.Join(fromDir, "docs", "a.txt"), modTime, modTime))
+	repo := local.New(virt.OS(t.TempDir()))
+	err := chky.Upload(ctx, &chunky.Upload{
+		From:  virt.OS(fromDir),
+		To:    repo,
+		Cache: virt.OS(t.TempDir()),
+	})
+	is.NoErr(err)
+
+	// Export everything as a compressed tarball
+	buf := new(bytes.Buffer)
+	err = chky.Export(ctx, &chunky.Export{
+		From:     repo,
+		To:       buf,
+		Revision: "latest",
+		Format:   "tar.gz",
+	})
+	is.NoErr(err)
+	gz, err := gzip.NewReader(buf)
+	is.NoErr(err)
+	tr := tar.NewReader(gz)
+	headers := map[string]*tar.Header{}
+	contents := map[string][]byte{}
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		is.NoErr(err)
+		headers[header.Name] = header
+		data, err := io.ReadAll(tr)
+		is.NoErr(err)
+		contents[header.Name] = data
+	}
+	is.Equal(headers["docs/"].Typeflag, byte(tar.TypeDir))
+	is.Equal(headers["docs/"].Mode, int64(0750))
+	is.Equal(headers["docs/a.txt"].Mode, int64(0600))
+	is.Equal(headers["docs/a.txt"].ModTime.Unix(), modTime.Unix())
+	is.Equal(string(contents["docs/a.txt"]), "a")
+	is.Equal(headers["large.bin"].Mode, int64(0755))
+	is.True(bytes.Equal(contents["large.bin"], makeData(300*kib)))
+	is.Equal(headers["link.txt"].Typeflag, byte(tar.TypeSymlink))
+	is.Equal(headers["link.txt"].Linkname, "docs/a.txt")
+
+	// Export a subpath as a zip
+	buf.Reset()
+	err = chky.Export(ctx, &chunky.Export{
+		From:     repo,
+		To:       buf,
+		Revision: "latest",
+		Format:   "zip",
+		Paths:    []string{"docs"},
+	})
+	is.NoErr(err)
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	is.NoErr(err)
+	var names []string
+	for _, file := range zr.File {
+		names = append(names, file.Name)
+	}
+	is.Equal(names, []string{"docs/", "docs/a.txt"})
+	data, err := fs.ReadFile(zr, "docs/a.txt")
+	is.NoErr(err)
+	is.Equal(string(data), "a")
+	is.Equal(zr.File[1].Mode(), fs.FileMode(0600))
+
+	// Exporting a missing path fails
+	err = chky.Export(ctx, &chunky.Export{
+		From:     repo,
+		To:       io.Discard,
+		Revision: "latest",
+		Paths:    []string{"missing"},
+	})
+	is.True(err != nil)
+}
+
+func TestImport(t *testing.T) {
+	is := is.New(t)
+	log := logs.Discard()
+	chky := chunky.New(log)
+	ctx := context.Background()
+
+	fromDir := t.TempDir()
+	is.NoErr(os.MkdirAll(filepath.Join(fromDir, "docs"), 0750))
+	is.NoErr(os.WriteFile(filepath.Join(fromDir, "docs", "a.txt"), []byte("a"), 0600))
+	is.NoErr(os.WriteFile(filepath.Join(fromDir, "large.bin"), makeData(300*kib), 0755))
+	is.NoErr(os.Symlink("docs/a.txt", filepath.Join(fromDir, "link.txt")))
+	modTime := time.Now().Add(-24 * time.Hour).Truncate(time.Second)
+	is.NoErr(os.Chtimes(filepath.Join(fromDir, "docs", "a.txt"), modTime, modTime))
+	repoDir := t.TempDir()
+	repo := local.New(virt.OS(repoDir))
+	cache := virt.OS(t.TempDir())
+	err := chky.Upload(ctx, &chunky.Upload{
+		From:  virt.OS(fromDir),
+		To:    repo,
+		Cache: cache,
+	})
+	is.NoErr(err)
+	packList, err := os.ReadDir(filepath.Join(repoDir, "packs"))
+	is.NoErr(err)
+
+	buf := new(bytes.Buffer)
+	err = chky.Export(ctx, &chunky.Export{
+		From:     repo,
+		To:       buf,
+		Revision: "latest",
+		Format:   "tar.gz",
+	})
+	is.NoErr(err)
+
+	// Commit IDs have second precision
+	time.Sleep(time.Second)
+	archive, err := chunky.ReadArchive(buf)
+	is.NoErr(err)
+	defer archive.Close()
+	err = chky.Upload(ctx, &chunky.Upload{
+		From:  archive,
+		To:    repo,
+		Cache: cache,
+		Tags:  []string{"release"},
+	})
+	is.NoErr(err)
+
+	// The files are already in the repository, so no packs are added
+	importedPacks, err := os.ReadDir(filepath.Join(repoDir, "packs"))
+	is.NoErr(err)
+	is.Equal(len(importedPacks), len(packList))
+
+	dir := t.TempDir()
+	err = chky.Download(ctx, &chunky.Download{
+		From:     repo,
+		To:       virt.OS(dir),
+		Revision: "release",
+	})
+	is.NoErr(err)
+	stat, err := os.Stat(filepath.Join(dir, "docs"))
+	is.NoErr(err)
+	is.Equal(stat.Mode().Perm(), fs.FileMode(0750))
+	stat, err = os.Stat(filepath.Join(dir, "docs", "a.txt"))
+	is.NoErr(err)
+	is.Equal(stat.Mode().Perm(), fs.FileMode(0600))
+	is.Equal(stat.ModTime().Unix(), modTime.Unix())
+	data, err := os.ReadFile(filepath.Join(dir, "large.bin"))
+	is.NoErr(err)
+	is.True(bytes.Equal(data, makeData(300*kib)))
+	link, err := os.Readlink(filepath.Join(dir, "link.txt"))
+	is.NoErr(err)
+	is.Equal(link, "docs/a.txt")
+}
+
+func TestImportStream(t *testing.T) {
+	is := is.New(t)
+	log := logs.Discard()
+	chky := chunky.New(log)
+	ctx := context.Background()
+
+	large := makeData(64 * kib)
+	repoDir := t.TempDir()
+	repo := local.New(virt.OS(repoDir))
+	cache := virt.OS(t.TempDir())
+	err := chky.Upload(ctx, &chunky.Upload{
+		From: virt.Tree{
+			"a.txt":     &virt.File{Data: []byte("a"), Mode: 0644},
+			"large.bin": &virt.File{Data: large, Mode: 0644},
+		},
+		To:           repo,
+		Cache:        cache,
+		MinChunkSize: "512B",
+		MaxChunkSize: "1KiB",
+	})
+	is.NoErr(err)
+	packList, err := os.ReadDir(filepath.Join(repoDir, "packs"))
+	is.NoErr(err)
+
+	// Write a tarball with the same files and a hard link
+	buf := new(bytes.Buffer)
+	gw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gw)
+	is.NoErr(tw.WriteHeader(&tar.Header{Name: "a.txt", Mode: 0644, Size: 1, Typeflag: tar.TypeReg}))
+	_, err = tw.Write([]byte("a"))
+	is.NoErr(err)
+	is.NoErr(tw.WriteHeader(&tar.Header{Name: "b.txt", Mode: 0644, Linkname: "a.txt", Typeflag: tar.TypeLink}))
+	is.NoErr(tw.WriteHeader(&tar.Header{Name: "large.bin", Mode: 0644, Size: int64(len(large)), Typeflag: tar.TypeReg}))
+	_, err = tw.Write(large)
+	is.NoErr(err)
+	is.NoErr(tw.Close())
+	is.NoErr(gw.Close())
+
+	// Commit IDs have second precision
+	time.Sleep(time.Second)
+	// Streams are read in order rather than copied into a temporary file
+	tmpDir := t.TempDir()
+	t.Setenv("TMPDIR", tmpDir)
+	archive, err := chunky.ReadArchive(io.MultiReader(buf))
+	is.NoErr(err)
+	defer archive.Close()
+	err = chky.Upload(ctx, &chunky.Upload{
+		From:         archive,
+		To:           repo,
+		Cache:        cache,
+		MinChunkSize: "512B",
+		MaxChunkSize: "1KiB",
+	})
+	is.NoErr(err)
+	tmpFiles, err := os.ReadDir(tmpDir)
+	is.NoErr(err)
+	is.Equal(len(tmpFiles), 0)
+
+	// The files are already in the repository, so no packs are added
+	importedPacks, err := os.ReadDir(filepath.Join(repoDir, "packs"))
+	is.NoErr(err)
+	is.Equal(len(importedPacks), len(packList))
+
+	dir := t.TempDir()
+	err = chky.Download(ctx, &chunky.Download{
+		From:     repo,
+		To:       virt.OS(dir),
+		Revision: "latest",
+	})
+	is.NoErr(err)
+	data, err := os.ReadFile(filepath.Join(dir, "large.bin"))
+	is.NoErr(err)
+	is.True(bytes.Equal(data, large))
+	data, err = os.ReadFile(filepath.Join(dir, "b.txt"))
+	is.NoErr(err)
+	is.Equal(string(data), "a")
+	a, err := os.Stat(filepath.Join(dir, "a.txt"))
+	is.NoErr(err)
+	b, err := os.Stat(filepath.Join(dir, "b.txt"))
+	is.NoErr(err)
+	is.True(os.SameFile(a, b))
+}
+
+func TestOpenFS(t *testing.T) {
+	is := is.New(t)
+	log := logs.Discard()
+	chky := chunky.New(log)
+	ctx := context.Background()
+
+	// Chunk the large file into blobs spread across packs
+	large := makeData(8 * kib)
+	repo := &countingRepo{Repo: local.New(virt.OS(t.TempDir())), downloads: map[string]int{}}
+	err := chky.Upload(ctx, &chunky.Upload{
+		From: virt.Tree{
+			"a.txt":            &virt.File{Data: []byte("a"), Mode: 0644},
+			"docs/b.txt":       &virt.File{Data: []byte("b"), Mode: 0600},
+			"docs/large.bin":   &virt.File{Data: large, Mode: 0755},
+			"docs/empty/.keep": &virt.File{Mode: 0644},
+			"link.txt":         &virt.File{Data: []byte("docs/b.txt"), Mode: fs.ModeSymlink | 0777},
+		},
+		To:           repo,
+		Cache:        virt.OS(t.TempDir()),
+		MinChunkSize: "512B",
+		MaxChunkSize: "1KiB",
+		MaxPackSize:  "2KiB",
+	})
+	is.NoErr(err)
+
+	fsys, err := chky.OpenFS(ctx, &chunky.OpenFS{Repo: repo, Revision: "latest"})
+	is.NoErr(err)
+	is.NoErr(fstest.TestFS(fsys, "a.txt", "docs/b.txt", "docs/large.bin", "docs/empty/.keep"))
+
+	data, err := fs.ReadFile(fsys, "docs/large.bin")
+	is.NoErr(err)
+	is.True(bytes.Equal(data, large))
+	stat, err := fs.Stat(fsys, "docs/b.txt")
+	is.NoErr(err)
+	is.Equal(stat.Mode(), fs.FileMode(0600))
+	// Symlinks are followed
+	data, err = fs.ReadFile(fsys, "link.txt")
+	is.NoErr(err)
+	is.Equal(string(data), "b")
+
+	// Read from the middle of the chunked file
+	file, err := fsys.Open("docs/large.bin")
+	is.NoErr(err)
+	defer file.Close()
+	buf := make([]byte, 3*kib)
+	n, err := file.(io.ReaderAt).ReadAt(buf, 5*kib)
+	is.NoErr(err)
+	is.Equal(n, len(buf))
+	is.True(bytes.Equal(buf, large[5*kib:]))
+	offset, err := file.(io.Seeker).Seek(-kib, io.SeekEnd)
+	is.NoErr(err)
+	is.Equal(offset, int64(7*kib))
+	rest, err := io.ReadAll(file)
+	is.NoErr(err)
+	is.True(bytes.Equal(rest, large[7*kib:]))
+
+	// Packs are shared, so reading again doesn't download them again
+	repo.mu.Lock()
+	var downloads []string
+	for fpath, count := range repo.downloads {
+		if strings.HasPrefix(fpath, "packs/") {
+			is.Equal(count, 1)
+			downloads = append(downloads, fpath)
+		}
+	}
+	repo.mu.Unlock()
+	is.True(len(downloads) > 1)
+}
+
+func TestOpenFSTampered(t *testing.T) {
+	is := is.New(t)
+	log := logs.Discard()
+	chky := chunky.New(log)
+	ctx := context.Background()
+
+	repoDir := t.TempDir()
+	repo := local.New(virt.OS(repoDir))
+	err := chky.Upload(ctx, &chunky.Upload{
+		From: virt.Tree{
+			"a.txt":     &virt.File{Data: []byte("a"), Mode: 0644},
+			"large.bin": &virt.File{Data: makeData(4 * kib), Mode: 0644},
+		},
+		To:           repo,
+		Cache:        virt.OS(t.TempDir()),
+		MinChunkSize: "512B",
+		MaxChunkSize: "1KiB",
+	})
+	is.NoErr(err)
+
+	// Flip the first byte of every file and blob in the packs
+	packDirs, err := os.ReadDir(filepath.Join(repoDir, "packs"))
+	is.NoErr(err)
+	for _, de := range packDirs {
+		fpath := filepath.Join(repoDir, "packs", de.Name())
+		data, err := os.ReadFile(fpath)
+		is.NoErr(err)
+		pack, err := packs.Unpack(data)
+		is.NoErr(err)
+		for _, chunk := range pack.Chunks() {
+			if len(chunk.Data) > 0 {
+				chunk.Data[0] ^= 0xff
+			}
+		}
+		data, err = pack.Pack()
+		is.NoErr(err)
+		is.NoErr(os.WriteFile(fpath, data, 0644))
+	}
+
+	fsys, err := chky.OpenFS(ctx, &chunky.OpenFS{Repo: repo, Revision: "latest"})
+	is.NoErr(err)
+	_, err = fs.ReadFile(fsys, "a.txt")
+	is.True(err != nil)
+	is.True(strings.Contains(err.Error(), "hash mismatch"))
+	_, err = fs.ReadFile(fsys, "large.bin")
+	is.True(err != nil)
+	is.True(strings.Contains(err.Error(), "hash mismatch"))
+}
+
+func TestUI(t *testing.T) {
+	is := is.New(t)
+	log := logs.Discard()
+	chky := chunky.New(log)
+	ctx := context.Background()
+
+	repo := local.New(virt.OS(t.TempDir()))
+	err := chky.Upload(ctx, &chunky.Upload{
+		From: virt.Tree{
+			"a.txt":      &virt.File{Data: []byte("one\ntwo\nthree\n"), Mode: 0644},
+			"docs/b.txt": &virt.File{Data: []byte("b"), Mode: 0644},
+			"link.txt":   &virt.File{Data: []byte("a.txt"), Mode: fs.ModeSymlink | 0777},
+		},
+		To:      repo,
+		Cache:   virt.OS(t.TempDir()),
+		Message: "first <upload>",
+	})
+	is.NoErr(err)
+	// Commit IDs have second precision
+	time.Sleep(time.Second)
+	err = chky.Upload(ctx, &chunky.Upload{
+		From: virt.Tree{
+			"a.txt":    &virt.File{Data: []byte("one\n2\nthree\n"), Mode: 0644},
+			"c.bin":    &virt.File{Data: []byte{0, 1, 2}, Mode: 0644},
+			"link.txt": &virt.File{Data: []byte("a.txt"), Mode: fs.ModeSymlink | 0777},
+		},
+		To:      repo,
+		Cache:   virt.OS(t.TempDir()),
+		Tags:    []string{"prod"},
+		Message: "second",
+		Meta:    map[string]string{"env": "test"},
+	})
+	is.NoErr(err)
+	history, err := chky.Log(ctx, &chunky.Log{Repo: repo, Revision: "latest"})
+	is.NoErr(err)
+	is.Equal(len(history), 2)
+	second, first := history[0].ID(), history[1].ID()
+
+	handler, err := chky.UI(&chunky.UI{Repo: repo})
+	is.NoErr(err)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	get := func(path string) (int, string) {
+		res, err := http.Get(server.URL + path)
+		is.NoErr(err)
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		is.NoErr(err)
+		return res.StatusCode, string(body)
+	}
+
+	// Commits are listed with their tags, size, user, message and metadata
+	status, body := get("/")
+	is.Equal(status, http.StatusOK)
+	is.True(strings.Contains(body, second))
+	is.True(strings.Contains(body, first))
+	is.True(strings.Contains(body, ">prod<"))
+	is.True(strings.Contains(body, "env=test"))
+	// Messages are escaped
+	is.True(strings.Contains(body, "first &lt;upload&gt;"))
+
+	// Tags and their history
+	status, body = get("/tags")
+	is.Equal(status, http.StatusOK)
+	is.True(strings.Contains(body, "/tags/latest"))
+	status, body = get("/tags/latest")
+	is.Equal(status, http.StatusOK)
+	is.True(strings.Contains(body, "latest@{1}"))
+	is.True(strings.Contains(body, first))
+	status, _ = get("/tags/missing")
+	is.Equal(status, http.StatusNotFound)
+
+	// Browse the file tree of an older revision
+	status, body = get("/tree/" + first + "/")
+	is.Equal(status, http.StatusOK)
+	is.True(strings.Contains(body, "docs/"))
+	is.True(strings.Contains(body, "→ a.txt"))
+	status, body = get("/tree/" + first + "/docs")
+	is.Equal(status, http.StatusOK)
+	is.True(strings.Contains(body, "b.txt"))
+	status, body = get("/tree/latest/a.txt")
+	is.Equal(status, http.StatusOK)
+	is.True(strings.Contains(body, "one\n2\nthree"))
+
+	// Download raw files, following symlinks
+	status, body = get("/raw/" + first + "/a.txt")
+	is.Equal(status, http.StatusOK)
+	is.Equal(body, "one\ntwo\nthree\n")
+	status, body = get("/raw/" + first + "/link.txt")
+	is.Equal(status, http.StatusOK)
+	is.Equal(body, "one\ntwo\nthree\n")
+	status, _ = get("/raw/latest/missing.txt")
+	is.Equal(status, http.StatusNotFound)
+
+	// Diff a revision against its parent
+	status, body = get("/diff/latest/")
+	is.Equal(status, http.StatusOK)
+	is.True(strings.Contains(body, "-two"))
+	// The template escapes the plus sign
+	is.True(strings.Contains(body, "&#43;2"))
+	is.True(strings.Contains(body, "removed"))
+	is.True(strings.Contains(body, "docs/b.txt"))
+	is.True(strings.Contains(body, "binary file"))
+	is.True(!strings.Contains(body, "link.txt"))
+
+	// Diff a single file
+	status, body = get("/diff/latest/a.txt")
+	is.Equal(status, http.StatusOK)
+	is.True(strings.Contains(body, "-two"))
+	is.True(!strings.Contains(body, "docs/b.txt"))
+
+	// Diff against another revision
+	status, body = get("/diff/" + first + "/a.txt?base=latest")
+	is.Equal(status, http.StatusOK)
+	is.True(strings.Contains(body, "-2"))
+	is.True(strings.Contains(body, "&#43;two"))
+}
+
+func TestFileHistory(t *testing.T) {
+	is := is.New(t)
+	log := logs.Discard()
+	chky := chunky.New(log)
+	ctx := context.Background()
+
+	repo := &countingRepo{Repo: local.New(virt.OS(t.TempDir())), downloads: map[string]int{}}
+	uploads := []virt.Tree{
+		{"config/app.yaml": &virt.File{Data: []byte("a"), Mode: 0644}},
+		{"config/app.yaml": &virt.File{Data: []byte("bb"), Mode: 0644}},
+		{"config/app.yaml": &virt.File{Data: []byte("bb"), Mode: 0644}, "other.txt": &virt.File{Data: []byte("x"), Mode: 0644}},
+		{"other.txt": &virt.File{Data: []byte("x"), Mode: 0644}},
+		{"config/app.yaml": &virt.File{Data: []byte("ccc"), Mode: 0644}},
+	}
+	for i, tree := range uploads {
+		if i > 0 {
+			// Commit IDs have second precision
+			time.Sleep(time.Second)
+		}
+		err := chky.Upload(ctx, &chunky.Upload{
+			From:  tree,
+			To:    repo,
+			Cache: virt.OS(t.TempDir()),
+		})
+		is.NoErr(err)
+	}
+	history, err := chky.Log(ctx, &chunky.Log{Repo: repo, Revision: "latest"})
+	is.NoErr(err)
+	is.Equal(len(history), 5)
+
+	cache := virt.OS(t.TempDir())
+	changes, err := chky.FileHistory(ctx, &chunky.FileHistory{
+		Repo:     repo,
+		Revision: "latest",
+		Path:     "/config/app.yaml",
+		Cache:    cache,
+	})
+	is.NoErr(err)
+	is.Equal(len(changes), 4)
+	is.Equal(changes[0].Commit.ID(), history[0].ID())
+	is.Equal(changes[0].Status, "added")
+	is.Equal(changes[0].File.Size, uint64(3))
+	is.Equal(changes[1].Commit.ID(), history[1].ID())
+	is.Equal(changes[1].Status, "removed")
+	is.Equal(changes[1].File, nil)
+	is.Equal(changes[2].Commit.ID(), history[3].ID())
+	is.Equal(changes[2].Status, "modified")
+	is.Equal(changes[2].File.Size, uint64(2))
+	is.Equal(changes[3].Commit.ID(), history[4].ID())
+	is.Equal(changes[3].Status, "added")
+
+	// Start from an older revision
+	changes, err = chky.FileHistory(ctx, &chunky.FileHistory{
+		Repo:     repo,
+		Revision: history[2].ID(),
+		Path:     "config/app.yaml",
+		Cache:    cache,
+	})
+	is.NoErr(err)
+	is.Equal(len(changes), 2)
+	is.Equal(changes[0].Commit.ID(), history[3].ID())
+
+	// Commits are read from the cache once they've been downloaded
+	repo.mu.Lock()
+	repo.downloads = map[string]int{}
+	repo.mu.Unlock()
+	changes, err = chky.FileHistory(ctx, &chunky.FileHistory{
+		Repo:     repo,
+		Revision: "latest",
+		Path:     "config/app.yaml",
+		Cache:    cache,
+	})
+	is.NoErr(err)
+	is.Equal(len(changes), 4)
+	repo.mu.Lock()
+	for _, commit := range history {
+		is.Equal(repo.downloads[path.Join("commits", commit.ID())], 0)
+	}
+	repo.mu.Unlock()
+}
+
+func TestGrep(t *testing.T) {
+	is := is.New(t)
+	log := logs.Discard()
+	chky := chunky.New(log)
+	ctx := context.Background()
+
+	// Lines in the large file cross the chunk boundaries
+	large := new(bytes.Buffer)
+	for i := 1; i <= 1000; i++ {
+		fmt.Fprintf(large, "line %d\n", i)
+	}
+	repo := local.New(virt.OS(t.TempDir()))
+	err := chky.Upload(ctx, &chunky.Upload{
+		From: virt.Tree{
+			"config/app.yaml": &virt.File{Data: []byte("name: app\nport: 8080\n"), Mode: 0644},
+			"config/db.yaml":  &virt.File{Data: []byte("host: db\r\nport: 5432"), Mode: 0644},
+			"bin.dat":         &virt.File{Data: []byte("port: 1\x00\x01"), Mode: 0644},
+			"large.txt":       &virt.File{Data: large.Bytes(), Mode: 0644},
+			"link.yaml":       &virt.File{Data: []byte("config/app.yaml"), Mode: fs.ModeSymlink | 0777},
+		},
+		To:           repo,
+		Cache:        virt.OS(t.TempDir()),
+		MinChunkSize: "512B",
+		MaxChunkSize: "1KiB",
+		MaxPackSize:  "2KiB",
+	})
+	is.NoErr(err)
+
+	// Matches are printed in order and binary files are skipped
+	out := new(bytes.Buffer)
+	err = chky.Grep(ctx, &chunky.Grep{
+		From:     repo,
+		To:       out,
+		Revision: "latest",
+		Pattern:  `^port: \d+$|^line (1|500|1000)$`,
+	})
+	is.NoErr(err)
+	is.Equal(out.String(), strings.Join([]string{
+		"config/app.yaml:2:port: 8080",
+		"config/db.yaml:2:port: 5432",
+		"large.txt:1:line 1",
+		"large.txt:500:line 500",
+		"large.txt:1000:line 1000",
+	}, "\n")+"\n")
+
+	// Limit the search to some paths and include binary files
+	out.Reset()
+	concurrency := 1
+	err = chky.Grep(ctx, &chunky.Grep{
+		From:        repo,
+		To:          out,
+		Revision:    "latest",
+		Pattern:     `port`,
+		Paths:       []string{"*.yaml", "bin.dat"},
+		Binary:      true,
+		Concurrency: &concurrency,
+	})
+	is.NoErr(err)
+	is.Equal(out.String(), "bin.dat:1:port: 1\x00\x01\nconfig/app.yaml:2:port: 8080\nconfig/db.yaml:2:port: 5432\n")
+
+	// Directories match everything within them
+	out.Reset()
+	err = chky.Grep(ctx, &chunky.Grep{
+		From:     repo,
+		To:       out,
+		Revision: "latest",
+		Pattern:  `host`,
+		Paths:    []string{"config"},
+	})
+	is.NoErr(err)
+	is.Equal(out.String(), "config/db.yaml:1:host: db\n")
+
+	err = chky.Grep(ctx, &chunky.Grep{
+		From:     repo,
+		To:       out,
+		Revision: "latest",
+		Pattern:  `(`,
+	})
+	is.True(err != nil)
+}
+
+func TestGrepLongLine(t *testing.T) {
+	is := is.New(t)
+	log := logs.Discard()
+	chky := chunky.New(log)
+	ctx := context.Background()
+
+	// Lines are cut off at 64KiB, so the end of a longer line isn't matched
+	long := strings.Repeat("x", 100*kib) + "end"
+	repo := local.New(virt.OS(t.TempDir()))
+	err := chky.Upload(ctx, &chunky.Upload{
+		From: virt.Tree{
+			"long.txt": &virt.File{Data: []byte(long + "\nend\n" + long), Mode: 0644},
+		},
+		To:           repo,
+		Cache:        virt.OS(t.TempDir()),
+		MinChunkSize: "512B",
+		MaxChunkSize: "1KiB",
+	})
+	is.NoErr(err)
+	out := new(bytes.Buffer)
+	err = chky.Grep(ctx, &chunky.Grep{
+		From:     repo,
+		To:       out,
+		Revision: "latest",
+		Pattern:  `end`,
+	})
+	is.NoErr(err)
+	is.Equal(out.String(), "long.txt:2:end\n")
+
+	out.Reset()
+	err = chky.Grep(ctx, &chunky.Grep{
+		From:     repo,
+		To:       out,
+		Revision: "latest",
+		Pattern:  `^x+$`,
+	})
+	is.NoErr(err)
+	prefix := strings.Repeat("x", 64*kib)
+	is.Equal(out.String(), "long.txt:1:"+prefix+"\nlong.txt:3:"+prefix+"\n")
+}
@@ -0,0 +1,422 @@
+package chunky
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/matthewmueller/chunky/internal/commits"
+	"github.com/matthewmueller/chunky/internal/packs"
+	"github.com/matthewmueller/chunky/internal/signatures"
+	"github.com/matthewmueller/chunky/internal/singleflight"
+	"github.com/matthewmueller/chunky/internal/tags"
+	"github.com/matthewmueller/chunky/repos"
+	"github.com/matthewmueller/logs"
+	"golang.org/x/sync/errgroup"
+)
+
+type Copy struct {
+	From repos.Repo
+	To   repos.Repo
+
+	// Revisions to copy along with their history (default: latest). Revisions
+	// that are tags copy the tag too.
+	Revisions []string
+
+	// All mirrors every commit and tag, along with the tag policy if the
+	// destination doesn't have one
+	All bool
+
+	// User copying the tags, checked against the destination's tag policy
+	// (default: current user)
+	User string
+	// Force copies tags even if the destination's policy protects them. The
+	// override is appended to the destination's tag history instead of
+	// replacing it.
+	Force bool
+
+	// Concurrency is the number of packs to copy concurrently (default: num cpus * 2)
+	Concurrency *int
+	concurrency int
+}
+
+func (in *Copy) validate() (err error) {
+	if in.From == nil {
+		err = errors.Join(err, errors.New("missing 'from' repository"))
+	}
+	if in.To == nil {
+		err = errors.Join(err, errors.New("missing 'to' repository"))
+	}
+	if in.All && len(in.Revisions) > 0 {
+		err = errors.Join(err, errors.New("cannot copy all and specific revisions"))
+	}
+
+	// Default to the latest revision
+	if !in.All && len(in.Revisions) == 0 {
+		in.Revisions = []string{"latest"}
+	}
+	if err2 := defaultUser(&in.User); err2 != nil {
+		err = errors.Join(err, err2)
+	}
+
+	// Set the concurrency if provided
+	if in.Concurrency != nil {
+		in.concurrency = *in.Concurrency
+		if in.concurrency <= 0 {
+			err = errors.Join(err, errors.New("invalid concurrency"))
+		}
+	} else {
+		in.concurrency = DefaultConcurrency
+	}
+
+	return err
+}
+
+// Copy commits along with their packs and tags from one repository to another.
+// Commits and packs the destination already has are skipped, so copying again
+// only transfers what's new. Tags replace the destination's when its tag policy
+// allows it.
+func (c *Client) Copy(ctx context.Context, in *Copy) error {
+	if err := in.validate(); err != nil {
+		return err
+	}
+
+	copier := newCopier(c.log, in.From, in.To)
+	if err := copier.listDestination(ctx); err != nil {
+		return err
+	}
+	if err := copier.protectTags(ctx, in.User, in.Force); err != nil {
+		return err
+	}
+	return copier.copy(ctx, in.All, in.Revisions, in.concurrency)
+}
+
+func newCopier(log *slog.Logger, from, to repos.Repo) *copier {
+	return &copier{
+		log:  logs.Scope(log),
+		from: from,
+		to:   to,
+		have: map[string]bool{},
+	}
+}
+
+// copier copies objects between repositories, keeping track of what the
+// destination already has
+type copier struct {
+	log   *slog.Logger
+	from  repos.Repo
+	to    repos.Repo
+	group singleflight.Group[string, struct{}]
+
+	mu   sync.Mutex
+	have map[string]bool
+
+	// policy of the destination that tag changes are checked against. Tags
+	// aren't checked without one.
+	policy *tags.Policy
+	user   string
+	force  bool
+}
+
+// Find the commits and packs that the destination already has
+func (c *copier) listDestination(ctx context.Context) error {
+	for _, dir := range []string{"commits", "packs"} {
+		if err := c.list(ctx, c.to, dir, c.add); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Check tag changes against the destination's tag policy
+func (c *copier) protectTags(ctx context.Context, user string, force bool) error {
+	policy, err := tags.ReadPolicy(ctx, c.to)
+	if err != nil {
+		return err
+	}
+	c.policy = policy
+	c.user = user
+	c.force = force
+	return nil
+}
+
+// Copy every commit, tag and the tag policy or just the history of some
+// revisions along with the tags they name
+func (c *copier) copy(ctx context.Context, all bool, revisions []string, concurrency int) error {
+	// Find the commits and tags to copy
+	var commitIds, tagNames []string
+	if all {
+		if err := c.list(ctx, c.from, "commits", func(fpath string) {
+			if !c.has(fpath) {
+				commitIds = append(commitIds, path.Base(fpath))
+			}
+		}); err != nil {
+			return err
+		}
+		if err := c.list(ctx, c.from, "tags", func(fpath string) {
+			tagNames = append(tagNames, path.Base(fpath))
+		}); err != nil {
+			return err
+		}
+	} else {
+		seen := map[string]bool{}
+		for _, revision := range revisions {
+			ids, err := c.history(ctx, revision, seen)
+			if err != nil {
+				return err
+			}
+			commitIds = append(commitIds, ids...)
+			// Copy the tag when the revision is a tag
+			name, _ := tags.ParseRevision(revision)
+			if _, err := tags.Read(ctx, c.from, name); err == nil {
+				tagNames = append(tagNames, name)
+			} else if !errors.Is(err, fs.ErrNotExist) {
+				return fmt.Errorf("chunky: unable to read tag %q: %w", name, err)
+			}
+		}
+	}
+
+	// Copy the packs before the commits that refer to them
+	eg, egCtx := errgroup.WithContext(ctx)
+	eg.SetLimit(concurrency)
+	commitList := make([]*commits.Commit, len(commitIds))
+	for i, commitId := range commitIds {
+		commit, err := commits.Read(ctx, c.from, commitId)
+		if err != nil {
+			return fmt.Errorf("chunky: unable to read commit %q: %w", commitId, err)
+		}
+		commitList[i] = commit
+		for _, pack := range commit.Packs() {
+			eg.Go(func() error {
+				return c.copyPack(egCtx, pack.ID)
+			})
+		}
+	}
+	if err := eg.Wait(); err != nil {
+		return err
+	}
+
+	// Copy the commits, oldest first, so parents are copied before their children
+	sort.Slice(commitList, func(i, j int) bool {
+		return commitList[i].ID() < commitList[j].ID()
+	})
+	for _, commit := range commitList {
+		if err := c.copyFile(ctx, path.Join("commits", commit.ID())); err != nil {
+			return err
+		}
+	}
+
+	// Copy the tag policy and tags once the commits they point to are copied.
+	// The destination's own policy is never replaced.
+	if all {
+		if err := c.createFile(ctx, tags.PolicyPath); err != nil {
+			return err
+		}
+	}
+	for _, name := range tagNames {
+		if err := c.syncTag(ctx, name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *copier) has(fpath string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.have[fpath]
+}
+
+func (c *copier) add(fpath string) {
+	c.mu.Lock()
+	c.have[fpath] = true
+	c.mu.Unlock()
+}
+
+// List the files in a directory of a repo, skipping signatures
+func (c *copier) list(ctx context.Context, repo repos.Repo, dir string, fn func(fpath string)) error {
+	return repo.Walk(ctx, dir, func(fpath string, de fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return fs.SkipAll
+			}
+			return err
+		} else if de.IsDir() || signatures.Is(fpath) {
+			return nil
+		}
+		fn(fpath)
+		return nil
+	})
+}
+
+// Find the commits of a revision's history that the destination doesn't have
+func (c *copier) history(ctx context.Context, revision string, seen map[string]bool) (commitIds []string, err error) {
+	commit, err := commits.Read(ctx, c.from, revision)
+	if err != nil {
+		return nil, fmt.Errorf("chunky: unable to read revision %q: %w", revision, err)
+	}
+	for {
+		commitId := commit.ID()
+		if seen[commitId] || c.has(path.Join("commits", commitId)) {
+			return commitIds, nil
+		}
+		seen[commitId] = true
+		commitIds = append(commitIds, commitId)
+		if commit.Parent() == "" {
+			return commitIds, nil
+		}
+		if commit, err = commits.Read(ctx, c.from, commit.Parent()); err != nil {
+			return nil, fmt.Errorf("chunky: unable to read parent of %q: %w", commitId, err)
+		}
+	}
+}
+
+// Copy a pack along with the packs its files refer to. The packs it refers to
+// are copied first, so a pack in the destination always has its references,
+// even when an earlier copy was interrupted.
+func (c *copier) copyPack(ctx context.Context, packId string) error {
+	packPath := path.Join("packs", packId)
+	if c.has(packPath) {
+		return nil
+	}
+	_, err, _ := c.group.Do(packId, func() (struct{}, error) {
+		if c.has(packPath) {
+			return struct{}{}, nil
+		}
+		packFile, err := repos.Download(ctx, c.from, packPath)
+		if err != nil {
+			return struct{}{}, fmt.Errorf("chunky: unable to download pack %q: %w", packId, err)
+		}
+		pack, err := packs.Unpack(packFile.Data)
+		if err != nil {
+			return struct{}{}, fmt.Errorf("chunky: unable to unpack pack %q: %w", packId, err)
+		}
+		for _, chunk := range pack.Chunks() {
+			for _, ref := range chunk.Refs {
+				if ref.Hole > 0 || ref.Pack == packId {
+					continue
+				}
+				if err := c.copyPack(ctx, ref.Pack); err != nil {
+					return struct{}{}, err
+				}
+			}
+		}
+		if err := repos.Upload(ctx, c.to, packFile); err != nil {
+			return struct{}{}, err
+		}
+		c.add(packPath)
+		c.log.Debug("copied pack", slog.String("pack", packId), slog.Int("size", len(packFile.Data)))
+		return struct{}{}, nil
+	})
+	return err
+}
+
+// Copy a file along with its signature, if it has one. The signature is
+// copied first, so the file isn't considered copied without it. A stale
+// signature is removed when the file isn't signed and the repository can
+// remove it. Otherwise it no longer verifies.
+func (c *copier) copyFile(ctx context.Context, fpath string) error {
+	sigPath := signatures.Path(fpath)
+	sigFile, err := repos.Download(ctx, c.from, sigPath)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("chunky: unable to download signature %q: %w", sigPath, err)
+		}
+		if err := repos.Remove(ctx, c.to, sigPath); err != nil && !errors.Is(err, fs.ErrNotExist) && !errors.Is(err, errors.ErrUnsupported) {
+			return fmt.Errorf("chunky: unable to remove signature %q: %w", sigPath, err)
+		}
+	} else if err := repos.Upload(ctx, c.to, sigFile); err != nil {
+		return err
+	}
+	file, err := repos.Download(ctx, c.from, fpath)
+	if err != nil {
+		return fmt.Errorf("chunky: unable to download %q: %w", fpath, err)
+	}
+	if err := repos.Upload(ctx, c.to, file); err != nil {
+		return err
+	}
+	c.log.Debug("copied file", slog.String("path", fpath))
+	return nil
+}
+
+// Create a file in the destination if it doesn't have one already. Files
+// missing from the source are left alone.
+func (c *copier) createFile(ctx context.Context, fpath string) error {
+	if _, err := repos.Download(ctx, c.to, fpath); err == nil {
+		return nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("chunky: unable to download %q: %w", fpath, err)
+	}
+	if _, err := repos.Download(ctx, c.from, fpath); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("chunky: unable to download %q: %w", fpath, err)
+	}
+	return c.copyFile(ctx, fpath)
+}
+
+// Sync a tag, replacing the destination's copy when it differs and the
+// destination's policy allows the change. Forced changes that the policy
+// doesn't allow are appended to the destination's history, so the override is
+// recorded. Tags missing from the source are left alone.
+func (c *copier) syncTag(ctx context.Context, name string) error {
+	fpath := path.Join("tags", name)
+	file, err := repos.Download(ctx, c.from, fpath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("chunky: unable to download %q: %w", fpath, err)
+	}
+	current := &tags.Tag{Name: name}
+	existing, err := repos.Download(ctx, c.to, fpath)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("chunky: unable to download %q: %w", fpath, err)
+		}
+	} else if bytes.Equal(existing.Data, file.Data) {
+		return nil
+	} else if current, err = tags.Parse(name, existing.Data); err != nil {
+		return err
+	}
+	if c.policy == nil {
+		return c.copyFile(ctx, fpath)
+	}
+	tag, err := tags.Parse(name, file.Data)
+	if err != nil {
+		return err
+	}
+
+	// Adding to the tag's history is a move, anything else rewrites it
+	op := tags.OpMove
+	if !tag.Extends(current) {
+		op = tags.OpReplace
+	}
+	if err := c.policy.Check(current, op, c.user); err != nil {
+		if !c.force {
+			return fmt.Errorf("chunky: unable to copy tag %q: %w", name, err)
+		}
+		c.log.Warn("overriding tag protection",
+			slog.String("tag", name),
+			slog.String("op", string(op)),
+			slog.String("user", c.user),
+		)
+		entry := current.Move(tag.Newest(), c.user, time.Now().UTC())
+		entry.Forced = true
+		// The source's signature doesn't cover the forced entry
+		if err := removeTagSignature(ctx, c.to, name); err != nil {
+			return err
+		}
+		return repos.Upload(ctx, c.to, current.File())
+	}
+	return c.copyFile(ctx, fpath)
+}
@@ -2,6 +2,7 @@ package chunky
 
 import (
 	"context"
+	"crypto/ed25519"
 	"errors"
 
 	"github.com/dustin/go-humanize"
@@ -15,6 +16,9 @@ import (
 // DefaultMaxCacheSize is the default maximum size of the LRU for caching packs
 const DefaultMaxCacheSize = 512 * miB // 512 MiB
 
+// DefaultMaxCacheDirSize is the default maximum size of the packs cached on disk
+const DefaultMaxCacheDirSize = 2 * giB // 2 GiB
+
 type Download struct {
 	From     repos.Repo
 	To       repos.FS
@@ -24,6 +28,15 @@ type Download struct {
 	MaxCacheSize string
 	maxCacheSize int
 
+	// CacheDir keeps downloaded packs on disk, so later runs don't download them
+	// again. Packs are cached by their ID, so each repository needs its own
+	// directory (optional)
+	CacheDir repos.FS
+
+	// MaxCacheDirSize is the maximum size of the packs in CacheDir (default: 2GiB)
+	MaxCacheDirSize string
+	maxCacheDirSize int
+
 	// LimitDownload is the maximum download speed per second (default: unlimited)
 	LimitDownload string
 	limitDownload int
@@ -31,6 +44,26 @@ type Download struct {
 	// Concurrency is the number of concurrent downloads (default: num cpus * 2)
 	Concurrency *int
 	concurrency int
+
+	// PreserveModTime restores the modification times recorded in the commit
+	// (default: true)
+	PreserveModTime *bool
+	preserveModTime bool
+
+	// PreserveOwner restores the ownership recorded in the commit. This requires
+	// sufficient privileges, otherwise files are owned by the current user.
+	PreserveOwner bool
+
+	// NumericOwner restores the recorded uid and gid rather than looking up the
+	// user and group names
+	NumericOwner bool
+
+	// PreserveXattrs restores the extended attributes recorded in the commit
+	PreserveXattrs bool
+
+	// TrustedKeys, when set, refuse revisions that aren't signed by one of the
+	// keys
+	TrustedKeys []ed25519.PublicKey
 }
 
 func (in *Download) validate() (err error) {
@@ -56,6 +89,17 @@ func (in *Download) validate() (err error) {
 		in.maxCacheSize = DefaultMaxCacheSize
 	}
 
+	if in.MaxCacheDirSize != "" {
+		maxCacheDirSize, err2 := humanize.ParseBytes(in.MaxCacheDirSize)
+		if err2 != nil {
+			err = errors.Join(err, errors.New("invalid max cache dir size"))
+		} else {
+			in.maxCacheDirSize = int(maxCacheDirSize)
+		}
+	} else {
+		in.maxCacheDirSize = DefaultMaxCacheDirSize
+	}
+
 	if in.LimitDownload != "" {
 		limitDownload, err2 := humanize.ParseBytes(in.LimitDownload)
 		if err2 != nil {
@@ -78,6 +122,9 @@ func (in *Download) validate() (err error) {
 		in.concurrency = DefaultConcurrency
 	}
 
+	// Preserve modification times unless told otherwise
+	in.preserveModTime = in.PreserveModTime == nil || *in.PreserveModTime
+
 	return err
 }
 
@@ -88,15 +135,29 @@ func (c *Client) Download(ctx context.Context, in *Download) error {
 	}
 
 	pr := packs.NewCachedReader(c.log, lru.New[*packs.Pack](c.log, in.maxCacheSize))
+	if in.CacheDir != nil {
+		pr.Disk = packs.NewDiskCache(c.log, in.CacheDir, in.maxCacheDirSize)
+	}
 	if in.limitDownload > 0 {
 		pr.Limiter = rate.New(in.limitDownload)
 	}
 
-	downloader := downloads.New(pr)
+	downloader := downloads.New(c.log, pr)
 	if in.concurrency > 0 {
 		downloader.Concurrency = in.concurrency
 	}
-
-	// Download the repo
+	downloader.PreserveModTime = in.preserveModTime
+	downloader.PreserveOwner = in.PreserveOwner
+	downloader.NumericOwner = in.NumericOwner
+	downloader.PreserveXattrs = in.PreserveXattrs
+
+	// Download the repo, verifying the revision first if we have trusted keys
+	if len(in.TrustedKeys) > 0 {
+		commit, err := verifyRevision(ctx, in.From, in.Revision, in.TrustedKeys)
+		if err != nil {
+			return err
+		}
+		return downloader.DownloadCommit(ctx, in.From, commit, in.To)
+	}
 	return downloader.Download(ctx, in.From, in.Revision, in.To)
 }
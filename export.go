@@ -0,0 +1,152 @@
+package chunky
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"io"
+
+	"github.com/dustin/go-humanize"
+	"github.com/matthewmueller/chunky/internal/archives"
+	"github.com/matthewmueller/chunky/internal/downloads"
+	"github.com/matthewmueller/chunky/internal/lru"
+	"github.com/matthewmueller/chunky/internal/packs"
+	"github.com/matthewmueller/chunky/internal/rate"
+	"github.com/matthewmueller/chunky/repos"
+)
+
+type Export struct {
+	From     repos.Repo
+	To       io.Writer
+	Revision string
+
+	// Format of the archive: tar, tar.gz, tar.zst or zip (default: tar)
+	Format string
+	format archives.Format
+
+	// Paths limit the export to these files and directories (default: all)
+	Paths []string
+
+	// MaxCacheSize is the maximum size of the LRU for caching packs (default: 512MiB)
+	MaxCacheSize string
+	maxCacheSize int
+
+	// CacheDir keeps downloaded packs on disk, so later runs don't download them
+	// again. Packs are cached by their ID, so each repository needs its own
+	// directory (optional)
+	CacheDir repos.FS
+
+	// MaxCacheDirSize is the maximum size of the packs in CacheDir (default: 2GiB)
+	MaxCacheDirSize string
+	maxCacheDirSize int
+
+	// LimitDownload is the maximum download speed per second (default: unlimited)
+	LimitDownload string
+	limitDownload int
+
+	// TrustedKeys, when set, refuse revisions that aren't signed by one of the
+	// keys
+	TrustedKeys []ed25519.PublicKey
+}
+
+func (in *Export) validate() (err error) {
+	// Required fields
+	if in.From == nil {
+		err = errors.Join(err, errors.New("missing 'from' repository"))
+	}
+	if in.To == nil {
+		err = errors.Join(err, errors.New("missing 'to'"))
+	}
+	if in.Revision == "" {
+		err = errors.Join(err, errors.New("missing 'revision'"))
+	}
+
+	if in.Format != "" {
+		format, err2 := archives.ParseFormat(in.Format)
+		if err2 != nil {
+			err = errors.Join(err, errors.New("invalid format"))
+		} else {
+			in.format = format
+		}
+	} else {
+		in.format = archives.Tar
+	}
+
+	if in.MaxCacheSize != "" {
+		maxCacheSize, err2 := humanize.ParseBytes(in.MaxCacheSize)
+		if err2 != nil {
+			err = errors.Join(err, errors.New("invalid max cache size"))
+		} else {
+			in.maxCacheSize = int(maxCacheSize)
+		}
+	} else {
+		in.maxCacheSize = 512 * miB
+	}
+
+	if in.MaxCacheDirSize != "" {
+		maxCacheDirSize, err2 := humanize.ParseBytes(in.MaxCacheDirSize)
+		if err2 != nil {
+			err = errors.Join(err, errors.New("invalid max cache dir size"))
+		} else {
+			in.maxCacheDirSize = int(maxCacheDirSize)
+		}
+	} else {
+		in.maxCacheDirSize = DefaultMaxCacheDirSize
+	}
+
+	if in.LimitDownload != "" {
+		limitDownload, err2 := humanize.ParseBytes(in.LimitDownload)
+		if err2 != nil {
+			err = errors.Join(err, errors.New("invalid limit download"))
+		} else {
+			in.limitDownload = int(limitDownload)
+		}
+	} else {
+		in.limitDownload = 0
+	}
+
+	return err
+}
+
+// Export a revision as a tar or zip archive. Files are written with their
+// modes, modification times and symlinks, streaming straight from the packs.
+func (c *Client) Export(ctx context.Context, in *Export) error {
+	if err := in.validate(); err != nil {
+		return err
+	}
+
+	// Create a cached pack reader with the specified max cache size
+	pr := packs.NewCachedReader(c.log, lru.New[*packs.Pack](c.log, in.maxCacheSize))
+	if in.CacheDir != nil {
+		pr.Disk = packs.NewDiskCache(c.log, in.CacheDir, in.maxCacheDirSize)
+	}
+
+	// Set the download limit if provided
+	if in.LimitDownload != "" {
+		pr.Limiter = rate.New(in.limitDownload)
+	}
+
+	download := downloads.New(c.log, pr)
+
+	archive, err := archives.New(in.To, in.format)
+	if err != nil {
+		return err
+	}
+
+	// Verify the revision first if we have trusted keys
+	if len(in.TrustedKeys) > 0 {
+		commit, err := verifyRevision(ctx, in.From, in.Revision, in.TrustedKeys)
+		if err != nil {
+			return err
+		}
+		if err := download.ExportCommit(ctx, in.From, commit, archive, in.Paths...); err != nil {
+			return err
+		}
+		return archive.Close()
+	}
+
+	if err := download.Export(ctx, in.From, in.Revision, archive, in.Paths...); err != nil {
+		return err
+	}
+	return archive.Close()
+}
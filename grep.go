@@ -0,0 +1,176 @@
+package chunky
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+
+	"github.com/dustin/go-humanize"
+	"github.com/matthewmueller/chunky/internal/downloads"
+	"github.com/matthewmueller/chunky/internal/lru"
+	"github.com/matthewmueller/chunky/internal/packs"
+	"github.com/matthewmueller/chunky/internal/rate"
+	"github.com/matthewmueller/chunky/repos"
+)
+
+type Grep struct {
+	From     repos.Repo
+	To       io.Writer
+	Revision string
+
+	// Pattern is a regular expression in Go's syntax
+	Pattern string
+	pattern *regexp.Regexp
+
+	// Paths limit the search to the files and directories matching these globs.
+	// Globs without a slash also match file names. (default: all)
+	Paths []string
+
+	// Binary searches binary files too, which are skipped by default
+	Binary bool
+
+	// MaxCacheSize is the maximum size of the LRU for caching packs (default: 512MiB)
+	MaxCacheSize string
+	maxCacheSize int
+
+	// CacheDir keeps downloaded packs on disk, so later runs don't download them
+	// again. Packs are cached by their ID, so each repository needs its own
+	// directory (optional)
+	CacheDir repos.FS
+
+	// MaxCacheDirSize is the maximum size of the packs in CacheDir (default: 2GiB)
+	MaxCacheDirSize string
+	maxCacheDirSize int
+
+	// LimitDownload is the maximum download speed per second (default: unlimited)
+	LimitDownload string
+	limitDownload int
+
+	// Concurrency is the number of concurrent downloads (default: num cpus * 2)
+	Concurrency *int
+	concurrency int
+
+	// TrustedKeys, when set, refuse revisions that aren't signed by one of the
+	// keys
+	TrustedKeys []ed25519.PublicKey
+}
+
+func (in *Grep) validate() (err error) {
+	// Required fields
+	if in.From == nil {
+		err = errors.Join(err, errors.New("missing 'from' repository"))
+	}
+	if in.To == nil {
+		err = errors.Join(err, errors.New("missing 'to'"))
+	}
+	if in.Revision == "" {
+		err = errors.Join(err, errors.New("missing 'revision'"))
+	}
+	if in.Pattern == "" {
+		err = errors.Join(err, errors.New("missing 'pattern'"))
+	} else if pattern, err2 := regexp.Compile(in.Pattern); err2 != nil {
+		err = errors.Join(err, fmt.Errorf("invalid pattern: %w", err2))
+	} else {
+		in.pattern = pattern
+	}
+
+	for _, glob := range in.Paths {
+		if _, err2 := path.Match(glob, ""); err2 != nil {
+			err = errors.Join(err, fmt.Errorf("invalid path %q", glob))
+		}
+	}
+
+	if in.MaxCacheSize != "" {
+		maxCacheSize, err2 := humanize.ParseBytes(in.MaxCacheSize)
+		if err2 != nil {
+			err = errors.Join(err, errors.New("invalid max cache size"))
+		} else {
+			in.maxCacheSize = int(maxCacheSize)
+		}
+	} else {
+		in.maxCacheSize = 512 * miB
+	}
+
+	if in.MaxCacheDirSize != "" {
+		maxCacheDirSize, err2 := humanize.ParseBytes(in.MaxCacheDirSize)
+		if err2 != nil {
+			err = errors.Join(err, errors.New("invalid max cache dir size"))
+		} else {
+			in.maxCacheDirSize = int(maxCacheDirSize)
+		}
+	} else {
+		in.maxCacheDirSize = DefaultMaxCacheDirSize
+	}
+
+	if in.LimitDownload != "" {
+		limitDownload, err2 := humanize.ParseBytes(in.LimitDownload)
+		if err2 != nil {
+			err = errors.Join(err, errors.New("invalid limit download"))
+		} else {
+			in.limitDownload = int(limitDownload)
+		}
+	} else {
+		in.limitDownload = 0
+	}
+
+	// Set the concurrency if provided
+	if in.Concurrency != nil {
+		in.concurrency = *in.Concurrency
+		// Disallow "unlimited" concurrency for now
+		if in.concurrency <= 0 {
+			err = errors.Join(err, errors.New("invalid concurrency"))
+		}
+	} else {
+		in.concurrency = DefaultConcurrency
+	}
+
+	return err
+}
+
+// Grep searches the files in a revision for lines that match a pattern,
+// writing each match as "path:line:text". File data is streamed from the packs
+// without writing the files anywhere.
+func (c *Client) Grep(ctx context.Context, in *Grep) error {
+	if err := in.validate(); err != nil {
+		return err
+	}
+
+	// Create a cached pack reader with the specified max cache size
+	pr := packs.NewCachedReader(c.log, lru.New[*packs.Pack](c.log, in.maxCacheSize))
+	if in.CacheDir != nil {
+		pr.Disk = packs.NewDiskCache(c.log, in.CacheDir, in.maxCacheDirSize)
+	}
+
+	// Set the download limit if provided
+	if in.LimitDownload != "" {
+		pr.Limiter = rate.New(in.limitDownload)
+	}
+
+	download := downloads.New(c.log, pr)
+	download.Concurrency = in.concurrency
+
+	grep := &downloads.Grep{
+		Pattern: in.pattern,
+		Globs:   in.Paths,
+		Binary:  in.Binary,
+	}
+	found := func(m *downloads.Match) error {
+		_, err := fmt.Fprintf(in.To, "%s:%d:%s\n", m.Path, m.Line, m.Text)
+		return err
+	}
+
+	// Verify the revision first if we have trusted keys
+	if len(in.TrustedKeys) > 0 {
+		commit, err := verifyRevision(ctx, in.From, in.Revision, in.TrustedKeys)
+		if err != nil {
+			return err
+		}
+		return download.GrepCommit(ctx, in.From, commit, grep, found)
+	}
+
+	return download.Grep(ctx, in.From, in.Revision, grep, found)
+}
@@ -0,0 +1,216 @@
+package archives
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Format of an archive
+type Format string
+
+const (
+	Tar    Format = "tar"
+	TarGz  Format = "tar.gz"
+	TarZst Format = "tar.zst"
+	Zip    Format = "zip"
+)
+
+// ErrUnsupported is returned when an entry can't be stored in an archive's
+// format, such as devices in zip archives
+var ErrUnsupported = errors.New("archives: unsupported entry")
+
+// ParseFormat parses an archive format
+func ParseFormat(format string) (Format, error) {
+	switch f := Format(format); f {
+	case Tar, TarGz, TarZst, Zip:
+		return f, nil
+	case "tgz":
+		return TarGz, nil
+	default:
+		return "", fmt.Errorf("archives: unknown format %q", format)
+	}
+}
+
+// FormatOf returns the format of an archive from its file name. Ok is false if
+// the file name doesn't have a known archive extension.
+func FormatOf(name string) (format Format, ok bool) {
+	switch {
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return TarGz, true
+	case strings.HasSuffix(name, ".tar.zst"):
+		return TarZst, true
+	case strings.HasSuffix(name, ".tar"):
+		return Tar, true
+	case strings.HasSuffix(name, ".zip"):
+		return Zip, true
+	default:
+		return "", false
+	}
+}
+
+// Entry is a file, directory, link or device in an archive
+type Entry struct {
+	Path    string
+	Mode    fs.FileMode
+	Size    int64
+	ModTime time.Time
+	// Link is the target of a symlink or hard link
+	Link string
+	// HardLink is true when the entry is a hard link to the Link path
+	HardLink bool
+	// Major and minor device numbers
+	Major uint32
+	Minor uint32
+	// Ownership, when it was recorded
+	Uid   int
+	Gid   int
+	User  string
+	Group string
+}
+
+// Writer writes entries to an archive
+type Writer interface {
+	// Create an entry, returning a writer for its data. Nothing should be
+	// written for entries without data, such as directories and links.
+	Create(entry *Entry) (io.Writer, error)
+	// Close finishes the archive without closing the underlying writer
+	Close() error
+}
+
+// New creates an archive writer in a format
+func New(w io.Writer, format Format) (Writer, error) {
+	switch format {
+	case Tar:
+		return &tarWriter{tw: tar.NewWriter(w)}, nil
+	case TarGz:
+		gz := gzip.NewWriter(w)
+		return &tarWriter{tw: tar.NewWriter(gz), compressor: gz}, nil
+	case TarZst:
+		zw, err := zstd.NewWriter(w)
+		if err != nil {
+			return nil, err
+		}
+		return &tarWriter{tw: tar.NewWriter(zw), compressor: zw}, nil
+	case Zip:
+		return &zipWriter{zw: zip.NewWriter(w)}, nil
+	default:
+		return nil, fmt.Errorf("archives: unknown format %q", format)
+	}
+}
+
+type tarWriter struct {
+	tw         *tar.Writer
+	compressor io.WriteCloser
+}
+
+func (t *tarWriter) Create(entry *Entry) (io.Writer, error) {
+	header := &tar.Header{
+		Name:    entry.Path,
+		Mode:    int64(entry.Mode.Perm()),
+		ModTime: entry.ModTime,
+		Uid:     entry.Uid,
+		Gid:     entry.Gid,
+		Uname:   entry.User,
+		Gname:   entry.Group,
+		Format:  tar.FormatPAX,
+	}
+	if entry.Mode&fs.ModeSetuid != 0 {
+		header.Mode |= 04000
+	}
+	if entry.Mode&fs.ModeSetgid != 0 {
+		header.Mode |= 02000
+	}
+	if entry.Mode&fs.ModeSticky != 0 {
+		header.Mode |= 01000
+	}
+	switch mode := entry.Mode; {
+	case entry.HardLink:
+		header.Typeflag = tar.TypeLink
+		header.Linkname = entry.Link
+	case mode.IsDir():
+		header.Typeflag = tar.TypeDir
+		header.Name += "/"
+	case mode&fs.ModeSymlink != 0:
+		header.Typeflag = tar.TypeSymlink
+		header.Linkname = entry.Link
+	case mode&fs.ModeNamedPipe != 0:
+		header.Typeflag = tar.TypeFifo
+	case mode&fs.ModeCharDevice != 0:
+		header.Typeflag = tar.TypeChar
+		header.Devmajor, header.Devminor = int64(entry.Major), int64(entry.Minor)
+	case mode&fs.ModeDevice != 0:
+		header.Typeflag = tar.TypeBlock
+		header.Devmajor, header.Devminor = int64(entry.Major), int64(entry.Minor)
+	case mode.IsRegular():
+		header.Typeflag = tar.TypeReg
+		header.Size = entry.Size
+	default:
+		return nil, fmt.Errorf("%w: %q with mode %s", ErrUnsupported, entry.Path, entry.Mode)
+	}
+	if err := t.tw.WriteHeader(header); err != nil {
+		return nil, fmt.Errorf("archives: unable to write header for %q: %w", entry.Path, err)
+	}
+	return t.tw, nil
+}
+
+func (t *tarWriter) Close() error {
+	if err := t.tw.Close(); err != nil {
+		return err
+	}
+	if t.compressor != nil {
+		return t.compressor.Close()
+	}
+	return nil
+}
+
+type zipWriter struct {
+	zw *zip.Writer
+}
+
+// Create an entry in the zip archive. Zip archives don't have hard links or
+// devices, so hard links are stored as copies by the caller.
+func (z *zipWriter) Create(entry *Entry) (io.Writer, error) {
+	header := &zip.FileHeader{
+		Name:     entry.Path,
+		Method:   zip.Deflate,
+		Modified: entry.ModTime,
+	}
+	switch mode := entry.Mode; {
+	case entry.HardLink:
+		return nil, fmt.Errorf("%w: hard link %q", ErrUnsupported, entry.Path)
+	case mode.IsDir():
+		header.Name += "/"
+		header.Method = zip.Store
+	case mode&fs.ModeSymlink != 0:
+		header.Method = zip.Store
+	case mode.IsRegular():
+	default:
+		return nil, fmt.Errorf("%w: %q with mode %s", ErrUnsupported, entry.Path, entry.Mode)
+	}
+	header.SetMode(entry.Mode)
+	w, err := z.zw.CreateHeader(header)
+	if err != nil {
+		return nil, fmt.Errorf("archives: unable to write header for %q: %w", entry.Path, err)
+	}
+	// Symlinks store their target as their data
+	if entry.Mode&fs.ModeSymlink != 0 {
+		if _, err := io.WriteString(w, entry.Link); err != nil {
+			return nil, fmt.Errorf("archives: unable to write symlink %q: %w", entry.Path, err)
+		}
+		return io.Discard, nil
+	}
+	return w, nil
+}
+
+func (z *zipWriter) Close() error {
+	return z.zw.Close()
+}
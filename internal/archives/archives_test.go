@@ -0,0 +1,231 @@
+package archives_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"github.com/matthewmueller/chunky/internal/archives"
+	"github.com/matthewmueller/chunky/repos"
+)
+
+// Write an archive with a directory, a file, a symlink and a file with a long
+// name that needs a PAX header
+func writeArchive(t *testing.T, format archives.Format, modTime time.Time) []byte {
+	t.Helper()
+	is := is.New(t)
+	buf := new(bytes.Buffer)
+	aw, err := archives.New(buf, format)
+	is.NoErr(err)
+	longName := "docs/" + strings.Repeat("x", 150) + ".txt"
+	entries := []struct {
+		entry *archives.Entry
+		data  string
+	}{
+		{&archives.Entry{Path: "docs", Mode: fs.ModeDir | 0750, ModTime: modTime}, ""},
+		{&archives.Entry{Path: "docs/a.txt", Mode: 0600, Size: 1, ModTime: modTime}, "a"},
+		{&archives.Entry{Path: longName, Mode: 0644, Size: 4, ModTime: modTime}, "long"},
+		{&archives.Entry{Path: "link.txt", Mode: fs.ModeSymlink | 0777, ModTime: modTime, Link: "docs/a.txt"}, ""},
+		{&archives.Entry{Path: "nested/b.txt", Mode: 0755, Size: 1, ModTime: modTime}, "b"},
+	}
+	for _, e := range entries {
+		w, err := aw.Create(e.entry)
+		is.NoErr(err)
+		_, err = io.WriteString(w, e.data)
+		is.NoErr(err)
+	}
+	is.NoErr(aw.Close())
+	return buf.Bytes()
+}
+
+// Read the regular files in an archive, streaming it if it's streamed
+func readFiles(t *testing.T, fsys *archives.FS) (files map[string]string, streamed bool) {
+	t.Helper()
+	is := is.New(t)
+	files = map[string]string{}
+	readFile := func(fpath string, info fs.FileInfo) error {
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		data, err := fs.ReadFile(fsys, fpath)
+		files[fpath] = string(data)
+		return err
+	}
+	err := fsys.Stream(readFile)
+	if errors.Is(err, errors.ErrUnsupported) {
+		err = fs.WalkDir(fsys, ".", func(fpath string, de fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			info, err := de.Info()
+			if err != nil {
+				return err
+			}
+			return readFile(fpath, info)
+		})
+		is.NoErr(err)
+		return files, false
+	}
+	is.NoErr(err)
+	return files, true
+}
+
+func TestReadFS(t *testing.T) {
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	longName := "docs/" + strings.Repeat("x", 150) + ".txt"
+	for _, format := range []archives.Format{archives.Tar, archives.TarGz, archives.TarZst, archives.Zip} {
+		t.Run(string(format), func(t *testing.T) {
+			is := is.New(t)
+			data := writeArchive(t, format, modTime)
+
+			// Read from a file and from a stream
+			fpath := filepath.Join(t.TempDir(), "archive."+string(format))
+			is.NoErr(os.WriteFile(fpath, data, 0644))
+			fromFile, err := archives.Open(fpath)
+			is.NoErr(err)
+			defer fromFile.Close()
+			fromStream, err := archives.Read(io.MultiReader(bytes.NewReader(data)))
+			is.NoErr(err)
+			defer fromStream.Close()
+
+			for _, fsys := range []*archives.FS{fromFile, fromStream} {
+				files, streamed := readFiles(t, fsys)
+				is.Equal(files["docs/a.txt"], "a")
+				is.Equal(files[longName], "long")
+				is.Equal(files["nested/b.txt"], "b")
+				// Only tarballs that can't be read in place are streamed
+				is.Equal(streamed, format != archives.Zip && (fsys == fromStream || format != archives.Tar))
+
+				stat, err := fsys.Lstat("docs")
+				is.NoErr(err)
+				is.True(stat.IsDir())
+				is.Equal(stat.Mode().Perm(), fs.FileMode(0750))
+
+				stat, err = fsys.Lstat("docs/a.txt")
+				is.NoErr(err)
+				is.Equal(stat.Mode(), fs.FileMode(0600))
+				is.Equal(stat.ModTime().Unix(), modTime.Unix())
+
+				stat, err = fsys.Lstat("link.txt")
+				is.NoErr(err)
+				is.True(stat.Mode()&fs.ModeSymlink != 0)
+				link, err := fsys.Readlink("link.txt")
+				is.NoErr(err)
+				is.Equal(link, "docs/a.txt")
+				if streamed {
+					// Streamed files can't be read again
+					_, err = fs.ReadFile(fsys, "link.txt")
+					is.True(err != nil)
+					is.True(fsys.Stream(func(string, fs.FileInfo) error { return nil }) != nil)
+				} else {
+					// Opening a symlink follows it
+					content, err := fs.ReadFile(fsys, "link.txt")
+					is.NoErr(err)
+					is.Equal(string(content), "a")
+				}
+
+				// Directories that aren't in the archive are implied
+				stat, err = fsys.Lstat("nested")
+				is.NoErr(err)
+				is.True(stat.IsDir())
+
+				var paths []string
+				err = fs.WalkDir(fsys, ".", func(fpath string, de fs.DirEntry, err error) error {
+					paths = append(paths, fpath)
+					return err
+				})
+				is.NoErr(err)
+				is.Equal(paths, []string{".", "docs", "docs/a.txt", longName, "link.txt", "nested", "nested/b.txt"})
+			}
+		})
+	}
+}
+
+func TestReadHardLink(t *testing.T) {
+	is := is.New(t)
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	is.NoErr(tw.WriteHeader(&tar.Header{Name: "a.txt", Mode: 0644, Size: 1, Typeflag: tar.TypeReg}))
+	_, err := tw.Write([]byte("a"))
+	is.NoErr(err)
+	is.NoErr(tw.WriteHeader(&tar.Header{Name: "b.txt", Mode: 0644, Linkname: "a.txt", Typeflag: tar.TypeLink}))
+	is.NoErr(tw.Close())
+
+	// Hard links share the data of the files they link to
+	fpath := filepath.Join(t.TempDir(), "links.tar")
+	is.NoErr(os.WriteFile(fpath, buf.Bytes(), 0644))
+	fromFile, err := archives.Open(fpath)
+	is.NoErr(err)
+	defer fromFile.Close()
+	content, err := fs.ReadFile(fromFile, "b.txt")
+	is.NoErr(err)
+	is.Equal(string(content), "a")
+
+	// Hard links that are streamed are identified by the file they link to
+	fromStream, err := archives.Read(bytes.NewReader(buf.Bytes()))
+	is.NoErr(err)
+	defer fromStream.Close()
+	files, streamed := readFiles(t, fromStream)
+	is.True(streamed)
+	is.Equal(files["a.txt"], "a")
+	for _, fsys := range []*archives.FS{fromFile, fromStream} {
+		a, err := fsys.Lstat("a.txt")
+		is.NoErr(err)
+		b, err := fsys.Lstat("b.txt")
+		is.NoErr(err)
+		aId, ok := repos.HardLink(a)
+		is.True(ok)
+		bId, ok := repos.HardLink(b)
+		is.True(ok)
+		is.Equal(aId, bId)
+	}
+}
+
+func TestReadDevice(t *testing.T) {
+	is := is.New(t)
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	is.NoErr(tw.WriteHeader(&tar.Header{Name: "null", Mode: 0666, Typeflag: tar.TypeChar, Devmajor: 1, Devminor: 3}))
+	is.NoErr(tw.WriteHeader(&tar.Header{Name: "sda", Mode: 0660, Typeflag: tar.TypeBlock, Devmajor: 8, Devminor: 1}))
+	is.NoErr(tw.Close())
+
+	fsys, err := archives.Read(buf)
+	is.NoErr(err)
+	defer fsys.Close()
+	readFiles(t, fsys)
+	stat, err := fsys.Lstat("null")
+	is.NoErr(err)
+	is.True(stat.Mode()&fs.ModeCharDevice != 0)
+	major, minor, ok := repos.DeviceNumber(stat)
+	is.True(ok)
+	is.Equal(major, uint32(1))
+	is.Equal(minor, uint32(3))
+	stat, err = fsys.Lstat("sda")
+	is.NoErr(err)
+	major, minor, ok = repos.DeviceNumber(stat)
+	is.True(ok)
+	is.Equal(major, uint32(8))
+	is.Equal(minor, uint32(1))
+}
+
+func TestReadUnsafePath(t *testing.T) {
+	is := is.New(t)
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	is.NoErr(tw.WriteHeader(&tar.Header{Name: "../evil.txt", Mode: 0644, Typeflag: tar.TypeReg}))
+	is.NoErr(tw.Close())
+
+	fsys, err := archives.Read(buf)
+	is.NoErr(err)
+	defer fsys.Close()
+	err = fsys.Stream(func(string, fs.FileInfo) error { return nil })
+	is.True(err != nil)
+}
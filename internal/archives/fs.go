@@ -0,0 +1,629 @@
+package archives
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/matthewmueller/chunky/repos"
+)
+
+// Magic numbers at the start of compressed streams and zip archives
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	zipMagic  = []byte("PK\x03\x04")
+	// Empty zip archives start with the end of the central directory
+	zipEmptyMagic = []byte("PK\x05\x06")
+)
+
+// maxSymlinks is the number of symlinks followed before giving up
+const maxSymlinks = 40
+
+// FS is a tar or zip archive that's read like a filesystem. Entries keep the
+// modes, modification times and symlinks recorded in the archive.
+//
+// Compressed tarballs and tarballs read from pipes are streamed rather than
+// copied anywhere. Their entries are only known once they've been streamed,
+// and each file can only be opened while it's being streamed.
+type FS struct {
+	root   *node
+	nodes  map[string]*node
+	closer func() error
+	// stream reads the entries of a streamed tarball, until it's been streamed
+	stream    *tar.Reader
+	streaming bool
+	// current is the file that's being streamed
+	mu      sync.Mutex
+	current *node
+	// nextID identifies the next regular file
+	nextID uint64
+}
+
+// node is an entry in the archive
+type node struct {
+	name    string
+	mode    fs.FileMode
+	size    int64
+	modTime time.Time
+	link    string
+	// major and minor numbers of a device
+	major uint32
+	minor uint32
+	// id is shared by a regular file and the hard links to it
+	id uint64
+	// open the data of a regular file
+	open func() (io.ReadCloser, error)
+	// streamed is true if the data is read from the stream, so it can only be
+	// opened while the file is being streamed
+	streamed bool
+	// children of a directory, sorted by name once the archive is read
+	children []*node
+	// implied directories aren't in the archive, but their children are
+	implied bool
+}
+
+// errStreamed is returned when a streamed file is opened after it's been read
+var errStreamed = errors.New("archives: file has already been streamed")
+
+// Open an archive file. The format is detected from its contents.
+func Open(fpath string) (*FS, error) {
+	file, err := os.Open(fpath)
+	if err != nil {
+		return nil, fmt.Errorf("archives: unable to open %q: %w", fpath, err)
+	}
+	fsys, err := Read(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	closer := fsys.closer
+	fsys.closer = func() error {
+		return errors.Join(closer(), file.Close())
+	}
+	return fsys, nil
+}
+
+// Read an archive from a reader. The format is detected from its contents.
+// Uncompressed archive files are read in place. Compressed tarballs and
+// tarballs read from pipes are streamed. Zip archives read from pipes can't be
+// read in order, so they're copied into a temporary file that's removed when
+// the FS is closed.
+func Read(r io.Reader) (*FS, error) {
+	if file, ok := r.(*os.File); ok {
+		if stat, err := file.Stat(); err == nil && stat.Mode().IsRegular() {
+			return readSection(io.NewSectionReader(file, 0, stat.Size()))
+		}
+	}
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(zstdMagic))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("archives: unable to read archive: %w", err)
+	}
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("archives: unable to decompress archive: %w", err)
+		}
+		return newStream(gz, gz.Close), nil
+	case bytes.HasPrefix(magic, zstdMagic):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("archives: unable to decompress archive: %w", err)
+		}
+		return newStream(zr, func() error {
+			zr.Close()
+			return nil
+		}), nil
+	case bytes.HasPrefix(magic, zipMagic), bytes.HasPrefix(magic, zipEmptyMagic):
+		return spool(br, readZip)
+	default:
+		return newStream(br, func() error { return nil }), nil
+	}
+}
+
+// Read an archive that can be read out of order
+func readSection(sr *io.SectionReader) (*FS, error) {
+	magic := make([]byte, len(zstdMagic))
+	n, err := sr.ReadAt(magic, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("archives: unable to read archive: %w", err)
+	}
+	magic = magic[:n]
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic), bytes.HasPrefix(magic, zstdMagic):
+		// Compressed tarballs can't be read out of order, so they're streamed
+		return Read(sr)
+	case bytes.HasPrefix(magic, zipMagic), bytes.HasPrefix(magic, zipEmptyMagic):
+		return readZip(sr)
+	default:
+		return readTar(sr)
+	}
+}
+
+// Copy a zip archive into a temporary file, so it can be read out of order
+func spool(r io.Reader, read func(sr *io.SectionReader) (*FS, error)) (*FS, error) {
+	tmp, err := os.CreateTemp("", "chunky-archive-*")
+	if err != nil {
+		return nil, fmt.Errorf("archives: unable to create temporary file: %w", err)
+	}
+	remove := func() error {
+		return errors.Join(tmp.Close(), os.Remove(tmp.Name()))
+	}
+	size, err := io.Copy(tmp, r)
+	if err != nil {
+		remove()
+		return nil, fmt.Errorf("archives: unable to read archive: %w", err)
+	}
+	fsys, err := read(io.NewSectionReader(tmp, 0, size))
+	if err != nil {
+		remove()
+		return nil, err
+	}
+	fsys.closer = remove
+	return fsys, nil
+}
+
+// Read the entries of a tarball. Regular files are read straight from the
+// tarball when they're opened.
+func readTar(sr *io.SectionReader) (*FS, error) {
+	fsys := newFS()
+	tr := tar.NewReader(sr)
+	for {
+		header, err := tr.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, fmt.Errorf("archives: unable to read tarball: %w", err)
+		}
+		if header.Typeflag == tar.TypeXGlobalHeader {
+			continue
+		}
+		n, err := fsys.tarNode(tr, header)
+		if err != nil {
+			return nil, err
+		}
+		if n.mode.IsRegular() && n.open == nil {
+			// The tar reader reads whole blocks, so the data starts where the
+			// reader is after the header
+			offset, err := sr.Seek(0, io.SeekCurrent)
+			if err != nil {
+				return nil, fmt.Errorf("archives: unable to find %q: %w", header.Name, err)
+			}
+			n.open = func() (io.ReadCloser, error) {
+				return io.NopCloser(io.NewSectionReader(sr, offset, header.Size)), nil
+			}
+		}
+		if _, err := fsys.add(header.Name, n); err != nil {
+			return nil, err
+		}
+	}
+	fsys.finish()
+	return fsys, nil
+}
+
+// Create a tarball that's read in order as it's streamed
+func newStream(r io.Reader, closer func() error) *FS {
+	fsys := newFS()
+	fsys.stream = tar.NewReader(r)
+	fsys.streaming = true
+	fsys.closer = closer
+	return fsys
+}
+
+// Stream calls fn with each entry of a streamed tarball in the order they're
+// stored. A file's data can only be opened while fn is called with it. Returns
+// errors.ErrUnsupported if the archive can be read out of order, so it can be
+// walked instead.
+func (f *FS) Stream(fn func(fpath string, info fs.FileInfo) error) error {
+	f.mu.Lock()
+	tr := f.stream
+	f.stream = nil
+	f.mu.Unlock()
+	if !f.streaming {
+		return errors.ErrUnsupported
+	} else if tr == nil {
+		return errors.New("archives: archive has already been streamed")
+	}
+	for {
+		header, err := tr.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return fmt.Errorf("archives: unable to read tarball: %w", err)
+		}
+		if header.Typeflag == tar.TypeXGlobalHeader {
+			continue
+		}
+		n, err := f.tarNode(tr, header)
+		if err != nil {
+			return err
+		}
+		if n.mode.IsRegular() && n.open == nil {
+			n.streamed = true
+			n.open = func() (io.ReadCloser, error) {
+				return io.NopCloser(tr), nil
+			}
+		}
+		stored, err := f.add(header.Name, n)
+		if err != nil {
+			return err
+		}
+		if stored == nil {
+			continue
+		}
+		f.mu.Lock()
+		f.current = stored
+		f.mu.Unlock()
+		err = fn(cleanPath(header.Name), fileInfo{stored})
+		f.mu.Lock()
+		f.current = nil
+		f.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	f.finish()
+	return nil
+}
+
+// Create the node of a tar entry. Regular files are given an ID, so hard links
+// can refer to them after they've been streamed.
+func (f *FS) tarNode(tr *tar.Reader, header *tar.Header) (*node, error) {
+	n := &node{
+		mode:    header.FileInfo().Mode(),
+		modTime: header.ModTime,
+	}
+	switch header.Typeflag {
+	case tar.TypeSymlink:
+		n.link = header.Linkname
+	case tar.TypeChar, tar.TypeBlock:
+		n.major, n.minor = uint32(header.Devmajor), uint32(header.Devminor)
+	case tar.TypeLink:
+		target, ok := f.nodes[cleanPath(header.Linkname)]
+		if !ok || !target.mode.IsRegular() {
+			return nil, fmt.Errorf("archives: %q links to missing file %q", header.Name, header.Linkname)
+		}
+		n.mode = target.mode.Type() | n.mode.Perm()
+		n.size, n.id, n.open, n.streamed = target.size, target.id, target.open, target.streamed
+	case tar.TypeReg, tar.TypeRegA, tar.TypeGNUSparse:
+		f.nextID++
+		n.id = f.nextID
+		n.size = header.Size
+		if isSparse(header) {
+			// Sparse files are expanded by the tar reader, so they're read into
+			// memory
+			data, err := io.ReadAll(tr)
+			if err != nil {
+				return nil, fmt.Errorf("archives: unable to read %q: %w", header.Name, err)
+			}
+			n.size = int64(len(data))
+			n.open = func() (io.ReadCloser, error) {
+				return io.NopCloser(bytes.NewReader(data)), nil
+			}
+		}
+	}
+	return n, nil
+}
+
+// isSparse returns true for GNU sparse files
+func isSparse(header *tar.Header) bool {
+	if header.Typeflag == tar.TypeGNUSparse {
+		return true
+	}
+	for key := range header.PAXRecords {
+		if strings.HasPrefix(key, "GNU.sparse.") {
+			return true
+		}
+	}
+	return false
+}
+
+// Read the entries of a zip archive
+func readZip(sr *io.SectionReader) (*FS, error) {
+	zr, err := zip.NewReader(sr, sr.Size())
+	if err != nil {
+		return nil, fmt.Errorf("archives: unable to read zip archive: %w", err)
+	}
+	fsys := newFS()
+	for _, zf := range zr.File {
+		n := &node{
+			mode:    zf.Mode(),
+			modTime: zf.Modified,
+		}
+		switch {
+		case n.mode&fs.ModeSymlink != 0:
+			// Symlinks store their target as their data
+			rc, err := zf.Open()
+			if err != nil {
+				return nil, fmt.Errorf("archives: unable to open %q: %w", zf.Name, err)
+			}
+			link, err := io.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				return nil, fmt.Errorf("archives: unable to read %q: %w", zf.Name, err)
+			}
+			n.link = string(link)
+		case n.mode.IsRegular():
+			n.size = int64(zf.UncompressedSize64)
+			n.open = zf.Open
+		}
+		if _, err := fsys.add(zf.Name, n); err != nil {
+			return nil, err
+		}
+	}
+	fsys.finish()
+	return fsys, nil
+}
+
+func newFS() *FS {
+	root := &node{name: ".", mode: fs.ModeDir | 0755, implied: true}
+	return &FS{
+		root:   root,
+		nodes:  map[string]*node{".": root},
+		closer: func() error { return nil },
+	}
+}
+
+// Clean the path of an entry, so absolute paths are relative to the root
+func cleanPath(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// Add an entry, creating the directories it's in. Later entries replace
+// earlier entries with the same path, like they do when a tarball is
+// extracted. Returns the node that's stored, which is nil for the root.
+func (f *FS) add(name string, n *node) (*node, error) {
+	fpath := cleanPath(name)
+	if fpath == "" {
+		// Keep the root as a directory
+		if n.mode.IsDir() {
+			f.root.mode, f.root.modTime, f.root.implied = n.mode, n.modTime, false
+		}
+		return nil, nil
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return nil, fmt.Errorf("archives: entry %q is outside the archive", name)
+		}
+	}
+	n.name = path.Base(fpath)
+	parent, err := f.mkdirAll(path.Dir(fpath))
+	if err != nil {
+		return nil, err
+	}
+	if existing, ok := f.nodes[fpath]; ok {
+		// Keep the children of directories that are listed again
+		if existing.mode.IsDir() && n.mode.IsDir() {
+			existing.mode, existing.modTime, existing.implied = n.mode, n.modTime, false
+			return existing, nil
+		}
+		*existing = *n
+		return existing, nil
+	}
+	f.nodes[fpath] = n
+	parent.children = append(parent.children, n)
+	return n, nil
+}
+
+// Create the directories of a path that aren't in the archive
+func (f *FS) mkdirAll(dir string) (*node, error) {
+	if dir == "." {
+		return f.root, nil
+	}
+	if n, ok := f.nodes[dir]; ok {
+		if !n.mode.IsDir() {
+			return nil, fmt.Errorf("archives: %q is in %q, which isn't a directory", dir, path.Dir(dir))
+		}
+		return n, nil
+	}
+	parent, err := f.mkdirAll(path.Dir(dir))
+	if err != nil {
+		return nil, err
+	}
+	n := &node{name: path.Base(dir), mode: fs.ModeDir | 0755, implied: true}
+	f.nodes[dir] = n
+	parent.children = append(parent.children, n)
+	return n, nil
+}
+
+// Sort the directories and give the implied directories the modification
+// time of the newest entry, so they don't change between reads
+func (f *FS) finish() {
+	var newest time.Time
+	for _, n := range f.nodes {
+		if n.modTime.After(newest) {
+			newest = n.modTime
+		}
+	}
+	for _, n := range f.nodes {
+		if n.implied {
+			n.modTime = newest
+		}
+		sort.Slice(n.children, func(i, j int) bool {
+			return n.children[i].name < n.children[j].name
+		})
+	}
+}
+
+// Find the node at a path, following symlinks when requested
+func (f *FS) find(op, name string, follow bool) (*node, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	fpath := name
+	for i := 0; ; i++ {
+		n, ok := f.nodes[fpath]
+		if !ok {
+			return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+		if !follow || n.mode&fs.ModeSymlink == 0 {
+			return n, nil
+		}
+		if i == maxSymlinks {
+			return nil, &fs.PathError{Op: op, Path: name, Err: errors.New("too many symlinks")}
+		}
+		if path.IsAbs(n.link) {
+			// Absolute symlinks point outside of the archive
+			return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+		fpath = path.Join(path.Dir(fpath), n.link)
+		if !fs.ValidPath(fpath) {
+			return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+	}
+}
+
+// Open a file in the archive, following symlinks
+func (f *FS) Open(name string) (fs.File, error) {
+	n, err := f.find("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if n.mode.IsDir() {
+		return &openDir{node: n}, nil
+	}
+	if n.open == nil {
+		return &openFile{node: n, rc: io.NopCloser(bytes.NewReader(nil))}, nil
+	}
+	if n.streamed {
+		// Streamed files can only be read once, while they're being streamed
+		f.mu.Lock()
+		current := f.current
+		f.current = nil
+		f.mu.Unlock()
+		if n != current {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: errStreamed}
+		}
+	}
+	rc, err := n.open()
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &openFile{node: n, rc: rc}, nil
+}
+
+// Stat a file in the archive, following symlinks
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	n, err := f.find("stat", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return fileInfo{n}, nil
+}
+
+// Lstat a file in the archive without following symlinks
+func (f *FS) Lstat(name string) (fs.FileInfo, error) {
+	n, err := f.find("lstat", name, false)
+	if err != nil {
+		return nil, err
+	}
+	return fileInfo{n}, nil
+}
+
+// Readlink returns the target of a symlink
+func (f *FS) Readlink(name string) (string, error) {
+	n, err := f.find("readlink", name, false)
+	if err != nil {
+		return "", err
+	}
+	if n.mode&fs.ModeSymlink == 0 {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	return n.link, nil
+}
+
+// ReadDir reads a directory in the archive, sorted by name
+func (f *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	n, err := f.find("readdir", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if !n.mode.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	entries := make([]fs.DirEntry, len(n.children))
+	for i, child := range n.children {
+		entries[i] = fs.FileInfoToDirEntry(fileInfo{child})
+	}
+	return entries, nil
+}
+
+// Close the archive, removing its temporary file if it has one
+func (f *FS) Close() error {
+	return f.closer()
+}
+
+type fileInfo struct {
+	n *node
+}
+
+var _ fs.FileInfo = fileInfo{}
+
+func (i fileInfo) Name() string       { return i.n.name }
+func (i fileInfo) Size() int64        { return i.n.size }
+func (i fileInfo) Mode() fs.FileMode  { return i.n.mode }
+func (i fileInfo) ModTime() time.Time { return i.n.modTime }
+func (i fileInfo) IsDir() bool        { return i.n.mode.IsDir() }
+func (i fileInfo) Sys() any           { return nil }
+
+// DeviceNumber returns the major and minor numbers of a device
+func (i fileInfo) DeviceNumber() (major, minor uint32) { return i.n.major, i.n.minor }
+
+// FileID identifies a regular file and the hard links to it within the archive
+func (i fileInfo) FileID() (id repos.FileID, ok bool) {
+	return repos.FileID{Ino: i.n.id}, i.n.id != 0
+}
+
+type openFile struct {
+	node *node
+	rc   io.ReadCloser
+}
+
+func (o *openFile) Stat() (fs.FileInfo, error) { return fileInfo{o.node}, nil }
+func (o *openFile) Read(p []byte) (int, error) { return o.rc.Read(p) }
+func (o *openFile) Close() error               { return o.rc.Close() }
+
+type openDir struct {
+	node   *node
+	offset int
+}
+
+func (o *openDir) Stat() (fs.FileInfo, error) { return fileInfo{o.node}, nil }
+func (o *openDir) Close() error               { return nil }
+
+func (o *openDir) Read(p []byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: o.node.name, Err: errors.New("is a directory")}
+}
+
+func (o *openDir) ReadDir(count int) ([]fs.DirEntry, error) {
+	children := o.node.children[o.offset:]
+	if count > 0 && len(children) > count {
+		children = children[:count]
+	}
+	if count > 0 && len(children) == 0 {
+		return nil, io.EOF
+	}
+	o.offset += len(children)
+	entries := make([]fs.DirEntry, len(children))
+	for i, child := range children {
+		entries[i] = fs.FileInfoToDirEntry(fileInfo{child})
+	}
+	return entries, nil
+}
@@ -8,6 +8,7 @@ import (
 	"strings"
 
 	"github.com/matthewmueller/chunky/internal/commits"
+	"github.com/matthewmueller/chunky/internal/signatures"
 	"github.com/matthewmueller/chunky/repos"
 )
 
@@ -30,6 +31,7 @@ func Load(fsys repos.FS) (*Local, error) {
 	cache := &Local{
 		fsys,
 		map[string]*commits.File{},
+		map[string]int{},
 		map[string]*commits.Commit{},
 	}
 
@@ -58,10 +60,7 @@ func Load(fsys repos.FS) (*Local, error) {
 			}
 			return nil, err
 		}
-		for _, file := range commit.Files() {
-			cache.files[cacheKey(file.Path, file.Id)] = file
-		}
-		cache.commits[commitId] = commit
+		cache.add(commitId, commit)
 	}
 
 	return cache, nil
@@ -76,7 +75,8 @@ func isCacheInvalid(err error) bool {
 
 type Local struct {
 	fsys    repos.FS
-	files   map[string]*commits.File   // path:hash -> pack_file
+	files   map[string]*commits.File   // path:hash[:attrs] -> pack_file
+	paths   map[string]int             // path -> number of commits with the path
 	commits map[string]*commits.Commit // commit_id -> commit
 }
 
@@ -95,7 +95,7 @@ func (c *Local) Download(ctx context.Context, repo repos.Repo) error {
 				return fs.SkipAll
 			}
 			return err
-		} else if de.IsDir() {
+		} else if de.IsDir() || signatures.Is(fpath) {
 			return nil
 		}
 
@@ -124,13 +124,8 @@ func (c *Local) Download(ctx context.Context, repo repos.Repo) error {
 			return err
 		}
 
-		// Add the files to the cache
-		for _, file := range commit.Files() {
-			c.files[cacheKey(file.Path, file.Id)] = file
-		}
-
-		// Mark the commit as downloaded
-		c.commits[commitId] = commit
+		// Add the files to the cache and mark the commit as downloaded
+		c.add(commitId, commit)
 
 		return nil
 	}); err != nil {
@@ -145,21 +140,23 @@ func (c *Local) Download(ctx context.Context, repo repos.Repo) error {
 		if err := c.fsys.RemoveAll(commitId); err != nil {
 			return err
 		}
-		commit := c.commits[commitId]
-		for _, file := range commit.Files() {
-			delete(c.files, cacheKey(file.Path, file.Id))
-		}
-		delete(c.commits, commitId)
+		c.remove(commitId)
 	}
 
 	return nil
 }
 
-func (c *Local) Get(path, hash string) (file *commits.File, ok bool) {
-	file, ok = c.files[cacheKey(path, hash)]
+func (c *Local) Get(path, hash, attrs string) (file *commits.File, ok bool) {
+	file, ok = c.files[cacheKey(path, hash, attrs)]
 	return file, ok
 }
 
+// Commit returns a cached commit
+func (c *Local) Commit(commitId string) (commit *commits.Commit, ok bool) {
+	commit, ok = c.commits[commitId]
+	return commit, ok
+}
+
 func (c *Local) Set(commitId string, commit *commits.Commit) error {
 	// Skip if we already have the commit
 	if _, ok := c.commits[commitId]; ok {
@@ -178,13 +175,38 @@ func (c *Local) Set(commitId string, commit *commits.Commit) error {
 	}
 
 	// Add the files to the cache
+	c.add(commitId, commit)
+	return nil
+}
+
+// Has returns true if any cached commit has a file at the path
+func (c *Local) Has(path string) bool {
+	return c.paths[path] > 0
+}
+
+// Add a commit's files to the cache
+func (c *Local) add(commitId string, commit *commits.Commit) {
 	for _, file := range commit.Files() {
-		c.files[cacheKey(file.Path, file.Id)] = file
+		c.files[cacheKey(file.Path, file.Id, file.Attrs)] = file
+		c.paths[file.Path]++
 	}
 	c.commits[commitId] = commit
-	return nil
 }
 
-func cacheKey(path, hash string) string {
-	return path + ":" + hash
+// Remove a commit's files from the cache
+func (c *Local) remove(commitId string) {
+	for _, file := range c.commits[commitId].Files() {
+		delete(c.files, cacheKey(file.Path, file.Id, file.Attrs))
+		if c.paths[file.Path]--; c.paths[file.Path] <= 0 {
+			delete(c.paths, file.Path)
+		}
+	}
+	delete(c.commits, commitId)
+}
+
+func cacheKey(path, hash, attrs string) string {
+	if attrs == "" {
+		return path + ":" + hash
+	}
+	return path + ":" + hash + ":" + attrs
 }
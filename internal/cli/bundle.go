@@ -0,0 +1,90 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"github.com/livebud/cli"
+	"github.com/matthewmueller/chunky"
+	"github.com/matthewmueller/chunky/repos/bundle"
+)
+
+type BundleCreate struct {
+	Repo        string
+	Revisions   []string
+	Output      string
+	Base        []string
+	Concurrency *int
+}
+
+func (b *BundleCreate) command(cli cli.Command) cli.Command {
+	cmd := cli.Command("create", "bundle revisions into a file")
+	cmd.Arg("repo", "repository to bundle").String(&b.Repo)
+	cmd.Args("revisions", "revisions to bundle along with their history").Optional().Strings(&b.Revisions)
+	cmd.Flag("output", "file to write the bundle to").Short('o').String(&b.Output)
+	cmd.Flag("base", "leave out the history of a revision the importer already has").Optional().Strings(&b.Base)
+	cmd.Flag("concurrency", "number of packs to read concurrently").Optional().Int(&b.Concurrency)
+	return cmd
+}
+
+func (c *CLI) BundleCreate(ctx context.Context, in *BundleCreate) error {
+	repo, err := c.loadRepo(in.Repo)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(in.Output)
+	if err != nil {
+		return err
+	}
+
+	// Don't leave a partial bundle behind
+	if err := c.chunky.CreateBundle(ctx, &chunky.CreateBundle{
+		From:        repo,
+		To:          file,
+		Revisions:   in.Revisions,
+		Base:        in.Base,
+		Concurrency: in.Concurrency,
+	}); err != nil {
+		file.Close()
+		return errors.Join(err, os.Remove(in.Output))
+	}
+	return file.Close()
+}
+
+type BundleImport struct {
+	Bundle      string
+	Repo        string
+	Force       bool
+	Concurrency *int
+}
+
+func (b *BundleImport) command(cli cli.Command) cli.Command {
+	cmd := cli.Command("import", "verify a bundle and import it into a repository")
+	cmd.Arg("bundle", "bundle file to import").String(&b.Bundle)
+	cmd.Arg("repo", "repository to import into").String(&b.Repo)
+	cmd.Flag("force", "override the repository's tag protection").Bool(&b.Force).Default(false)
+	cmd.Flag("concurrency", "number of packs to import concurrently").Optional().Int(&b.Concurrency)
+	return cmd
+}
+
+func (c *CLI) BundleImport(ctx context.Context, in *BundleImport) error {
+	from, err := bundle.Open(in.Bundle)
+	if err != nil {
+		return err
+	}
+	defer from.Close()
+
+	to, err := c.loadRepo(in.Repo)
+	if err != nil {
+		return err
+	}
+
+	return c.chunky.ImportBundle(ctx, &chunky.ImportBundle{
+		From:        from,
+		To:          to,
+		Force:       in.Force,
+		Concurrency: in.Concurrency,
+	})
+}
@@ -29,5 +29,7 @@ func (c *CLI) CachePrune(ctx context.Context, in *CachePrune) error {
 		return err
 	}
 
+	// Packs cached on disk are within the cache directory, so they're removed
+	// along with it
 	return os.RemoveAll(cacheDir)
 }
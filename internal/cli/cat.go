@@ -5,6 +5,7 @@ import (
 
 	"github.com/livebud/cli"
 	"github.com/matthewmueller/chunky"
+	"github.com/matthewmueller/chunky/repos"
 )
 
 type Cat struct {
@@ -13,6 +14,8 @@ type Cat struct {
 	Path          string
 	LimitDownload *string
 	Concurrency   *int
+	VerifyKeys    []string
+	PackCache     bool
 }
 
 func (c *Cat) command(cli cli.Command) cli.Command {
@@ -22,14 +25,28 @@ func (c *Cat) command(cli cli.Command) cli.Command {
 	cmd.Flag("revision", "revision to show").String(&c.Revision).Default("latest")
 	cmd.Flag("limit-download", "limit bytes per second").Optional().String(&c.LimitDownload)
 	cmd.Flag("concurrency", "number of concurrent downloads").Optional().Int(&c.Concurrency)
+	cmd.Flag("pack-cache", "cache downloaded packs on disk").Bool(&c.PackCache).Default(false)
+	cmd.Flag("verify-key", "only show revisions signed by this public key").Optional().Strings(&c.VerifyKeys)
 	return cmd
 }
 
 func (c *CLI) Cat(ctx context.Context, in *Cat) error {
-	repo, err := c.loadRepo(in.Repo)
+	repoUrl, err := repos.Parse(in.Repo)
 	if err != nil {
 		return err
 	}
+	repo, err := c.loadRepoFromUrl(repoUrl)
+	if err != nil {
+		return err
+	}
+
+	// Load the cache for downloaded packs
+	var packCache repos.FS
+	if in.PackCache {
+		if packCache, err = c.loadPackCache(repoUrl); err != nil {
+			return err
+		}
+	}
 
 	// Set the download limit if provided
 	limitDownload := ""
@@ -37,6 +54,11 @@ func (c *CLI) Cat(ctx context.Context, in *Cat) error {
 		limitDownload = *in.LimitDownload
 	}
 
+	trustedKeys, err := loadTrustedKeys(in.VerifyKeys)
+	if err != nil {
+		return err
+	}
+
 	// Download a file
 	return c.chunky.Cat(ctx, &chunky.Cat{
 		From:          repo,
@@ -45,5 +67,7 @@ func (c *CLI) Cat(ctx context.Context, in *Cat) error {
 		LimitDownload: limitDownload,
 		Concurrency:   in.Concurrency,
 		Path:          in.Path,
+		TrustedKeys:   trustedKeys,
+		CacheDir:      packCache,
 	})
 }
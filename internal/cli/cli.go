@@ -3,6 +3,7 @@ package cli
 import (
 	"bytes"
 	"context"
+	"crypto/ed25519"
 	"fmt"
 	"io"
 	"log/slog"
@@ -10,6 +11,7 @@ import (
 	"os"
 	"os/user"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/livebud/cli"
@@ -17,8 +19,10 @@ import (
 	"github.com/matthewmueller/chunky"
 	"github.com/matthewmueller/chunky/internal/commits"
 	"github.com/matthewmueller/chunky/internal/humanize"
+	"github.com/matthewmueller/chunky/internal/signatures"
 	"github.com/matthewmueller/chunky/internal/tags"
 	"github.com/matthewmueller/chunky/repos"
+	"github.com/matthewmueller/chunky/repos/bundle"
 	"github.com/matthewmueller/chunky/repos/local"
 	"github.com/matthewmueller/chunky/repos/sftp"
 	"github.com/matthewmueller/logs"
@@ -41,6 +45,7 @@ func Run() int {
 func Default() *CLI {
 	return &CLI{
 		os.Stdout,
+		os.Stdin,
 		".",
 		prompter.Default(),
 		color.Default(),
@@ -52,6 +57,7 @@ func Default() *CLI {
 
 type CLI struct {
 	Stdout io.Writer
+	Stdin  io.Reader
 	Dir    string
 	Prompt *prompter.Prompt
 	Color  color.Writer
@@ -78,6 +84,9 @@ func (c *CLI) loadRepoFromUrl(url *url.URL) (repos.Repo, error) {
 		return local.New(virt.OS(url.Path)), nil
 	case "sftp", "ssh":
 		return sftp.Dial(url)
+	case "bundle":
+		// Bundles are read-only
+		return bundle.Open(filepath.Join(url.Host, url.Path))
 	default:
 		return nil, fmt.Errorf("cli: unsupported repo scheme: %s", url.Scheme)
 	}
@@ -131,6 +140,61 @@ func (c *CLI) loadCache(repoUrl *url.URL) (repos.FS, error) {
 	return virt.OS(cacheDir), nil
 }
 
+// Load the directory that downloaded packs are cached in. Packs are cached by
+// their ID, so each repository has its own directory within its cache.
+func (c *CLI) loadPackCache(repoUrl *url.URL) (repos.FS, error) {
+	cacheDir, err := c.cacheDir(repoUrl)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(cacheDir, "packs")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("cli: creating pack cache dir: %w", err)
+	}
+	return virt.OS(dir), nil
+}
+
+// Load the ed25519 private key used to sign revisions
+func loadSigningKey(path string) (ed25519.PrivateKey, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cli: reading signing key: %w", err)
+	}
+	return signatures.ParsePrivateKey(data)
+}
+
+// Load the public keys that are trusted to sign revisions. Without any paths,
+// the keys are loaded from the trusted_keys file in the user's config
+// directory, if it exists.
+func loadTrustedKeys(paths []string) (keys []ed25519.PublicKey, err error) {
+	if len(paths) == 0 {
+		configDir, err := os.UserConfigDir()
+		if err != nil {
+			return nil, nil
+		}
+		trustedKeys := filepath.Join(configDir, "chunky", "trusted_keys")
+		if _, err := os.Stat(trustedKeys); err != nil {
+			return nil, nil
+		}
+		paths = []string{trustedKeys}
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("cli: reading trusted keys: %w", err)
+		}
+		fileKeys, err := signatures.ParsePublicKeys(data)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, fileKeys...)
+	}
+	return keys, nil
+}
+
 func (c *CLI) getUser() (string, error) {
 	u, err := user.Current()
 	if err != nil {
@@ -142,6 +206,43 @@ func (c *CLI) getUser() (string, error) {
 	return u.Username, nil
 }
 
+// Parse a list of key=value pairs
+func parseMeta(pairs []string) (map[string]string, error) {
+	meta := map[string]string{}
+	for _, pair := range pairs {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("cli: invalid key=value pair %q", pair)
+		}
+		meta[key] = value
+	}
+	return meta, nil
+}
+
+// Check that the commit has all the metadata
+func matchMeta(commit *commits.Commit, meta map[string]string) bool {
+	for key, value := range meta {
+		if !commit.HasMeta(key, value) {
+			return false
+		}
+	}
+	return true
+}
+
+// Format metadata as key=value pairs sorted by key
+func metaPairs(meta map[string]string) []string {
+	keys := make([]string, 0, len(meta))
+	for key := range meta {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, key := range keys {
+		pairs[i] = key + "=" + meta[key]
+	}
+	return pairs
+}
+
 func formatTags(tags []*tags.Tag) string {
 	if len(tags) == 0 {
 		return ""
@@ -158,13 +259,14 @@ func formatCommit(writer io.Writer, color color.Writer, commit *commits.Commit,
 	relTime := humanize.Time(commit.CreatedAt())
 	tags := tagMap[commitId]
 	size := humanize.Bytes(commit.Size())
-	writer.Write(fmt.Appendf(nil, "%s\t%s\t%s\t%s\t%+v\n", color.Green(commitId), color.Green(formatTags(tags)), size, commit.User(), color.Dim(relTime)))
+	message, _, _ := strings.Cut(commit.Message(), "\n")
+	writer.Write(fmt.Appendf(nil, "%s\t%s\t%s\t%s\t%+v\t%s\t%s\n", color.Green(commitId), color.Green(formatTags(tags)), size, commit.User(), color.Dim(relTime), message, color.Dim(strings.Join(metaPairs(commit.Meta()), " "))))
 }
 
 func formatTag(writer io.Writer, color color.Writer, tag *tags.Tag, newest *commits.Commit) {
 	b := new(bytes.Buffer)
 	b.WriteString(color.Green(tag.Name))
-	if len(tag.Commits) == 0 {
+	if len(tag.Entries) == 0 {
 		writer.Write(b.Bytes())
 		return
 	}
@@ -176,10 +278,10 @@ func formatTag(writer io.Writer, color color.Writer, tag *tags.Tag, newest *comm
 
 	// List each commit, up to 5
 	b.WriteString("\t[")
-	commitIds := tag.Commits
+	commitIds := tag.Commits()
 	// If more than 5, only show last 5
-	if len(tag.Commits) > 5 {
-		commitIds = tag.Commits[len(tag.Commits)-5:]
+	if len(commitIds) > 5 {
+		commitIds = commitIds[len(commitIds)-5:]
 	}
 	last := len(commitIds) - 1
 	for i := last; i >= 0; i-- {
@@ -191,7 +293,7 @@ func formatTag(writer io.Writer, color color.Writer, tag *tags.Tag, newest *comm
 			b.WriteString(color.Dim(commitId))
 		}
 	}
-	if len(tag.Commits) > 5 {
+	if len(tag.Entries) > 5 {
 		b.WriteString(", ")
 		b.WriteString(color.Dim("..."))
 	}
@@ -249,6 +351,39 @@ func (c *CLI) Parse(ctx context.Context, args ...string) error {
 		}))
 	}
 
+	{ // import <archive|-> <repo>
+		in := &Import{}
+		cmd := in.command(cli)
+		cmd.Run(c.wrap(func(ctx context.Context) error {
+			return c.Import(ctx, in)
+		}))
+	}
+
+	{ // copy <from> <to> [--revision=<revision>] [--all]
+		in := &Copy{}
+		cmd := in.command(cli)
+		cmd.Run(c.wrap(func(ctx context.Context) error {
+			return c.Copy(ctx, in)
+		}))
+	}
+
+	{ // bundle create <repo> [revisions...] -o <file>
+		// bundle import <file> <repo>
+		bundle := cli.Command("bundle", "move revisions between repositories in a file")
+
+		create := &BundleCreate{}
+		createCmd := create.command(bundle)
+		createCmd.Run(c.wrap(func(ctx context.Context) error {
+			return c.BundleCreate(ctx, create)
+		}))
+
+		imp := &BundleImport{}
+		importCmd := imp.command(bundle)
+		importCmd.Run(c.wrap(func(ctx context.Context) error {
+			return c.BundleImport(ctx, imp)
+		}))
+	}
+
 	{ // versions <repo>
 		in := &List{}
 		cmd := in.command(cli)
@@ -257,6 +392,14 @@ func (c *CLI) Parse(ctx context.Context, args ...string) error {
 		}))
 	}
 
+	{ // log <repo> [-- <path>] [--revision=<revision>]
+		in := &Log{}
+		cmd := in.command(cli)
+		cmd.Run(c.wrap(func(ctx context.Context) error {
+			return c.Log(ctx, in)
+		}))
+	}
+
 	{ // show <repo> <revision>
 		in := &Show{}
 		cmd := in.command(cli)
@@ -273,6 +416,30 @@ func (c *CLI) Parse(ctx context.Context, args ...string) error {
 		}))
 	}
 
+	{ // grep <repo> <pattern> [--revision=<revision>] [--path=<glob>]
+		in := &Grep{}
+		cmd := in.command(cli)
+		cmd.Run(c.wrap(func(ctx context.Context) error {
+			return c.Grep(ctx, in)
+		}))
+	}
+
+	{ // export <repo> [paths...] [--revision=<revision>] [--format=<format>] [-o <file>]
+		in := &Export{}
+		cmd := in.command(cli)
+		cmd.Run(c.wrap(func(ctx context.Context) error {
+			return c.Export(ctx, in)
+		}))
+	}
+
+	{ // serve-ui <repo> [--addr=<addr>]
+		in := &ServeUI{}
+		cmd := in.command(cli)
+		cmd.Run(c.wrap(func(ctx context.Context) error {
+			return c.ServeUI(ctx, in)
+		}))
+	}
+
 	{ // cat-pack <repo> <pack>
 		in := &CatPack{}
 		cmd := in.command(cli)
@@ -305,6 +472,14 @@ func (c *CLI) Parse(ctx context.Context, args ...string) error {
 		}))
 	}
 
+	{ // tag-log <repo> <tag>
+		in := &TagLog{}
+		cmd := in.command(cli)
+		cmd.Run(c.wrap(func(ctx context.Context) error {
+			return c.TagLog(ctx, in)
+		}))
+	}
+
 	{ // tags <repo>
 		in := &Tags{}
 		cmd := in.command(cli)
@@ -313,6 +488,30 @@ func (c *CLI) Parse(ctx context.Context, args ...string) error {
 		}))
 	}
 
+	{ // protect <repo> <pattern>
+		in := &Protect{}
+		cmd := in.command(cli)
+		cmd.Run(c.wrap(func(ctx context.Context) error {
+			return c.Protect(ctx, in)
+		}))
+	}
+
+	{ // protections <repo>
+		in := &Protections{}
+		cmd := in.command(cli)
+		cmd.Run(c.wrap(func(ctx context.Context) error {
+			return c.Protections(ctx, in)
+		}))
+	}
+
+	{ // verify <repo> <revision>
+		in := &Verify{}
+		cmd := in.command(cli)
+		cmd.Run(c.wrap(func(ctx context.Context) error {
+			return c.Verify(ctx, in)
+		}))
+	}
+
 	{ // cache prune <repo>
 		in := &CachePrune{}
 		cmd := in.command(cli)
@@ -329,5 +528,25 @@ func (c *CLI) Parse(ctx context.Context, args ...string) error {
 		}))
 	}
 
-	return cli.Parse(ctx, args...)
+	return cli.Parse(ctx, renameStdin(args)...)
+}
+
+// stdinArg stands in for the "-" argument of the import command
+const stdinArg = "\x00stdin"
+
+// The flag parser never finishes when a bare "-" is an argument, so the import
+// command's "-" argument is renamed before parsing
+func renameStdin(args []string) []string {
+	renamed := make([]string, len(args))
+	importing := false
+	for i, arg := range args {
+		switch {
+		case arg == "import":
+			importing = true
+		case arg == "-" && importing:
+			arg = stdinArg
+		}
+		renamed[i] = arg
+	}
+	return renamed
 }
@@ -0,0 +1,49 @@
+package cli
+
+import (
+	"context"
+
+	"github.com/livebud/cli"
+	"github.com/matthewmueller/chunky"
+)
+
+type Copy struct {
+	From        string
+	To          string
+	Revisions   []string
+	All         bool
+	Force       bool
+	Concurrency *int
+}
+
+func (c *Copy) command(cli cli.Command) cli.Command {
+	cmd := cli.Command("copy", "copy revisions from one repository to another")
+	cmd.Arg("from", "repository to copy from").String(&c.From)
+	cmd.Arg("to", "repository to copy to").String(&c.To)
+	cmd.Flag("revision", "revision to copy along with its history").Short('r').Optional().Strings(&c.Revisions)
+	cmd.Flag("all", "mirror every revision and tag").Bool(&c.All).Default(false)
+	cmd.Flag("force", "override the destination's tag protection").Bool(&c.Force).Default(false)
+	cmd.Flag("concurrency", "number of packs to copy concurrently").Optional().Int(&c.Concurrency)
+	return cmd
+}
+
+func (c *CLI) Copy(ctx context.Context, in *Copy) error {
+	from, err := c.loadRepo(in.From)
+	if err != nil {
+		return err
+	}
+
+	to, err := c.loadRepo(in.To)
+	if err != nil {
+		return err
+	}
+
+	return c.chunky.Copy(ctx, &chunky.Copy{
+		From:        from,
+		To:          to,
+		Revisions:   in.Revisions,
+		All:         in.All,
+		Force:       in.Force,
+		Concurrency: in.Concurrency,
+	})
+}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/livebud/cli"
 	"github.com/matthewmueller/chunky"
+	"github.com/matthewmueller/chunky/repos"
 )
 
 type Download struct {
@@ -13,6 +14,12 @@ type Download struct {
 	Revision      string
 	LimitDownload string
 	Concurrency   *int
+	VerifyKeys    []string
+	NoModTime     bool
+	Owner         bool
+	NumericOwner  bool
+	Xattrs        bool
+	PackCache     bool
 }
 
 func (d *Download) command(cli cli.Command) cli.Command {
@@ -22,15 +29,33 @@ func (d *Download) command(cli cli.Command) cli.Command {
 	cmd.Flag("revision", "revision to download").String(&d.Revision).Default("latest")
 	cmd.Flag("limit-download", "limit bytes per second").String(&d.LimitDownload).Default("")
 	cmd.Flag("concurrency", "number of concurrent downloads").Optional().Int(&d.Concurrency)
+	cmd.Flag("no-modtime", "don't restore file modification times").Bool(&d.NoModTime).Default(false)
+	cmd.Flag("owner", "restore file ownership").Bool(&d.Owner).Default(false)
+	cmd.Flag("numeric-owner", "restore ownership by uid and gid rather than by name").Bool(&d.NumericOwner).Default(false)
+	cmd.Flag("xattrs", "restore extended attributes").Bool(&d.Xattrs).Default(false)
+	cmd.Flag("pack-cache", "cache downloaded packs on disk").Bool(&d.PackCache).Default(false)
+	cmd.Flag("verify-key", "only download revisions signed by this public key").Optional().Strings(&d.VerifyKeys)
 	return cmd
 }
 
 func (c *CLI) Download(ctx context.Context, in *Download) error {
 	// Load the repository to download from
-	repo, err := c.loadRepo(in.From)
+	repoUrl, err := repos.Parse(in.From)
 	if err != nil {
 		return err
 	}
+	repo, err := c.loadRepoFromUrl(repoUrl)
+	if err != nil {
+		return err
+	}
+
+	// Load the cache for downloaded packs
+	var packCache repos.FS
+	if in.PackCache {
+		if packCache, err = c.loadPackCache(repoUrl); err != nil {
+			return err
+		}
+	}
 
 	// Load the virtual filesystem to download into
 	to, err := c.loadFS(in.To)
@@ -38,12 +63,25 @@ func (c *CLI) Download(ctx context.Context, in *Download) error {
 		return err
 	}
 
+	trustedKeys, err := loadTrustedKeys(in.VerifyKeys)
+	if err != nil {
+		return err
+	}
+
+	preserveModTime := !in.NoModTime
+
 	// Download the directory
 	return c.chunky.Download(ctx, &chunky.Download{
-		From:          repo,
-		To:            to,
-		Revision:      in.Revision,
-		LimitDownload: in.LimitDownload,
-		Concurrency:   in.Concurrency,
+		From:            repo,
+		To:              to,
+		Revision:        in.Revision,
+		LimitDownload:   in.LimitDownload,
+		Concurrency:     in.Concurrency,
+		TrustedKeys:     trustedKeys,
+		PreserveModTime: &preserveModTime,
+		PreserveOwner:   in.Owner || in.NumericOwner,
+		NumericOwner:    in.NumericOwner,
+		PreserveXattrs:  in.Xattrs,
+		CacheDir:        packCache,
 	})
 }
@@ -0,0 +1,101 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"github.com/livebud/cli"
+	"github.com/matthewmueller/chunky"
+	"github.com/matthewmueller/chunky/internal/archives"
+	"github.com/matthewmueller/chunky/repos"
+)
+
+type Export struct {
+	Repo          string
+	Revision      string
+	Format        string
+	Output        string
+	Paths         []string
+	LimitDownload *string
+	VerifyKeys    []string
+	PackCache     bool
+}
+
+func (e *Export) command(cli cli.Command) cli.Command {
+	cmd := cli.Command("export", "export a revision as a tar or zip archive")
+	cmd.Arg("repo", "repository to export from").String(&e.Repo)
+	cmd.Args("paths", "files and directories to export").Optional().Strings(&e.Paths)
+	cmd.Flag("revision", "revision to export").String(&e.Revision).Default("latest")
+	cmd.Flag("format", "archive format: tar, tar.gz, tar.zst or zip (default: from the output name)").String(&e.Format).Default("")
+	cmd.Flag("output", "file to write the archive to, or - for stdout").Short('o').String(&e.Output).Default("-")
+	cmd.Flag("limit-download", "limit bytes per second").Optional().String(&e.LimitDownload)
+	cmd.Flag("pack-cache", "cache downloaded packs on disk").Bool(&e.PackCache).Default(false)
+	cmd.Flag("verify-key", "only export revisions signed by this public key").Optional().Strings(&e.VerifyKeys)
+	return cmd
+}
+
+func (c *CLI) Export(ctx context.Context, in *Export) error {
+	repoUrl, err := repos.Parse(in.Repo)
+	if err != nil {
+		return err
+	}
+	repo, err := c.loadRepoFromUrl(repoUrl)
+	if err != nil {
+		return err
+	}
+
+	// Load the cache for downloaded packs
+	var packCache repos.FS
+	if in.PackCache {
+		if packCache, err = c.loadPackCache(repoUrl); err != nil {
+			return err
+		}
+	}
+
+	// Set the download limit if provided
+	limitDownload := ""
+	if in.LimitDownload != nil {
+		limitDownload = *in.LimitDownload
+	}
+
+	trustedKeys, err := loadTrustedKeys(in.VerifyKeys)
+	if err != nil {
+		return err
+	}
+
+	// Infer the format from the output file name
+	format := in.Format
+	if format == "" {
+		if f, ok := archives.FormatOf(in.Output); ok {
+			format = string(f)
+		}
+	}
+
+	export := &chunky.Export{
+		From:          repo,
+		To:            c.Stdout,
+		Revision:      in.Revision,
+		Format:        format,
+		Paths:         in.Paths,
+		LimitDownload: limitDownload,
+		TrustedKeys:   trustedKeys,
+		CacheDir:      packCache,
+	}
+	if in.Output == "-" {
+		return c.chunky.Export(ctx, export)
+	}
+
+	file, err := os.Create(in.Output)
+	if err != nil {
+		return err
+	}
+	export.To = file
+
+	// Don't leave a partial archive behind
+	if err := c.chunky.Export(ctx, export); err != nil {
+		file.Close()
+		return errors.Join(err, os.Remove(in.Output))
+	}
+	return file.Close()
+}
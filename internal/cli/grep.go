@@ -0,0 +1,78 @@
+package cli
+
+import (
+	"context"
+
+	"github.com/livebud/cli"
+	"github.com/matthewmueller/chunky"
+	"github.com/matthewmueller/chunky/repos"
+)
+
+type Grep struct {
+	Repo          string
+	Pattern       string
+	Revision      string
+	Paths         []string
+	Binary        bool
+	LimitDownload *string
+	Concurrency   *int
+	VerifyKeys    []string
+	PackCache     bool
+}
+
+func (g *Grep) command(cli cli.Command) cli.Command {
+	cmd := cli.Command("grep", "search the contents of files in a revision")
+	cmd.Arg("repo", "repository to search").String(&g.Repo)
+	cmd.Arg("pattern", "regular expression to search for").String(&g.Pattern)
+	cmd.Flag("revision", "revision to search").String(&g.Revision).Default("latest")
+	cmd.Flag("path", "only search paths matching this glob").Optional().Strings(&g.Paths)
+	cmd.Flag("binary", "search binary files too").Bool(&g.Binary).Default(false)
+	cmd.Flag("limit-download", "limit bytes per second").Optional().String(&g.LimitDownload)
+	cmd.Flag("concurrency", "number of concurrent downloads").Optional().Int(&g.Concurrency)
+	cmd.Flag("pack-cache", "cache downloaded packs on disk").Bool(&g.PackCache).Default(false)
+	cmd.Flag("verify-key", "only search revisions signed by this public key").Optional().Strings(&g.VerifyKeys)
+	return cmd
+}
+
+func (c *CLI) Grep(ctx context.Context, in *Grep) error {
+	repoUrl, err := repos.Parse(in.Repo)
+	if err != nil {
+		return err
+	}
+	repo, err := c.loadRepoFromUrl(repoUrl)
+	if err != nil {
+		return err
+	}
+
+	// Load the cache for downloaded packs
+	var packCache repos.FS
+	if in.PackCache {
+		if packCache, err = c.loadPackCache(repoUrl); err != nil {
+			return err
+		}
+	}
+
+	// Set the download limit if provided
+	limitDownload := ""
+	if in.LimitDownload != nil {
+		limitDownload = *in.LimitDownload
+	}
+
+	trustedKeys, err := loadTrustedKeys(in.VerifyKeys)
+	if err != nil {
+		return err
+	}
+
+	return c.chunky.Grep(ctx, &chunky.Grep{
+		From:          repo,
+		To:            c.Stdout,
+		Revision:      in.Revision,
+		Pattern:       in.Pattern,
+		Paths:         in.Paths,
+		Binary:        in.Binary,
+		LimitDownload: limitDownload,
+		Concurrency:   in.Concurrency,
+		TrustedKeys:   trustedKeys,
+		CacheDir:      packCache,
+	})
+}
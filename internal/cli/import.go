@@ -0,0 +1,101 @@
+package cli
+
+import (
+	"context"
+
+	"github.com/livebud/cli"
+	"github.com/matthewmueller/chunky"
+	"github.com/matthewmueller/chunky/repos"
+)
+
+type Import struct {
+	Archive      string
+	To           string
+	Tags         []string
+	Paths        []string
+	Parent       string
+	Message      string
+	Meta         []string
+	Force        bool
+	SignKey      string
+	SpecialFiles string
+	LimitUpload  string
+	Concurrency  *int
+}
+
+func (i *Import) command(cli cli.Command) cli.Command {
+	cmd := cli.Command("import", "upload a tar or zip archive to a repository")
+	cmd.Arg("archive", "archive to upload, or - for stdin").String(&i.Archive)
+	cmd.Arg("repo", "repository to upload to").String(&i.To)
+	cmd.Flag("tags", "tag the revision").Short('t').Optional().Strings(&i.Tags)
+	cmd.Flag("paths", "subpaths to upload").Strings(&i.Paths).Default(".")
+	cmd.Flag("parent", "parent revision").String(&i.Parent).Default("")
+	cmd.Flag("message", "describe the revision").Short('m').String(&i.Message).Default("")
+	cmd.Flag("meta", "attach key=value metadata").Optional().Strings(&i.Meta)
+	cmd.Flag("force", "override tag protection").Bool(&i.Force).Default(false)
+	cmd.Flag("sign-key", "ed25519 private key to sign the revision").String(&i.SignKey).Default("")
+	cmd.Flag("special-files", "how to handle fifos and devices: skip, fail or record").String(&i.SpecialFiles).Default("skip")
+	cmd.Flag("limit-upload", "limit bytes per second").String(&i.LimitUpload).Default("")
+	cmd.Flag("concurrency", "number of concurrent uploads").Optional().Int(&i.Concurrency)
+	return cmd
+}
+
+func (c *CLI) Import(ctx context.Context, in *Import) error {
+	repoUrl, err := repos.Parse(in.To)
+	if err != nil {
+		return err
+	}
+
+	repo, err := c.loadRepoFromUrl(repoUrl)
+	if err != nil {
+		return err
+	}
+
+	cache, err := c.loadCache(repoUrl)
+	if err != nil {
+		return err
+	}
+
+	var archive *chunky.Archive
+	if in.Archive == "-" || in.Archive == stdinArg {
+		archive, err = chunky.ReadArchive(c.Stdin)
+	} else {
+		archive, err = chunky.OpenArchive(in.Archive)
+	}
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	user, err := c.getUser()
+	if err != nil {
+		return err
+	}
+
+	meta, err := parseMeta(in.Meta)
+	if err != nil {
+		return err
+	}
+
+	signingKey, err := loadSigningKey(in.SignKey)
+	if err != nil {
+		return err
+	}
+
+	return c.chunky.Upload(ctx, &chunky.Upload{
+		From:         archive,
+		To:           repo,
+		Tags:         in.Tags,
+		Paths:        in.Paths,
+		Parent:       in.Parent,
+		Message:      in.Message,
+		Meta:         meta,
+		Force:        in.Force,
+		SigningKey:   signingKey,
+		User:         user,
+		Cache:        cache,
+		LimitUpload:  in.LimitUpload,
+		Concurrency:  in.Concurrency,
+		SpecialFiles: chunky.SpecialFiles(in.SpecialFiles),
+	})
+}
@@ -11,15 +11,21 @@ import (
 
 type List struct {
 	Repo string
+	Meta []string
 }
 
 func (in *List) command(cli cli.Command) cli.Command {
 	cmd := cli.Command("list", "list uploads to a repository")
 	cmd.Arg("repo", "repo to list from").String(&in.Repo)
+	cmd.Flag("meta", "only list revisions with key=value metadata").Optional().Strings(&in.Meta)
 	return cmd
 }
 
 func (c *CLI) List(ctx context.Context, in *List) error {
+	meta, err := parseMeta(in.Meta)
+	if err != nil {
+		return err
+	}
 	repo, err := c.loadRepo(in.Repo)
 	if err != nil {
 		return err
@@ -34,6 +40,9 @@ func (c *CLI) List(ctx context.Context, in *List) error {
 	}
 	writer := tabwriter.NewWriter(c.Stdout, 0, 0, 1, ' ', 0)
 	for _, commit := range commits {
+		if !matchMeta(commit, meta) {
+			continue
+		}
 		formatCommit(writer, c.Color, commit, tagMap)
 	}
 	return writer.Flush()
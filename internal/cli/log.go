@@ -0,0 +1,95 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"text/tabwriter"
+
+	"github.com/livebud/cli"
+	"github.com/matthewmueller/chunky"
+	"github.com/matthewmueller/chunky/internal/humanize"
+	"github.com/matthewmueller/chunky/internal/tags"
+	"github.com/matthewmueller/chunky/repos"
+)
+
+type Log struct {
+	Repo     string
+	Revision string
+	Path     *string
+}
+
+func (in *Log) command(cli cli.Command) cli.Command {
+	cmd := cli.Command("log", "show the history of a revision")
+	cmd.Arg("repo", "repo to show the history of").String(&in.Repo)
+	cmd.Arg("path", "only show the revisions that changed this file").Optional().String(&in.Path)
+	cmd.Flag("revision", "revision to start from").String(&in.Revision).Default("latest")
+	return cmd
+}
+
+func (c *CLI) Log(ctx context.Context, in *Log) error {
+	if in.Path != nil {
+		return c.fileHistory(ctx, in.Repo, in.Revision, *in.Path)
+	}
+	repo, err := c.loadRepo(in.Repo)
+	if err != nil {
+		return err
+	}
+	tagMap, err := tags.ReadMap(ctx, repo)
+	if err != nil {
+		return err
+	}
+	// Print whatever history we were able to read, even if the chain is broken
+	commits, logErr := c.chunky.Log(ctx, &chunky.Log{
+		Repo:     repo,
+		Revision: in.Revision,
+	})
+	writer := tabwriter.NewWriter(c.Stdout, 0, 0, 1, ' ', 0)
+	for _, commit := range commits {
+		formatCommit(writer, c.Color, commit, tagMap)
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	return logErr
+}
+
+// Show the revisions that added, modified or removed a file
+func (c *CLI) fileHistory(ctx context.Context, repoPath, revision, fpath string) error {
+	repoUrl, err := repos.Parse(repoPath)
+	if err != nil {
+		return err
+	}
+	repo, err := c.loadRepoFromUrl(repoUrl)
+	if err != nil {
+		return err
+	}
+	cache, err := c.loadCache(repoUrl)
+	if err != nil {
+		return err
+	}
+	tagMap, err := tags.ReadMap(ctx, repo)
+	if err != nil {
+		return err
+	}
+	// Print whatever history we were able to read, even if the chain is broken
+	changes, logErr := c.chunky.FileHistory(ctx, &chunky.FileHistory{
+		Repo:     repo,
+		Revision: revision,
+		Path:     fpath,
+		Cache:    cache,
+	})
+	writer := tabwriter.NewWriter(c.Stdout, 0, 0, 1, ' ', 0)
+	for _, change := range changes {
+		commit := change.Commit
+		size := "-"
+		if change.File != nil {
+			size = humanize.Bytes(change.File.Size)
+		}
+		relTime := humanize.Time(commit.CreatedAt())
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%s\n", c.Color.Green(commit.ID()), c.Color.Green(formatTags(tagMap[commit.ID()])), change.Status, size, commit.User(), c.Color.Dim(relTime))
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	return logErr
+}
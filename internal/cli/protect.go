@@ -0,0 +1,50 @@
+package cli
+
+import (
+	"context"
+
+	"github.com/livebud/cli"
+	"github.com/matthewmueller/chunky"
+)
+
+type Protect struct {
+	Repo       string
+	Pattern    string
+	Immutable  bool
+	AppendOnly bool
+	Users      []string
+	Remove     bool
+}
+
+func (p *Protect) command(cli cli.Command) cli.Command {
+	cmd := cli.Command("protect", "protect tags matching a pattern")
+	cmd.Arg("repo", "repository to protect").String(&p.Repo)
+	cmd.Arg("pattern", "tag pattern (e.g. v*)").String(&p.Pattern)
+	cmd.Flag("immutable", "tags can't be changed once created").Bool(&p.Immutable).Default(false)
+	cmd.Flag("append-only", "tags can't be rolled back or deleted").Bool(&p.AppendOnly).Default(false)
+	cmd.Flag("user", "only allow these users to change the tags").Optional().Strings(&p.Users)
+	cmd.Flag("remove", "remove the protection").Bool(&p.Remove).Default(false)
+	return cmd
+}
+
+func (c *CLI) Protect(ctx context.Context, in *Protect) error {
+	repo, err := c.loadRepo(in.Repo)
+	if err != nil {
+		return err
+	}
+	if in.Remove {
+		return c.chunky.UnprotectTags(ctx, &chunky.UnprotectTags{
+			Repo:    repo,
+			Pattern: in.Pattern,
+		})
+	}
+	return c.chunky.ProtectTags(ctx, &chunky.ProtectTags{
+		Repo: repo,
+		Rule: &chunky.TagRule{
+			Pattern:    in.Pattern,
+			Immutable:  in.Immutable,
+			AppendOnly: in.AppendOnly,
+			Users:      in.Users,
+		},
+	})
+}
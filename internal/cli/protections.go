@@ -0,0 +1,50 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/livebud/cli"
+	"github.com/matthewmueller/chunky"
+)
+
+type Protections struct {
+	Repo string
+}
+
+func (p *Protections) command(cli cli.Command) cli.Command {
+	cmd := cli.Command("protections", "list tag protections")
+	cmd.Arg("repo", "repository to list from").String(&p.Repo)
+	return cmd
+}
+
+func (c *CLI) Protections(ctx context.Context, in *Protections) error {
+	repo, err := c.loadRepo(in.Repo)
+	if err != nil {
+		return err
+	}
+	rules, err := c.chunky.ListTagRules(ctx, &chunky.ListTagRules{
+		Repo: repo,
+	})
+	if err != nil {
+		return err
+	}
+	writer := tabwriter.NewWriter(c.Stdout, 0, 0, 1, ' ', 0)
+	for _, rule := range rules {
+		var kinds []string
+		if rule.Immutable {
+			kinds = append(kinds, "immutable")
+		}
+		if rule.AppendOnly {
+			kinds = append(kinds, "append-only")
+		}
+		users := "everyone"
+		if len(rule.Users) > 0 {
+			users = strings.Join(rule.Users, ", ")
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\n", c.Color.Green(rule.Pattern), strings.Join(kinds, ", "), c.Color.Dim(users))
+	}
+	return writer.Flush()
+}
@@ -0,0 +1,80 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+
+	"github.com/livebud/cli"
+	"github.com/matthewmueller/chunky"
+	"github.com/matthewmueller/chunky/repos"
+)
+
+type ServeUI struct {
+	Repo          string
+	Addr          string
+	LimitDownload *string
+	PackCache     bool
+}
+
+func (s *ServeUI) command(cli cli.Command) cli.Command {
+	cmd := cli.Command("serve-ui", "browse a repository in a web browser")
+	cmd.Arg("repo", "repository to browse").String(&s.Repo)
+	cmd.Flag("addr", "address to listen on").String(&s.Addr).Default(":8080")
+	cmd.Flag("limit-download", "limit bytes per second").Optional().String(&s.LimitDownload)
+	cmd.Flag("pack-cache", "cache downloaded packs on disk").Bool(&s.PackCache).Default(false)
+	return cmd
+}
+
+func (c *CLI) ServeUI(ctx context.Context, in *ServeUI) error {
+	repoUrl, err := repos.Parse(in.Repo)
+	if err != nil {
+		return err
+	}
+	repo, err := c.loadRepoFromUrl(repoUrl)
+	if err != nil {
+		return err
+	}
+
+	// Load the cache for downloaded packs
+	var packCache repos.FS
+	if in.PackCache {
+		if packCache, err = c.loadPackCache(repoUrl); err != nil {
+			return err
+		}
+	}
+
+	// Set the download limit if provided
+	limitDownload := ""
+	if in.LimitDownload != nil {
+		limitDownload = *in.LimitDownload
+	}
+
+	handler, err := c.chunky.UI(&chunky.UI{
+		Repo:          repo,
+		LimitDownload: limitDownload,
+		CacheDir:      packCache,
+	})
+	if err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", in.Addr)
+	if err != nil {
+		return err
+	}
+	server := &http.Server{Handler: handler}
+	c.log.Info("serving ui", slog.String("url", "http://"+listener.Addr().String()))
+
+	// Stop serving once the context is canceled
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -45,10 +45,18 @@ func (c *CLI) Show(ctx context.Context, in *Show) error {
 		return err
 	}
 
+	// Write the message and metadata
+	if message := commit.Message(); message != "" {
+		fmt.Fprintln(c.Stdout, message)
+	}
+	for _, pair := range metaPairs(commit.Meta()) {
+		fmt.Fprintln(c.Stdout, c.Color.Dim(pair))
+	}
+
 	// Write the file tree
-	fsys := virt.Map{}
+	fsys := virt.Tree{}
 	for _, file := range commit.Files() {
-		fsys[file.Path] = ""
+		fsys[file.Path] = &virt.File{Mode: file.Mode}
 	}
 	tree, err := virt.Print(fsys)
 	if err != nil {
@@ -0,0 +1,63 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/livebud/cli"
+	"github.com/matthewmueller/chunky"
+	"github.com/matthewmueller/chunky/internal/humanize"
+)
+
+type TagLog struct {
+	Repo string
+	Tag  string
+}
+
+func (t *TagLog) command(cli cli.Command) cli.Command {
+	cmd := cli.Command("tag-log", "show the history of a tag")
+	cmd.Arg("repo", "repository to show").String(&t.Repo)
+	cmd.Arg("tag", "tag to show").String(&t.Tag)
+	return cmd
+}
+
+func (c *CLI) TagLog(ctx context.Context, in *TagLog) error {
+	repo, err := c.loadRepo(in.Repo)
+	if err != nil {
+		return err
+	}
+	entries, err := c.chunky.TagLog(ctx, &chunky.TagLog{
+		Repo: repo,
+		Tag:  in.Tag,
+	})
+	if err != nil {
+		return err
+	}
+	writer := tabwriter.NewWriter(c.Stdout, 0, 0, 1, ' ', 0)
+	for i, entry := range entries {
+		commitId := entry.Commit.ID()
+		if i == 0 {
+			commitId = c.Color.Green(commitId)
+		}
+		// Fallback to the commit for tags that didn't record who moved them
+		user, movedAt := entry.User, entry.MovedAt
+		if user == "" {
+			user = entry.Commit.User()
+		}
+		if movedAt.IsZero() {
+			movedAt = entry.Commit.CreatedAt()
+		}
+		relTime := humanize.Time(movedAt)
+		var flags []string
+		if entry.Rollback {
+			flags = append(flags, c.Color.Dim("(rollback)"))
+		}
+		if entry.Forced {
+			flags = append(flags, c.Color.Red("(forced)"))
+		}
+		fmt.Fprintf(writer, "%s@{%d}\t%s\t%s\t%s\t%s\n", in.Tag, i, commitId, user, c.Color.Dim(relTime), strings.Join(flags, " "))
+	}
+	return writer.Flush()
+}
@@ -2,6 +2,7 @@ package cli
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/livebud/cli"
 	"github.com/matthewmueller/chunky"
@@ -11,6 +12,10 @@ type Tag struct {
 	Repo     string
 	Revision string
 	Tag      string
+	Delete   bool
+	Rollback bool
+	Force    bool
+	SignKey  string
 }
 
 func (t *Tag) command(cli cli.Command) cli.Command {
@@ -18,19 +23,61 @@ func (t *Tag) command(cli cli.Command) cli.Command {
 	cmd.Arg("repo", "repository to tag").String(&t.Repo)
 	cmd.Arg("tag", "tag to create").String(&t.Tag)
 	cmd.Flag("revision", "revision to tag").String(&t.Revision).Default("latest")
+	cmd.Flag("delete", "delete the tag").Bool(&t.Delete).Default(false)
+	cmd.Flag("rollback", "move the tag back to its previous commit").Bool(&t.Rollback).Default(false)
+	cmd.Flag("force", "override the tag's protection").Bool(&t.Force).Default(false)
+	cmd.Flag("sign-key", "ed25519 private key to sign the tag").String(&t.SignKey).Default("")
 	return cmd
 }
 
 func (c *CLI) Tag(ctx context.Context, in *Tag) error {
+	if in.Delete && in.Rollback {
+		return fmt.Errorf("cli: --delete and --rollback cannot be used together")
+	}
 	// Load the repository
 	repo, err := c.loadRepo(in.Repo)
 	if err != nil {
 		return err
 	}
+	user, err := c.getUser()
+	if err != nil {
+		return err
+	}
+	signingKey, err := loadSigningKey(in.SignKey)
+	if err != nil {
+		return err
+	}
+	// Delete the tag
+	if in.Delete {
+		return c.chunky.DeleteTag(ctx, &chunky.DeleteTag{
+			Repo:  repo,
+			Tag:   in.Tag,
+			User:  user,
+			Force: in.Force,
+		})
+	}
+	// Rollback the tag
+	if in.Rollback {
+		commit, err := c.chunky.RollbackTag(ctx, &chunky.RollbackTag{
+			Repo:       repo,
+			Tag:        in.Tag,
+			User:       user,
+			Force:      in.Force,
+			SigningKey: signingKey,
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(c.Stdout, "%s -> %s\n", c.Color.Green(in.Tag), commit.ID())
+		return nil
+	}
 	// Tag the revision
 	return c.chunky.TagRevision(ctx, &chunky.TagRevision{
-		Repo:     repo,
-		Revision: in.Revision,
-		Tag:      in.Tag,
+		Repo:       repo,
+		Revision:   in.Revision,
+		Tag:        in.Tag,
+		User:       user,
+		Force:      in.Force,
+		SigningKey: signingKey,
 	})
 }
@@ -9,13 +9,24 @@ import (
 )
 
 type Upload struct {
-	From        string
-	To          string
-	Tags        []string
-	Paths       []string
-	Cache       bool
-	LimitUpload string
-	Concurrency *int
+	From          string
+	To            string
+	Tags          []string
+	Paths         []string
+	Parent        string
+	Message       string
+	Meta          []string
+	Force         bool
+	SignKey       string
+	Cache         bool
+	LimitUpload   string
+	Concurrency   *int
+	Owner         bool
+	NumericOwner  bool
+	Xattrs        bool
+	SpecialFiles  string
+	ModifiedFiles string
+	ForceRehash   bool
 }
 
 func (u *Upload) command(cli cli.Command) cli.Command {
@@ -24,6 +35,17 @@ func (u *Upload) command(cli cli.Command) cli.Command {
 	cmd.Arg("repo", "repository to upload to").String(&u.To)
 	cmd.Flag("tags", "tag the revision").Short('t').Optional().Strings(&u.Tags)
 	cmd.Flag("paths", "subpaths to upload").Strings(&u.Paths).Default(".")
+	cmd.Flag("parent", "parent revision").String(&u.Parent).Default("")
+	cmd.Flag("message", "describe the revision").Short('m').String(&u.Message).Default("")
+	cmd.Flag("meta", "attach key=value metadata").Optional().Strings(&u.Meta)
+	cmd.Flag("force", "override tag protection").Bool(&u.Force).Default(false)
+	cmd.Flag("sign-key", "ed25519 private key to sign the revision").String(&u.SignKey).Default("")
+	cmd.Flag("owner", "capture file ownership").Bool(&u.Owner).Default(false)
+	cmd.Flag("numeric-owner", "capture ownership by uid and gid rather than by name").Bool(&u.NumericOwner).Default(false)
+	cmd.Flag("xattrs", "capture extended attributes").Bool(&u.Xattrs).Default(false)
+	cmd.Flag("special-files", "how to handle fifos, sockets and devices: skip, fail or record").String(&u.SpecialFiles).Default("skip")
+	cmd.Flag("modified-files", "how to handle files modified during upload: retry, skip or fail").String(&u.ModifiedFiles).Default("retry")
+	cmd.Flag("force-rehash", "hash every file, even if it looks unchanged").Bool(&u.ForceRehash).Default(false)
 	cmd.Flag("limit-upload", "limit bytes per second").String(&u.LimitUpload).Default("")
 	cmd.Flag("concurrency", "number of concurrent uploads").Optional().Int(&u.Concurrency)
 	return cmd
@@ -55,14 +77,35 @@ func (c *CLI) Upload(ctx context.Context, in *Upload) error {
 		return err
 	}
 
+	meta, err := parseMeta(in.Meta)
+	if err != nil {
+		return err
+	}
+
+	signingKey, err := loadSigningKey(in.SignKey)
+	if err != nil {
+		return err
+	}
+
 	return c.chunky.Upload(ctx, &chunky.Upload{
-		From:        fsys,
-		To:          repo,
-		Tags:        in.Tags,
-		Paths:       in.Paths,
-		User:        user,
-		Cache:       cache,
-		LimitUpload: in.LimitUpload,
-		Concurrency: in.Concurrency,
+		From:           fsys,
+		To:             repo,
+		Tags:           in.Tags,
+		Paths:          in.Paths,
+		Parent:         in.Parent,
+		Message:        in.Message,
+		Meta:           meta,
+		Force:          in.Force,
+		SigningKey:     signingKey,
+		User:           user,
+		Cache:          cache,
+		LimitUpload:    in.LimitUpload,
+		Concurrency:    in.Concurrency,
+		PreserveOwner:  in.Owner || in.NumericOwner,
+		NumericOwner:   in.NumericOwner,
+		PreserveXattrs: in.Xattrs,
+		SpecialFiles:   chunky.SpecialFiles(in.SpecialFiles),
+		ModifiedFiles:  chunky.ModifiedFiles(in.ModifiedFiles),
+		ForceRehash:    in.ForceRehash,
 	})
 }
@@ -0,0 +1,47 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/livebud/cli"
+	"github.com/matthewmueller/chunky"
+)
+
+type Verify struct {
+	Repo       string
+	Revision   string
+	VerifyKeys []string
+}
+
+func (v *Verify) command(cli cli.Command) cli.Command {
+	cmd := cli.Command("verify", "verify a revision's signature")
+	cmd.Arg("repo", "repository to verify").String(&v.Repo)
+	cmd.Arg("revision", "revision to verify").String(&v.Revision)
+	cmd.Flag("verify-key", "public key trusted to sign revisions").Optional().Strings(&v.VerifyKeys)
+	return cmd
+}
+
+func (c *CLI) Verify(ctx context.Context, in *Verify) error {
+	repo, err := c.loadRepo(in.Repo)
+	if err != nil {
+		return err
+	}
+	trustedKeys, err := loadTrustedKeys(in.VerifyKeys)
+	if err != nil {
+		return err
+	} else if len(trustedKeys) == 0 {
+		return errors.New("cli: no trusted keys. Pass --verify-key or add keys to the trusted_keys config file")
+	}
+	commit, err := c.chunky.Verify(ctx, &chunky.Verify{
+		Repo:        repo,
+		Revision:    in.Revision,
+		TrustedKeys: trustedKeys,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.Stdout, "%s %s\n", c.Color.Green("verified"), commit.ID())
+	return nil
+}
@@ -0,0 +1,450 @@
+// Package commitfs is a read-only filesystem view of a commit. The file list
+// comes from the commit and file data is read from the packs when it's needed.
+package commitfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/matthewmueller/chunky/internal/commits"
+	"github.com/matthewmueller/chunky/internal/packs"
+	"github.com/matthewmueller/chunky/internal/sha256"
+	"github.com/matthewmueller/chunky/repos"
+)
+
+// maxSymlinks is the number of symlinks followed before giving up
+const maxSymlinks = 40
+
+// New creates a filesystem for the files in a commit. Packs are read with the
+// context as they're needed, so the filesystem stops reading once the context
+// is canceled.
+func New(ctx context.Context, repo repos.Repo, commit *commits.Commit, pr packs.Reader) *FS {
+	root := &node{name: ".", modTime: commit.CreatedAt()}
+	fsys := &FS{
+		ctx:      ctx,
+		repo:     repo,
+		pr:       pr,
+		root:     root,
+		nodes:    map[string]*node{".": root},
+		verified: map[string]bool{},
+	}
+	for _, file := range commit.Files() {
+		fsys.add(file)
+	}
+	for _, n := range fsys.nodes {
+		sort.Slice(n.children, func(i, j int) bool {
+			return n.children[i].name < n.children[j].name
+		})
+	}
+	return fsys
+}
+
+// FS is a read-only view of the files in a commit
+type FS struct {
+	ctx   context.Context
+	repo  repos.Repo
+	pr    packs.Reader
+	root  *node
+	nodes map[string]*node
+
+	mu sync.Mutex
+	// verified are the blobs whose data has been checked against their hash
+	verified map[string]bool
+}
+
+var (
+	_ fs.FS        = (*FS)(nil)
+	_ fs.ReadDirFS = (*FS)(nil)
+	_ fs.StatFS    = (*FS)(nil)
+)
+
+// node is a file or directory in the commit
+type node struct {
+	name string
+	// file is nil for directories that aren't in the commit, such as the root
+	file     *commits.File
+	modTime  time.Time
+	children []*node
+
+	mu    sync.Mutex
+	chunk *packs.Chunk
+	// ends are the end offsets of the blobs and holes of a chunked file, filled
+	// in as the file is read
+	ends []int64
+}
+
+func (n *node) mode() fs.FileMode {
+	if n.file == nil {
+		return fs.ModeDir | 0755
+	}
+	return n.file.Mode
+}
+
+func (n *node) size() int64 {
+	if n.file == nil || n.file.IsDir() {
+		return 0
+	}
+	return int64(n.file.Size)
+}
+
+// Add a file to the tree, creating the directories that aren't in the commit
+func (f *FS) add(file *commits.File) {
+	if existing, ok := f.nodes[file.Path]; ok {
+		existing.file = file
+		return
+	}
+	parent := f.mkdirAll(path.Dir(file.Path))
+	n := &node{name: path.Base(file.Path), file: file}
+	f.nodes[file.Path] = n
+	parent.children = append(parent.children, n)
+}
+
+func (f *FS) mkdirAll(dir string) *node {
+	if n, ok := f.nodes[dir]; ok {
+		return n
+	}
+	parent := f.mkdirAll(path.Dir(dir))
+	n := &node{name: path.Base(dir), modTime: f.root.modTime}
+	f.nodes[dir] = n
+	parent.children = append(parent.children, n)
+	return n
+}
+
+// Read the file chunk of a node from its pack. Hard links are stored under the
+// path they link to.
+func (f *FS) readChunk(n *node) (*packs.Chunk, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.chunk != nil {
+		return n.chunk, nil
+	}
+	pack, err := f.pr.Read(f.ctx, f.repo, n.file.PackId)
+	if err != nil {
+		return nil, err
+	}
+	chunkPath := n.file.Path
+	if n.file.Link != "" {
+		chunkPath = n.file.Link
+	}
+	fc, ok := pack.Chunk(chunkPath)
+	if !ok {
+		return nil, fmt.Errorf("commitfs: unable to find file %q in pack %q", chunkPath, n.file.PackId)
+	}
+	if fc.Hash != n.file.Id {
+		return nil, fmt.Errorf("commitfs: file %q in pack %q doesn't match the commit", chunkPath, n.file.PackId)
+	}
+	// Check the data of small files and symlinks, chunked files are checked
+	// blob by blob as they're read
+	if len(fc.Refs) == 0 && (fc.Mode.IsRegular() || fc.Mode&fs.ModeSymlink != 0) {
+		if hash := sha256.HashBytes(fc.Mode, fc.Data); hash != fc.Hash {
+			return nil, fmt.Errorf("commitfs: hash mismatch for file %q: expected %s, got %s", chunkPath, fc.Hash, hash)
+		}
+	}
+	n.chunk = fc
+	return fc, nil
+}
+
+// Read the data of a blob from its pack
+func (f *FS) readBlob(ref *packs.Ref) ([]byte, error) {
+	pack, err := f.pr.Read(f.ctx, f.repo, ref.Pack)
+	if err != nil {
+		return nil, err
+	}
+	bc, ok := pack.Chunk(ref.Hash)
+	if !ok {
+		return nil, fmt.Errorf("commitfs: unable to find chunk %q in pack %q", ref.Hash, ref.Pack)
+	}
+	// Blobs are read many times as a file is read, so they're only hashed once
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := ref.Pack + "/" + ref.Hash
+	if !f.verified[key] {
+		if hash := sha256.Hash(bc.Data); hash != ref.Hash {
+			return nil, fmt.Errorf("commitfs: hash mismatch for chunk %q in pack %q: got %s", ref.Hash, ref.Pack, hash)
+		}
+		f.verified[key] = true
+	}
+	return bc.Data, nil
+}
+
+// Locate the blob or hole of a chunked file that contains an offset,
+// returning its index and the offset it starts at. The sizes of blobs aren't
+// recorded, so blobs are read up to the offset the first time it's located.
+func (f *FS) locate(n *node, fc *packs.Chunk, off int64) (i int, start int64, err error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	i = sort.Search(len(n.ends), func(i int) bool { return n.ends[i] > off })
+	for i == len(n.ends) {
+		if i == len(fc.Refs) {
+			return 0, 0, fmt.Errorf("commitfs: file %q is shorter than its size", fc.Path)
+		}
+		size := fc.Refs[i].Hole
+		if size == 0 {
+			data, err := f.readBlob(fc.Refs[i])
+			if err != nil {
+				return 0, 0, err
+			}
+			size = int64(len(data))
+		}
+		end := size
+		if i > 0 {
+			end += n.ends[i-1]
+		}
+		n.ends = append(n.ends, end)
+		if end <= off {
+			i++
+		}
+	}
+	if i > 0 {
+		start = n.ends[i-1]
+	}
+	return i, start, nil
+}
+
+// Find the node at a path, following symlinks when requested
+func (f *FS) find(op, name string, follow bool) (*node, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	fpath := name
+	for i := 0; ; i++ {
+		n, ok := f.nodes[fpath]
+		if !ok {
+			return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+		if !follow || n.mode()&fs.ModeSymlink == 0 {
+			return n, nil
+		}
+		if i == maxSymlinks {
+			return nil, &fs.PathError{Op: op, Path: name, Err: errors.New("too many symlinks")}
+		}
+		fc, err := f.readChunk(n)
+		if err != nil {
+			return nil, &fs.PathError{Op: op, Path: name, Err: err}
+		}
+		link := string(fc.Data)
+		if path.IsAbs(link) {
+			// Absolute symlinks point outside of the commit
+			return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+		fpath = path.Join(path.Dir(fpath), link)
+		if !fs.ValidPath(fpath) {
+			return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+	}
+}
+
+// Open a file in the commit, following symlinks
+func (f *FS) Open(name string) (fs.File, error) {
+	n, err := f.find("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if n.mode().IsDir() {
+		return &dir{fs: f, node: n}, nil
+	}
+	fc, err := f.readChunk(n)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &file{fs: f, node: n, chunk: fc}, nil
+}
+
+// Stat a file in the commit, following symlinks
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	n, err := f.find("stat", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return &fileInfo{f, n}, nil
+}
+
+// Lstat a file in the commit without following symlinks
+func (f *FS) Lstat(name string) (fs.FileInfo, error) {
+	n, err := f.find("lstat", name, false)
+	if err != nil {
+		return nil, err
+	}
+	return &fileInfo{f, n}, nil
+}
+
+// Readlink returns the target of a symlink
+func (f *FS) Readlink(name string) (string, error) {
+	n, err := f.find("readlink", name, false)
+	if err != nil {
+		return "", err
+	}
+	if n.mode()&fs.ModeSymlink == 0 {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	fc, err := f.readChunk(n)
+	if err != nil {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: err}
+	}
+	return string(fc.Data), nil
+}
+
+// ReadDir reads a directory in the commit, sorted by name
+func (f *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	n, err := f.find("readdir", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if !n.mode().IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	return f.dirEntries(n.children), nil
+}
+
+func (f *FS) dirEntries(nodes []*node) []fs.DirEntry {
+	entries := make([]fs.DirEntry, len(nodes))
+	for i, n := range nodes {
+		entries[i] = fs.FileInfoToDirEntry(&fileInfo{f, n})
+	}
+	return entries
+}
+
+// fileInfo describes a node. The modification time is stored in the pack, so
+// it's read when it's first needed.
+type fileInfo struct {
+	fs   *FS
+	node *node
+}
+
+func (i *fileInfo) Name() string      { return i.node.name }
+func (i *fileInfo) Size() int64       { return i.node.size() }
+func (i *fileInfo) Mode() fs.FileMode { return i.node.mode() }
+func (i *fileInfo) IsDir() bool       { return i.node.mode().IsDir() }
+func (i *fileInfo) Sys() any          { return nil }
+
+// ModTime returns the modification time recorded in the commit, falling back
+// to the time in the pack for older commits. Returns the zero time if the pack
+// can't be read.
+func (i *fileInfo) ModTime() time.Time {
+	if i.node.file == nil {
+		return i.node.modTime
+	}
+	if i.node.file.ModTime != 0 {
+		return time.Unix(i.node.file.ModTime, 0)
+	}
+	fc, err := i.fs.readChunk(i.node)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(fc.ModTime, 0)
+}
+
+// file is an open file. Chunked files are read from their blobs, so reads can
+// seek without reading the blobs that come before.
+type file struct {
+	fs     *FS
+	node   *node
+	chunk  *packs.Chunk
+	offset int64
+}
+
+var (
+	_ io.ReaderAt = (*file)(nil)
+	_ io.Seeker   = (*file)(nil)
+)
+
+func (f *file) Stat() (fs.FileInfo, error) { return &fileInfo{f.fs, f.node}, nil }
+func (f *file) Close() error               { return nil }
+
+func (f *file) Read(p []byte) (int, error) {
+	n, err := f.ReadAt(p, f.offset)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.chunk.Size
+	default:
+		return 0, &fs.PathError{Op: "seek", Path: f.chunk.Path, Err: fs.ErrInvalid}
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.chunk.Path, Err: fs.ErrInvalid}
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *file) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, &fs.PathError{Op: "read", Path: f.chunk.Path, Err: fs.ErrInvalid}
+	}
+	fc := f.chunk
+	if off >= fc.Size {
+		return 0, io.EOF
+	}
+	// Small files are stored in one chunk
+	if len(fc.Refs) == 0 {
+		n = copy(p, fc.Data[off:])
+		if n < len(p) {
+			return n, io.EOF
+		}
+		return n, nil
+	}
+	for n < len(p) && off < fc.Size {
+		i, start, err := f.fs.locate(f.node, fc, off)
+		if err != nil {
+			return n, err
+		}
+		ref := fc.Refs[i]
+		var copied int
+		if ref.Hole > 0 {
+			copied = int(min(int64(len(p)-n), start+ref.Hole-off))
+			clear(p[n : n+copied])
+		} else {
+			data, err := f.fs.readBlob(ref)
+			if err != nil {
+				return n, err
+			}
+			copied = copy(p[n:], data[off-start:])
+		}
+		n += copied
+		off += int64(copied)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// dir is an open directory
+type dir struct {
+	fs     *FS
+	node   *node
+	offset int
+}
+
+func (d *dir) Stat() (fs.FileInfo, error) { return &fileInfo{d.fs, d.node}, nil }
+func (d *dir) Close() error               { return nil }
+
+func (d *dir) Read(p []byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.node.name, Err: errors.New("is a directory")}
+}
+
+func (d *dir) ReadDir(count int) ([]fs.DirEntry, error) {
+	children := d.node.children[d.offset:]
+	if count > 0 && len(children) > count {
+		children = children[:count]
+	}
+	if count > 0 && len(children) == 0 {
+		return nil, io.EOF
+	}
+	d.offset += len(children)
+	return d.fs.dirEntries(children), nil
+}
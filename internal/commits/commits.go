@@ -16,6 +16,8 @@ import (
 	"time"
 
 	"github.com/klauspost/compress/zstd"
+	"github.com/matthewmueller/chunky/internal/signatures"
+	"github.com/matthewmueller/chunky/internal/tags"
 	"github.com/matthewmueller/chunky/internal/timeid"
 	"github.com/matthewmueller/chunky/repos"
 )
@@ -28,10 +30,14 @@ func New(user string, createdAt time.Time) *Commit {
 }
 
 type Commit struct {
-	user      string
-	createdAt time.Time
-	size      uint64
-	files     []*File
+	user       string
+	createdAt  time.Time
+	parent     string
+	parentHash string
+	message    string
+	meta       map[string]string
+	size       uint64
+	files      []*File
 }
 
 func (c *Commit) Files() (files []*File) {
@@ -78,12 +84,76 @@ func (c *Commit) User() string {
 	return c.user
 }
 
+// Parent returns the ID of the parent commit or an empty string if this is the
+// first commit in the history
+func (c *Commit) Parent() string {
+	return c.parent
+}
+
+// ParentHash returns the hash of the parent commit at the time this commit was
+// created
+func (c *Commit) ParentHash() string {
+	return c.parentHash
+}
+
+// SetParent links the commit to its parent. The parent's hash is recorded so
+// that changes to the parent can be detected later on.
+func (c *Commit) SetParent(parent *Commit) {
+	c.parent = parent.ID()
+	c.parentHash = parent.Hash()
+}
+
+// Message returns the commit message
+func (c *Commit) Message() string {
+	return c.message
+}
+
+// SetMessage sets the commit message
+func (c *Commit) SetMessage(message string) {
+	c.message = message
+}
+
+// Meta returns the key/value metadata attached to the commit
+func (c *Commit) Meta() map[string]string {
+	return c.meta
+}
+
+// SetMeta attaches a key/value pair to the commit
+func (c *Commit) SetMeta(key, value string) {
+	if c.meta == nil {
+		c.meta = map[string]string{}
+	}
+	c.meta[key] = value
+}
+
+// HasMeta returns true if the commit has the key set to the value
+func (c *Commit) HasMeta(key, value string) bool {
+	v, ok := c.meta[key]
+	return ok && v == value
+}
+
+// Hash returns a sha256 hash of the commit's contents. Since each commit
+// includes its parent's hash, the hash also covers the commit's history.
+func (c *Commit) Hash() string {
+	data, err := json.Marshal(c.state())
+	if err != nil {
+		// This should never happen since the state is always serializable
+		panic(fmt.Sprintf("commits: unable to marshal commit: %v", err))
+	}
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
 type commitState struct {
-	User      string    `json:"user,omitempty"`
-	CreatedAt time.Time `json:"created_at,omitempty"`
-	Checksum  string    `json:"checksum,omitempty"`
-	Size      uint64    `json:"size,omitempty"`
-	Files     []*File   `json:"files,omitempty"`
+	User       string            `json:"user,omitempty"`
+	CreatedAt  time.Time         `json:"created_at,omitempty"`
+	Parent     string            `json:"parent,omitempty"`
+	ParentHash string            `json:"parent_hash,omitempty"`
+	Message    string            `json:"message,omitempty"`
+	Meta       map[string]string `json:"meta,omitempty"`
+	Checksum   string            `json:"checksum,omitempty"`
+	Size       uint64            `json:"size,omitempty"`
+	Files      []*File           `json:"files,omitempty"`
 }
 
 func (c *commitState) Verify() error {
@@ -98,10 +168,25 @@ func (c *commitState) Verify() error {
 }
 
 type File struct {
-	Path   string `json:"path,omitempty"`
-	Size   uint64 `json:"size,omitempty"`
-	Id     string `json:"id,omitempty"`
-	PackId string `json:"pack_id,omitempty"`
+	Path   string      `json:"path,omitempty"`
+	Size   uint64      `json:"size,omitempty"`
+	Id     string      `json:"id,omitempty"`
+	PackId string      `json:"pack_id,omitempty"`
+	Mode   fs.FileMode `json:"mode,omitempty"`
+	// Attrs is a hash of the captured ownership and extended attributes
+	Attrs string `json:"attrs,omitempty"`
+	// Link is the path of the file this file is hard linked to. The data is
+	// stored under that path in the pack.
+	Link string `json:"link,omitempty"`
+	// ModTime is the modification time in seconds, like the time in packs. It's
+	// recorded in the commit too since unchanged data is reused from packs
+	// uploaded with an older time. Commits from older versions don't have it.
+	ModTime int64 `json:"modtime,omitempty"`
+}
+
+// IsDir returns true if the file is a directory
+func (f *File) IsDir() bool {
+	return f.Mode.IsDir()
 }
 
 func (c *Commit) Add(file *File) {
@@ -109,7 +194,10 @@ func (c *Commit) Add(file *File) {
 		return
 	}
 	c.files = append(c.files, file)
-	c.size += file.Size
+	// Hard links share their data, so only count it once
+	if file.Link == "" {
+		c.size += file.Size
+	}
 }
 
 func (c *Commit) state() *commitState {
@@ -119,11 +207,15 @@ func (c *Commit) state() *commitState {
 		checksum.Write([]byte(file.Id))
 	}
 	return &commitState{
-		User:      c.user,
-		CreatedAt: c.createdAt,
-		Checksum:  hex.EncodeToString(checksum.Sum(nil)),
-		Size:      c.size,
-		Files:     c.files,
+		User:       c.user,
+		CreatedAt:  c.createdAt,
+		Parent:     c.parent,
+		ParentHash: c.parentHash,
+		Message:    c.message,
+		Meta:       c.meta,
+		Checksum:   hex.EncodeToString(checksum.Sum(nil)),
+		Size:       c.size,
+		Files:      c.files,
 	}
 }
 
@@ -172,10 +264,14 @@ func Unpack(data []byte) (*Commit, error) {
 		return nil, err
 	}
 	return &Commit{
-		user:      state.User,
-		createdAt: state.CreatedAt,
-		size:      state.Size,
-		files:     state.Files,
+		user:       state.User,
+		createdAt:  state.CreatedAt,
+		parent:     state.Parent,
+		parentHash: state.ParentHash,
+		message:    state.Message,
+		meta:       state.Meta,
+		size:       state.Size,
+		files:      state.Files,
 	}, nil
 }
 
@@ -186,15 +282,20 @@ func resolveRevision(ctx context.Context, repo repos.Repo, revision string) (sha
 	} else if !errors.Is(err, fs.ErrNotExist) {
 		return "", fmt.Errorf("commits: unable to download commit: %w", err)
 	}
-	// Try to download the tag
-	tag, err := repos.Download(ctx, repo, path.Join("tags", revision))
+	// Try to download the tag, supporting "<tag>@{n}" to go back n moves
+	name, n := tags.ParseRevision(revision)
+	tag, err := tags.Read(ctx, repo, name)
 	if err != nil {
 		if errors.Is(err, fs.ErrNotExist) {
-			return "", fmt.Errorf("commits: revision not found: %s", revision)
+			return "", fmt.Errorf("commits: revision not found: %s: %w", revision, fs.ErrNotExist)
 		}
 		return "", fmt.Errorf("commits: unable to download tag: %w", err)
 	}
-	return string(tag.Data), nil
+	commitId, ok := tag.At(n)
+	if !ok {
+		return "", fmt.Errorf("commits: revision not found: %s: %w", revision, fs.ErrNotExist)
+	}
+	return commitId, nil
 }
 
 func read(ctx context.Context, repo repos.Repo, path string) (*Commit, error) {
@@ -213,11 +314,58 @@ func Read(ctx context.Context, repo repos.Repo, revision string) (*Commit, error
 	return read(ctx, repo, path.Join("commits", commitSha))
 }
 
+// Log walks the parent chain starting at the revision, returning the commits
+// from newest to oldest. An error is returned if a parent is missing or has
+// changed since its child was created.
+func Log(ctx context.Context, repo repos.Repo, revision string) (commits []*Commit, err error) {
+	return LogCached(ctx, repo, revision, func(string) (*Commit, bool) { return nil, false })
+}
+
+// LogCached is like Log, but looks up commits with cached before downloading
+// them
+func LogCached(ctx context.Context, repo repos.Repo, revision string, cached func(commitId string) (*Commit, bool)) (commits []*Commit, err error) {
+	readCommit := func(commitId string) (*Commit, error) {
+		if commit, ok := cached(commitId); ok {
+			return commit, nil
+		}
+		return read(ctx, repo, path.Join("commits", commitId))
+	}
+	commit, ok := cached(revision)
+	if !ok {
+		commitId, err := resolveRevision(ctx, repo, revision)
+		if err != nil {
+			return nil, fmt.Errorf("commits: unable to resolve revision %q: %w", revision, err)
+		}
+		if commit, err = readCommit(commitId); err != nil {
+			return nil, err
+		}
+	}
+	seen := map[string]bool{}
+	for {
+		commits = append(commits, commit)
+		seen[commit.ID()] = true
+		if commit.parent == "" {
+			return commits, nil
+		}
+		if seen[commit.parent] {
+			return commits, fmt.Errorf("commits: cycle detected at commit %q", commit.parent)
+		}
+		parent, err := readCommit(commit.parent)
+		if err != nil {
+			return commits, fmt.Errorf("commits: unable to read parent %q of %q: %w", commit.parent, commit.ID(), err)
+		}
+		if commit.parentHash != "" && parent.Hash() != commit.parentHash {
+			return commits, fmt.Errorf("commits: parent %q of %q has been modified", commit.parent, commit.ID())
+		}
+		commit = parent
+	}
+}
+
 func ReadAll(ctx context.Context, repo repos.Repo) (commits []*Commit, err error) {
 	if err := repo.Walk(ctx, "commits", func(fpath string, de fs.DirEntry, err error) error {
 		if err != nil {
 			return err
-		} else if de.IsDir() {
+		} else if de.IsDir() || signatures.Is(fpath) {
 			return nil
 		}
 		commit, err := read(ctx, repo, fpath)
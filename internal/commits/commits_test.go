@@ -0,0 +1,94 @@
+package commits_test
+
+import (
+	"context"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"github.com/matthewmueller/chunky/internal/commits"
+	"github.com/matthewmueller/chunky/repos"
+	"github.com/matthewmueller/chunky/repos/local"
+	"github.com/matthewmueller/virt"
+)
+
+func upload(ctx context.Context, repo repos.Repo, commit *commits.Commit) error {
+	data, err := commit.Pack()
+	if err != nil {
+		return err
+	}
+	fileCh := make(chan *repos.File, 2)
+	fileCh <- &repos.File{
+		Path: path.Join("commits", commit.ID()),
+		Data: data,
+		Mode: 0644,
+	}
+	fileCh <- &repos.File{
+		Path: path.Join("tags", "latest"),
+		Data: []byte(commit.ID()),
+		Mode: 0644,
+	}
+	close(fileCh)
+	return repo.Upload(ctx, fileCh)
+}
+
+func TestLog(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	repo := local.New(virt.Tree{})
+	now := time.Now().UTC()
+
+	first := commits.New("alice", now.Add(-2*time.Second))
+	first.Add(&commits.File{Path: "a.txt", Id: "a1", PackId: "p1", Size: 1})
+	is.NoErr(upload(ctx, repo, first))
+
+	second := commits.New("bob", now.Add(-time.Second))
+	second.Add(&commits.File{Path: "a.txt", Id: "a2", PackId: "p2", Size: 1})
+	second.SetParent(first)
+	is.NoErr(upload(ctx, repo, second))
+
+	third := commits.New("alice", now)
+	third.Add(&commits.File{Path: "a.txt", Id: "a3", PackId: "p3", Size: 1})
+	third.SetParent(second)
+	is.NoErr(upload(ctx, repo, third))
+
+	history, err := commits.Log(ctx, repo, "latest")
+	is.NoErr(err)
+	is.Equal(len(history), 3)
+	is.Equal(history[0].ID(), third.ID())
+	is.Equal(history[1].ID(), second.ID())
+	is.Equal(history[2].ID(), first.ID())
+	is.Equal(history[2].Parent(), "")
+
+	history, err = commits.Log(ctx, repo, second.ID())
+	is.NoErr(err)
+	is.Equal(len(history), 2)
+	is.Equal(history[0].ID(), second.ID())
+}
+
+func TestLogTampered(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	repo := local.New(virt.Tree{})
+	now := time.Now().UTC()
+
+	first := commits.New("alice", now.Add(-time.Second))
+	first.Add(&commits.File{Path: "a.txt", Id: "a1", PackId: "p1", Size: 1})
+	is.NoErr(upload(ctx, repo, first))
+
+	second := commits.New("bob", now)
+	second.Add(&commits.File{Path: "a.txt", Id: "a2", PackId: "p2", Size: 1})
+	second.SetParent(first)
+	is.NoErr(upload(ctx, repo, second))
+
+	// Overwrite the first commit with different contents
+	tampered := commits.New("mallory", first.CreatedAt())
+	tampered.Add(&commits.File{Path: "a.txt", Id: "evil", PackId: "p1", Size: 1})
+	is.NoErr(upload(ctx, repo, tampered))
+	is.NoErr(upload(ctx, repo, second))
+
+	history, err := commits.Log(ctx, repo, "latest")
+	is.True(err != nil)
+	is.Equal(len(history), 1)
+}
@@ -6,25 +6,51 @@ import (
 	"fmt"
 	"io"
 	"io/fs"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/matthewmueller/chunky/internal/commits"
+	"github.com/matthewmueller/chunky/internal/owners"
 	"github.com/matthewmueller/chunky/internal/packs"
 	"github.com/matthewmueller/chunky/internal/sha256"
 	"github.com/matthewmueller/chunky/repos"
 	"golang.org/x/sync/errgroup"
 )
 
-func New(pr packs.Reader) *Downloader {
+func New(log *slog.Logger, pr packs.Reader) *Downloader {
 	return &Downloader{
-		pr: pr,
+		log:    log,
+		pr:     pr,
+		owners: owners.New(),
+
+		Prefetch: 4,
 	}
 }
 
 type Downloader struct {
+	log         *slog.Logger
 	pr          packs.Reader
 	Concurrency int
+	// Prefetch is the number of packs downloaded ahead of the files being
+	// written. Prefetched packs are held in memory until their files are written.
+	Prefetch int
+	// PreserveModTime sets the modification time of downloaded files to the
+	// modification time recorded in the commit
+	PreserveModTime bool
+	// PreserveOwner restores the ownership recorded in the commit. Changing
+	// ownership usually requires root, so permission errors are ignored.
+	PreserveOwner bool
+	// NumericOwner restores the recorded uid and gid, ignoring the user and
+	// group names
+	NumericOwner bool
+	// PreserveXattrs restores the extended attributes recorded in the commit
+	PreserveXattrs bool
+
+	owners *owners.Lookup
 }
 
 // Download a revision from a repo to a filesystem
@@ -33,44 +59,180 @@ func (d *Downloader) Download(ctx context.Context, from repos.Repo, revision str
 	if err != nil {
 		return fmt.Errorf("downloads: unable to load commit %q: %w", revision, err)
 	}
-	// Download the files concurrently in batches based on the number of CPUs
-	// TODO: consider simplifying with buffered channels
-	for _, files := range splitFiles(commit.Files(), d.Concurrency) {
-		eg, ctx := errgroup.WithContext(ctx)
-		for _, file := range files {
-			eg.Go(func() error {
-				return d.downloadFile(ctx, from, to, file)
-			})
+	return d.DownloadCommit(ctx, from, commit, to)
+}
+
+// DownloadCommit downloads the files in a commit from a repo to a filesystem
+func (d *Downloader) DownloadCommit(ctx context.Context, from repos.Repo, commit *commits.Commit, to repos.FS) error {
+	revision := commit.ID()
+	dirs := &dirList{}
+	// Hard links are created once the files they link to have been written
+	var links []*commits.File
+	var packList []*commits.Pack
+	for _, pack := range commit.Packs() {
+		var files []*commits.File
+		for _, file := range pack.Files {
+			if file.Link != "" {
+				links = append(links, file)
+				continue
+			}
+			files = append(files, file)
+		}
+		if len(files) > 0 {
+			packList = append(packList, &commits.Pack{ID: pack.ID, Files: files})
 		}
-		if err := eg.Wait(); err != nil {
+	}
+	if err := d.downloadPacks(ctx, from, to, dirs, packList); err != nil {
+		return fmt.Errorf("downloads: unable to download revision %q: %w", revision, err)
+	}
+	for _, link := range links {
+		if err := d.downloadLink(ctx, from, to, link); err != nil {
 			return fmt.Errorf("downloads: unable to download revision %q: %w", revision, err)
 		}
 	}
+	// Restore the directory modes and times once their contents are written
+	if err := d.restoreDirs(to, dirs.chunks); err != nil {
+		return fmt.Errorf("downloads: unable to download revision %q: %w", revision, err)
+	}
 	return nil
 }
 
-func splitFiles(files []*commits.File, size int) [][]*commits.File {
-	if size == 0 {
-		return [][]*commits.File{files}
+// dirList collects the directories of a commit while downloading
+type dirList struct {
+	mu     sync.Mutex
+	chunks []*packs.Chunk
+}
+
+func (l *dirList) Add(chunk *packs.Chunk) {
+	l.mu.Lock()
+	l.chunks = append(l.chunks, chunk)
+	l.mu.Unlock()
+}
+
+// restoreDirs applies the directory modes and modification times. Children are
+// restored before their parents, so writing into a directory doesn't change its
+// modification time after it's been restored.
+func (d *Downloader) restoreDirs(to repos.FS, dirs []*packs.Chunk) error {
+	sort.Slice(dirs, func(i, j int) bool {
+		return dirs[i].Path > dirs[j].Path
+	})
+	for _, dir := range dirs {
+		if err := d.restoreAttrs(to, dir); err != nil {
+			return err
+		}
+		if err := repos.Chmod(to, dir.Path, chmodMode(dir.Mode)); err != nil && !errors.Is(err, errors.ErrUnsupported) {
+			return fmt.Errorf("cli: unable to set mode of directory %q: %w", dir.Path, err)
+		}
+		if err := d.setModTime(to, dir, repos.Chtimes); err != nil {
+			return err
+		}
 	}
-	var buckets [][]*commits.File
-	for i := 0; i < len(files); i += size {
-		buckets = append(buckets, files[i:min(i+size, len(files))])
+	return nil
+}
+
+// prefetch is a pack that's downloaded ahead of writing its files
+type prefetch struct {
+	files []*commits.File
+	pack  *packs.Pack
+	err   error
+	done  chan struct{}
+}
+
+// Download the files pack by pack. Packs are downloaded ahead of the writers,
+// which write the files of each pack while the next packs download. Writers
+// move on to the next file as soon as they finish, so one large file doesn't
+// hold up the rest.
+func (d *Downloader) downloadPacks(ctx context.Context, from repos.Repo, to repos.FS, dirs *dirList, packList []*commits.Pack) error {
+	eg, ctx := errgroup.WithContext(ctx)
+
+	// Download the packs in order. The channel's buffer limits how far ahead of
+	// the writers the downloads get.
+	prefetched := make(chan *prefetch, max(d.Prefetch-1, 0))
+	eg.Go(func() error {
+		defer close(prefetched)
+		for _, pack := range packList {
+			p := &prefetch{files: pack.Files, done: make(chan struct{})}
+			select {
+			case prefetched <- p:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			eg.Go(func() error {
+				defer close(p.done)
+				p.pack, p.err = d.pr.Read(ctx, from, pack.ID)
+				if p.err != nil {
+					return fmt.Errorf("cli: unable to download pack %q: %w", pack.ID, p.err)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+
+	// Hand the files of each pack to the writers as the pack arrives
+	var slots chan struct{}
+	if d.Concurrency > 0 {
+		slots = make(chan struct{}, d.Concurrency)
 	}
-	return buckets
+	eg.Go(func() error {
+		for p := range prefetched {
+			select {
+			case <-p.done:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			if p.err != nil {
+				return nil
+			}
+			for _, file := range p.files {
+				if slots != nil {
+					select {
+					case slots <- struct{}{}:
+					case <-ctx.Done():
+						return ctx.Err()
+					}
+				}
+				eg.Go(func() error {
+					if slots != nil {
+						defer func() { <-slots }()
+					}
+					fc, err := fileChunk(p.pack, file)
+					if err != nil {
+						return err
+					}
+					return d.downloadChunk(ctx, from, to, dirs, fc)
+				})
+			}
+		}
+		return nil
+	})
+
+	return eg.Wait()
 }
 
-func (d *Downloader) downloadFile(ctx context.Context, from repos.Repo, to repos.FS, cf *commits.File) error {
-	// Load the pack that contains the file chunk
-	pack, err := d.pr.Read(ctx, from, cf.PackId)
+func (d *Downloader) downloadFile(ctx context.Context, from repos.Repo, to repos.FS, dirs *dirList, cf *commits.File) error {
+	fc, err := d.readChunk(ctx, from, cf)
 	if err != nil {
-		return fmt.Errorf("cli: unable to download pack %q: %w", cf.PackId, err)
+		return err
 	}
+	return d.downloadChunk(ctx, from, to, dirs, fc)
+}
 
-	// Find the file chunk within the pack
-	fc, ok := pack.Chunk(cf.Path)
-	if !ok {
-		return fmt.Errorf("cli: unable to find file %q in pack %q", cf.Path, cf.PackId)
+// Write a file chunk to the filesystem, downloading its blobs as necessary
+func (d *Downloader) downloadChunk(ctx context.Context, from repos.Repo, to repos.FS, dirs *dirList, fc *packs.Chunk) error {
+	// Create the directory. The mode and times are restored after the files
+	// within it have been written.
+	if fc.Mode.IsDir() {
+		if err := to.MkdirAll(fc.Path, 0755); err != nil {
+			return fmt.Errorf("cli: unable to create directory %q: %w", fc.Path, err)
+		}
+		dirs.Add(fc)
+		return nil
+	}
+
+	// Create the FIFO, socket or device
+	if repos.IsSpecial(fc.Mode) {
+		return d.downloadSpecial(to, fc)
 	}
 
 	if fc.Mode&fs.ModeSymlink != 0 {
@@ -89,7 +251,7 @@ func (d *Downloader) downloadFile(ctx context.Context, from repos.Repo, to repos
 				return fmt.Errorf("cli: unable to create symlink %q: %w", fc.Path, err)
 			}
 		}
-		return nil
+		return d.setModTime(to, fc, repos.Lchtimes)
 	}
 
 	// Create the file
@@ -106,9 +268,75 @@ func (d *Downloader) downloadFile(ctx context.Context, from repos.Repo, to repos
 			return fmt.Errorf("cli: unable to create file %q: %w", fc.Path, err)
 		}
 	}
-	defer file.Close()
+	// Only files we opened ourselves skip over holes. Other writers, like a
+	// pipe or a file opened for appending, get zeros.
+	var w io.Writer = file
+	if sw, ok := file.(sparseWriter); ok {
+		w = &sparseFile{sw}
+	}
+	if err := d.writeFile(ctx, from, w, fc); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("cli: unable to close file %q: %w", fc.Path, err)
+	}
 
-	return d.writeFile(ctx, from, file, fc)
+	if err := d.restoreAttrs(to, fc); err != nil {
+		return err
+	}
+	return d.setModTime(to, fc, repos.Chtimes)
+}
+
+// restoreAttrs applies the recorded ownership and extended attributes. Changing
+// ownership clears setuid bits and capabilities, so the mode is reapplied and
+// the extended attributes are set afterwards.
+func (d *Downloader) restoreAttrs(to repos.FS, fc *packs.Chunk) error {
+	isSymlink := fc.Mode&fs.ModeSymlink != 0
+	if d.PreserveOwner && fc.Owner != nil {
+		uid, gid := d.owners.IDs(fc.Owner, d.NumericOwner)
+		if err := repos.Lchown(to, fc.Path, uid, gid); err != nil {
+			if !errors.Is(err, errors.ErrUnsupported) && !errors.Is(err, fs.ErrPermission) {
+				return fmt.Errorf("cli: unable to change owner of %q: %w", fc.Path, err)
+			}
+		} else if !isSymlink && !fc.Mode.IsDir() {
+			if err := repos.Chmod(to, fc.Path, chmodMode(fc.Mode)); err != nil && !errors.Is(err, errors.ErrUnsupported) {
+				return fmt.Errorf("cli: unable to set mode of %q: %w", fc.Path, err)
+			}
+		}
+	}
+	if d.PreserveXattrs {
+		for attr, value := range fc.Xattrs {
+			if err := repos.Lsetxattr(to, fc.Path, attr, value); err != nil {
+				if !errors.Is(err, errors.ErrUnsupported) && !errors.Is(err, fs.ErrPermission) {
+					return fmt.Errorf("cli: unable to set extended attribute %q on %q: %w", attr, fc.Path, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// chmodMode returns the permission and special bits of a mode
+func chmodMode(mode fs.FileMode) fs.FileMode {
+	return mode.Perm() | mode&(fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky)
+}
+
+// setModTime applies the recorded modification time to a downloaded file.
+// Times are recorded with second precision. Filesystems that can't change
+// times are left alone.
+func (d *Downloader) setModTime(to repos.FS, fc *packs.Chunk, chtimes func(repos.FS, string, time.Time, time.Time) error) error {
+	if !d.PreserveModTime {
+		return nil
+	}
+	mtime := time.Unix(fc.ModTime, 0)
+	if err := chtimes(to, fc.Path, mtime, mtime); err != nil {
+		if errors.Is(err, errors.ErrUnsupported) {
+			return nil
+		}
+		return fmt.Errorf("cli: unable to set modification time of %q: %w", fc.Path, err)
+	}
+	return nil
 }
 
 // Cat file data from a repo to a writer
@@ -118,26 +346,107 @@ func (d *Downloader) Cat(ctx context.Context, w io.Writer, repo repos.Repo, revi
 	if err != nil {
 		return fmt.Errorf("downloads: unable to load commit %q: %w", revision, err)
 	}
+	return d.CatCommit(ctx, w, repo, commit, path)
+}
 
+// CatCommit writes a file within a commit to a writer
+func (d *Downloader) CatCommit(ctx context.Context, w io.Writer, repo repos.Repo, commit *commits.Commit, path string) error {
 	// Find the file within the commit
 	cf, ok := commit.File(path)
 	if !ok {
-		return fmt.Errorf("downloads: unable to find file %q in commit %q", path, revision)
+		return fmt.Errorf("downloads: unable to find file %q in commit %q", path, commit.ID())
+	}
+	if cf.IsDir() {
+		return fmt.Errorf("downloads: %q is a directory in commit %q", path, commit.ID())
+	}
+	if repos.IsSpecial(cf.Mode) {
+		return fmt.Errorf("downloads: %q is a special file in commit %q", path, commit.ID())
+	}
+
+	fc, err := d.readChunk(ctx, repo, cf)
+	if err != nil {
+		return err
+	}
+
+	return d.writeFile(ctx, repo, w, fc)
+}
+
+// Create a special file. Devices can only be created with sufficient
+// privileges, so they're skipped with a warning otherwise.
+func (d *Downloader) downloadSpecial(to repos.FS, fc *packs.Chunk) error {
+	if err := to.RemoveAll(fc.Path); err != nil {
+		return fmt.Errorf("cli: unable to remove existing file %q: %w", fc.Path, err)
+	}
+	if err := to.MkdirAll(filepath.Dir(fc.Path), 0755); err != nil {
+		return fmt.Errorf("cli: unable to create directory %q: %w", fc.Path, err)
+	}
+	if err := repos.Mknod(to, fc.Path, fc.Mode, fc.Major, fc.Minor); err != nil {
+		if errors.Is(err, errors.ErrUnsupported) || errors.Is(err, fs.ErrPermission) {
+			d.log.Warn("skipping special file",
+				slog.String("path", fc.Path),
+				slog.String("mode", fc.Mode.String()),
+				slog.String("error", err.Error()),
+			)
+			return nil
+		}
+		return fmt.Errorf("cli: unable to create special file %q: %w", fc.Path, err)
+	}
+	if err := d.restoreAttrs(to, fc); err != nil {
+		return err
+	}
+	return d.setModTime(to, fc, repos.Lchtimes)
+}
+
+// Create a hard link to a file that's already been downloaded. Falls back to
+// downloading a copy when the filesystem can't link the files.
+func (d *Downloader) downloadLink(ctx context.Context, from repos.Repo, to repos.FS, cf *commits.File) error {
+	if err := to.RemoveAll(cf.Path); err != nil {
+		return fmt.Errorf("cli: unable to remove existing file %q: %w", cf.Path, err)
+	}
+	if err := to.MkdirAll(filepath.Dir(cf.Path), 0755); err != nil {
+		return fmt.Errorf("cli: unable to create directory %q: %w", cf.Path, err)
+	}
+	if err := repos.Link(to, cf.Link, cf.Path); err == nil {
+		return nil
 	}
+	return d.downloadFile(ctx, from, to, nil, cf)
+}
 
+// Read the file chunk of a commit file from its pack. Hard links are stored
+// under the path they link to, so the chunk is moved to the link's path.
+func (d *Downloader) readChunk(ctx context.Context, repo repos.Repo, cf *commits.File) (*packs.Chunk, error) {
 	// Load the pack that contains the file chunk
 	pack, err := d.pr.Read(ctx, repo, cf.PackId)
 	if err != nil {
-		return fmt.Errorf("cli: unable to download pack %q: %w", cf.PackId, err)
+		return nil, fmt.Errorf("cli: unable to download pack %q: %w", cf.PackId, err)
 	}
+	return fileChunk(pack, cf)
+}
 
-	// Find the file chunk within the pack
-	fc, ok := pack.Chunk(cf.Path)
+// Find the file chunk of a commit file within its pack
+func fileChunk(pack *packs.Pack, cf *commits.File) (*packs.Chunk, error) {
+	chunkPath := cf.Path
+	if cf.Link != "" {
+		chunkPath = cf.Link
+	}
+	fc, ok := pack.Chunk(chunkPath)
 	if !ok {
-		return fmt.Errorf("cli: unable to find file %q in pack %q", cf.Path, cf.PackId)
+		return nil, fmt.Errorf("cli: unable to find file %q in pack %q", chunkPath, cf.PackId)
 	}
-
-	return d.writeFile(ctx, repo, w, fc)
+	if fc.Hash != cf.Id {
+		return nil, fmt.Errorf("cli: file %q in pack %q doesn't match the commit", chunkPath, cf.PackId)
+	}
+	// Chunks are shared between files, so they're copied before they're changed
+	if cf.Link != "" || (cf.ModTime != 0 && cf.ModTime != fc.ModTime) {
+		moved := *fc
+		moved.Path = cf.Path
+		// The commit has the newer time when the data was reused from a pack
+		if cf.ModTime != 0 {
+			moved.ModTime = cf.ModTime
+		}
+		fc = &moved
+	}
+	return fc, nil
 }
 
 // Write file data to a writer, downloading chunks as necessary and checking hashes
@@ -157,7 +466,17 @@ func (d *Downloader) writeFile(ctx context.Context, repo repos.Repo, w io.Writer
 	}
 
 	// Write the chunks one-by-one to the writer
+	endsInHole := false
 	for _, ref := range fc.Refs {
+		if ref.Hole > 0 {
+			if err := writeHole(w, ref.Hole); err != nil {
+				return fmt.Errorf("cli: unable to write hole in file %q: %w", fc.Path, err)
+			}
+			hash.Zeros(ref.Hole)
+			endsInHole = true
+			continue
+		}
+		endsInHole = false
 		pack, err := d.pr.Read(ctx, repo, ref.Pack)
 		if err != nil {
 			return fmt.Errorf("cli: unable to download pack %q: %w", ref.Pack, err)
@@ -174,6 +493,13 @@ func (d *Downloader) writeFile(ctx context.Context, repo repos.Repo, w io.Writer
 		}
 	}
 
+	// Seeking past the end doesn't extend the file, so extend it to its full size
+	if sf, ok := w.(*sparseFile); ok && endsInHole {
+		if err := sf.Truncate(fc.Size); err != nil {
+			return fmt.Errorf("cli: unable to extend file %q: %w", fc.Path, err)
+		}
+	}
+
 	// Check the hash
 	if hash.String() != fc.Hash {
 		return fmt.Errorf("cli: hash mismatch for chunked file %q: expected %s, got %s", fc.Path, fc.Hash, hash.String())
@@ -181,3 +507,36 @@ func (d *Downloader) writeFile(ctx context.Context, repo repos.Repo, w io.Writer
 
 	return nil
 }
+
+// sparseWriter is implemented by files that can skip over holes, leaving them
+// unallocated on filesystems that support sparse files
+type sparseWriter interface {
+	io.Writer
+	io.Seeker
+	Truncate(size int64) error
+}
+
+// sparseFile is a file the downloader created and truncated, so it starts out
+// empty and seeking over holes is safe
+type sparseFile struct {
+	sparseWriter
+}
+
+// zeros is a buffer for writing holes to writers that can't seek
+var zeros = make([]byte, 32*1024)
+
+// Write a hole, seeking over it when possible and writing zeros otherwise
+func writeHole(w io.Writer, size int64) error {
+	if sf, ok := w.(*sparseFile); ok {
+		_, err := sf.Seek(size, io.SeekCurrent)
+		return err
+	}
+	for size > 0 {
+		n, err := w.Write(zeros[:min(size, int64(len(zeros)))])
+		if err != nil {
+			return err
+		}
+		size -= int64(n)
+	}
+	return nil
+}
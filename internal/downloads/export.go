@@ -0,0 +1,132 @@
+package downloads
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/matthewmueller/chunky/internal/archives"
+	"github.com/matthewmueller/chunky/internal/commits"
+	"github.com/matthewmueller/chunky/repos"
+)
+
+// Export a revision from a repo to an archive
+func (d *Downloader) Export(ctx context.Context, from repos.Repo, revision string, to archives.Writer, paths ...string) error {
+	commit, err := commits.Read(ctx, from, revision)
+	if err != nil {
+		return fmt.Errorf("downloads: unable to load commit %q: %w", revision, err)
+	}
+	return d.ExportCommit(ctx, from, commit, to, paths...)
+}
+
+// ExportCommit writes the files in a commit to an archive in the order they
+// were committed. File data is streamed from the packs straight into the
+// archive. Paths limit the export to those files and directories.
+func (d *Downloader) ExportCommit(ctx context.Context, from repos.Repo, commit *commits.Commit, to archives.Writer, paths ...string) error {
+	revision := commit.ID()
+	match := matchPaths(paths)
+	// Hard links can only refer to files already in the archive
+	written := map[string]bool{}
+	found := false
+	for _, cf := range commit.Files() {
+		if !match(cf.Path) {
+			continue
+		}
+		found = true
+		if err := d.exportFile(ctx, from, to, written, cf); err != nil {
+			return fmt.Errorf("downloads: unable to export revision %q: %w", revision, err)
+		}
+		written[cf.Path] = true
+	}
+	if !found && len(paths) > 0 {
+		return fmt.Errorf("downloads: unable to find %q in commit %q", strings.Join(paths, ", "), revision)
+	}
+	return nil
+}
+
+// Write a file in a commit to the archive
+func (d *Downloader) exportFile(ctx context.Context, from repos.Repo, to archives.Writer, written map[string]bool, cf *commits.File) error {
+	fc, err := d.readChunk(ctx, from, cf)
+	if err != nil {
+		return err
+	}
+	entry := &archives.Entry{
+		Path:    fc.Path,
+		Mode:    fc.Mode,
+		Size:    fc.Size,
+		ModTime: time.Unix(fc.ModTime, 0),
+		Major:   fc.Major,
+		Minor:   fc.Minor,
+	}
+	if fc.Owner != nil {
+		entry.Uid, entry.Gid = fc.Owner.Uid, fc.Owner.Gid
+		entry.User, entry.Group = fc.Owner.User, fc.Owner.Group
+	}
+
+	// Link to the file when it's already in the archive, otherwise fall back to
+	// writing a copy
+	if cf.Link != "" && written[cf.Link] {
+		link := *entry
+		link.HardLink = true
+		link.Link = cf.Link
+		link.Size = 0
+		if _, err := to.Create(&link); err == nil {
+			return nil
+		} else if !errors.Is(err, archives.ErrUnsupported) {
+			return err
+		}
+	}
+
+	if fc.Mode&fs.ModeSymlink != 0 {
+		entry.Link = string(fc.Data)
+		_, err := to.Create(entry)
+		return err
+	}
+
+	w, err := to.Create(entry)
+	if err != nil {
+		if errors.Is(err, archives.ErrUnsupported) {
+			d.log.Warn("skipping file unsupported by the archive",
+				slog.String("path", fc.Path),
+				slog.String("mode", fc.Mode.String()),
+			)
+			return nil
+		}
+		return err
+	}
+	// Only regular files have data
+	if !fc.Mode.IsRegular() {
+		return nil
+	}
+	return d.writeFile(ctx, from, w, fc)
+}
+
+// matchPaths returns a function that matches the paths within the given files
+// and directories. Every path matches when there are none.
+func matchPaths(paths []string) func(fpath string) bool {
+	if len(paths) == 0 {
+		return func(string) bool { return true }
+	}
+	cleaned := make([]string, len(paths))
+	for i, p := range paths {
+		p = strings.TrimPrefix(path.Clean("/"+p), "/")
+		if p == "" {
+			// The root matches everything
+			return func(string) bool { return true }
+		}
+		cleaned[i] = p
+	}
+	return func(fpath string) bool {
+		for _, p := range cleaned {
+			if fpath == p || strings.HasPrefix(fpath, p+"/") {
+				return true
+			}
+		}
+		return false
+	}
+}
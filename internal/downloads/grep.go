@@ -0,0 +1,234 @@
+package downloads
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"github.com/matthewmueller/chunky/internal/commits"
+	"github.com/matthewmueller/chunky/repos"
+	"golang.org/x/sync/errgroup"
+)
+
+// Grep configures a search through the files in a revision
+type Grep struct {
+	Pattern *regexp.Regexp
+	// Globs limit the search to the matching paths (default: all)
+	Globs []string
+	// Binary searches binary files too, which are skipped by default
+	Binary bool
+}
+
+// Match is a line in a file that matched the pattern
+type Match struct {
+	Path string
+	// Line is the 1-based line number
+	Line int
+	Text string
+}
+
+// Grep searches the files in a revision for lines that match a pattern
+func (d *Downloader) Grep(ctx context.Context, from repos.Repo, revision string, grep *Grep, found func(*Match) error) error {
+	commit, err := commits.Read(ctx, from, revision)
+	if err != nil {
+		return fmt.Errorf("downloads: unable to load commit %q: %w", revision, err)
+	}
+	return d.GrepCommit(ctx, from, commit, grep, found)
+}
+
+// defaultConcurrency is the number of files searched at once when the
+// downloader's concurrency isn't set, like downloads (default: num cpus * 2)
+var defaultConcurrency = runtime.NumCPU() * 2
+
+// maxLineLength caps the length of the lines that are matched, so files without
+// newlines aren't held in memory. The rest of a longer line is skipped.
+const maxLineLength = 64 * 1024
+
+type grepResult struct {
+	matches []*Match
+	done    chan struct{}
+}
+
+// GrepCommit searches the regular files in a commit, streaming each file's data
+// through the pattern without writing it anywhere. Files are searched
+// concurrently, but the matches are reported in the commit's order.
+func (d *Downloader) GrepCommit(ctx context.Context, from repos.Repo, commit *commits.Commit, grep *Grep, found func(*Match) error) error {
+	match := matchGlobs(grep.Globs)
+	var files []*commits.File
+	for _, cf := range commit.Files() {
+		if cf.Mode.IsRegular() && match(cf.Path) {
+			files = append(files, cf)
+		}
+	}
+
+	// Stop searching once the matches can't be reported
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	eg, ctx := errgroup.WithContext(ctx)
+
+	results := make([]*grepResult, len(files))
+	for i := range results {
+		results[i] = &grepResult{done: make(chan struct{})}
+	}
+	concurrency := d.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultConcurrency
+	}
+	slots := make(chan struct{}, concurrency)
+	eg.Go(func() error {
+		for i, cf := range files {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			result := results[i]
+			eg.Go(func() (err error) {
+				defer close(result.done)
+				defer func() { <-slots }()
+				result.matches, err = d.grepFile(ctx, from, cf, grep)
+				return err
+			})
+		}
+		return nil
+	})
+
+	// Report the matches in order as each file finishes
+	for _, result := range results {
+		select {
+		case <-result.done:
+		case <-ctx.Done():
+			return eg.Wait()
+		}
+		for _, m := range result.matches {
+			if err := found(m); err != nil {
+				cancel()
+				eg.Wait()
+				return err
+			}
+		}
+	}
+	return eg.Wait()
+}
+
+// errBinary stops searching a binary file
+var errBinary = errors.New("downloads: binary file")
+
+// Search a file's data line by line
+func (d *Downloader) grepFile(ctx context.Context, from repos.Repo, cf *commits.File, grep *Grep) ([]*Match, error) {
+	fc, err := d.readChunk(ctx, from, cf)
+	if err != nil {
+		return nil, err
+	}
+	lw := &lineWriter{path: cf.Path, grep: grep}
+	if err := d.writeFile(ctx, from, lw, fc); err != nil {
+		if errors.Is(err, errBinary) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	lw.flush()
+	return lw.matches, nil
+}
+
+// lineWriter matches each line written to it
+type lineWriter struct {
+	path    string
+	grep    *Grep
+	partial []byte
+	line    int
+	matches []*Match
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	if !w.grep.Binary && bytes.IndexByte(p, 0) >= 0 {
+		return 0, errBinary
+	}
+	n := len(p)
+	for {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			break
+		}
+		if len(w.partial) > 0 {
+			w.appendPartial(p[:i])
+			w.match(w.partial)
+			w.partial = w.partial[:0]
+		} else {
+			w.match(p[:i])
+		}
+		p = p[i+1:]
+	}
+	w.appendPartial(p)
+	return n, nil
+}
+
+// Append to the line that's being written, up to the max line length
+func (w *lineWriter) appendPartial(p []byte) {
+	if room := maxLineLength - len(w.partial); len(p) > room {
+		p = p[:max(room, 0)]
+	}
+	w.partial = append(w.partial, p...)
+}
+
+// Match the last line if it doesn't end with a newline
+func (w *lineWriter) flush() {
+	if len(w.partial) > 0 {
+		w.match(w.partial)
+		w.partial = nil
+	}
+}
+
+func (w *lineWriter) match(line []byte) {
+	w.line++
+	if len(line) > maxLineLength {
+		line = line[:maxLineLength]
+	}
+	line = bytes.TrimSuffix(line, []byte("\r"))
+	if w.grep.Pattern.Match(line) {
+		w.matches = append(w.matches, &Match{
+			Path: w.path,
+			Line: w.line,
+			Text: string(line),
+		})
+	}
+}
+
+// matchGlobs returns a function that matches paths against path.Match globs.
+// A glob matches a file or any file within a matching directory. Globs without
+// a slash also match the file's name, so "*.yaml" matches "config/app.yaml".
+// Every path matches when there are no globs.
+func matchGlobs(globs []string) func(fpath string) bool {
+	if len(globs) == 0 {
+		return func(string) bool { return true }
+	}
+	cleaned := make([]string, len(globs))
+	for i, glob := range globs {
+		glob = strings.TrimPrefix(path.Clean("/"+glob), "/")
+		if glob == "" {
+			// The root matches everything
+			return func(string) bool { return true }
+		}
+		cleaned[i] = glob
+	}
+	return func(fpath string) bool {
+		for _, glob := range cleaned {
+			if !strings.Contains(glob, "/") {
+				if ok, _ := path.Match(glob, path.Base(fpath)); ok {
+					return true
+				}
+			}
+			for dir := fpath; dir != "."; dir = path.Dir(dir) {
+				if ok, _ := path.Match(glob, dir); ok {
+					return true
+				}
+			}
+		}
+		return false
+	}
+}
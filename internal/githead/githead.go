@@ -0,0 +1,68 @@
+// Package githead reads the current git commit from a working tree without
+// shelling out to git.
+package githead
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// Head is the checked out commit of a git working tree
+type Head struct {
+	Commit string
+	Branch string // empty when the head is detached
+}
+
+// Read the HEAD of the git repository at the root of fsys. Returns an error
+// wrapping fs.ErrNotExist if there is no git repository.
+func Read(fsys fs.FS) (*Head, error) {
+	data, err := fs.ReadFile(fsys, path.Join(".git", "HEAD"))
+	if err != nil {
+		return nil, fmt.Errorf("githead: unable to read HEAD: %w", err)
+	}
+	head := strings.TrimSpace(string(data))
+	// Detached heads contain the commit directly
+	ref, ok := strings.CutPrefix(head, "ref: ")
+	if !ok {
+		return &Head{Commit: head}, nil
+	}
+	commit, err := resolveRef(fsys, ref)
+	if err != nil {
+		return nil, err
+	}
+	return &Head{
+		Commit: commit,
+		Branch: strings.TrimPrefix(ref, "refs/heads/"),
+	}, nil
+}
+
+// Resolve a ref from either a loose ref file or the packed-refs file
+func resolveRef(fsys fs.FS, ref string) (string, error) {
+	data, err := fs.ReadFile(fsys, path.Join(".git", ref))
+	if err == nil {
+		return strings.TrimSpace(string(data)), nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return "", fmt.Errorf("githead: unable to read ref %q: %w", ref, err)
+	}
+	packed, err := fs.ReadFile(fsys, path.Join(".git", "packed-refs"))
+	if err != nil {
+		return "", fmt.Errorf("githead: unable to resolve ref %q: %w", ref, err)
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(packed))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") || strings.HasPrefix(line, "^") {
+			continue
+		}
+		commit, name, ok := strings.Cut(line, " ")
+		if ok && name == ref {
+			return commit, nil
+		}
+	}
+	return "", fmt.Errorf("githead: unable to resolve ref %q: %w", ref, fs.ErrNotExist)
+}
@@ -0,0 +1,54 @@
+package githead_test
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+
+	"github.com/matryer/is"
+	"github.com/matthewmueller/chunky/internal/githead"
+	"github.com/matthewmueller/virt"
+)
+
+const sha = "3f786850e387550fdab836ed7e6dc881de23001b"
+
+func TestLooseRef(t *testing.T) {
+	is := is.New(t)
+	fsys := virt.Map{
+		".git/HEAD":            "ref: refs/heads/main\n",
+		".git/refs/heads/main": sha + "\n",
+	}
+	head, err := githead.Read(fsys)
+	is.NoErr(err)
+	is.Equal(head.Commit, sha)
+	is.Equal(head.Branch, "main")
+}
+
+func TestPackedRef(t *testing.T) {
+	is := is.New(t)
+	fsys := virt.Map{
+		".git/HEAD":        "ref: refs/heads/feature/a\n",
+		".git/packed-refs": "# pack-refs with: peeled fully-peeled sorted\n" + sha + " refs/heads/feature/a\n",
+	}
+	head, err := githead.Read(fsys)
+	is.NoErr(err)
+	is.Equal(head.Commit, sha)
+	is.Equal(head.Branch, "feature/a")
+}
+
+func TestDetached(t *testing.T) {
+	is := is.New(t)
+	fsys := virt.Map{
+		".git/HEAD": sha + "\n",
+	}
+	head, err := githead.Read(fsys)
+	is.NoErr(err)
+	is.Equal(head.Commit, sha)
+	is.Equal(head.Branch, "")
+}
+
+func TestNoRepo(t *testing.T) {
+	is := is.New(t)
+	_, err := githead.Read(virt.Map{"a.txt": "a"})
+	is.True(errors.Is(err, fs.ErrNotExist))
+}
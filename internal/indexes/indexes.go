@@ -0,0 +1,170 @@
+package indexes
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/matthewmueller/chunky/repos"
+)
+
+// indexDir is where the indexes are stored in the cache. It's a subdirectory
+// because the top-level files in the cache are commits.
+const indexDir = "index"
+
+// Entry is the metadata of a file when it was last hashed
+type Entry struct {
+	Mode    fs.FileMode
+	Size    int64
+	ModTime int64 // nanoseconds
+	Inode   repos.Inode
+	Hash    string
+}
+
+// New creates an empty index for an upload that started at startedAt. The
+// index isn't saved.
+func New(startedAt time.Time) *Index {
+	return &Index{
+		entries:   map[string]*Entry{},
+		next:      map[string]*Entry{},
+		startedAt: startedAt,
+	}
+}
+
+// Load the index of the source whose root directory is root from the cache.
+// Each source has its own index, identified by the device and inode of its
+// root. Sources without inodes can't tell whether a file changed from its
+// metadata, so they get an empty index that's never saved. A missing or
+// unreadable index is treated as empty, since the files will just be hashed
+// again.
+func Load(fsys repos.FS, root fs.FileInfo, startedAt time.Time) (*Index, error) {
+	index := New(startedAt)
+	inode, ok := repos.FileInode(root)
+	if !ok {
+		return index, nil
+	}
+	index.path = path.Join(indexDir, fmt.Sprintf("%d-%d", inode.Dev, inode.Ino))
+	data, err := fs.ReadFile(fsys, index.path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return index, nil
+		}
+		return nil, err
+	}
+	entries, err := unpack(data)
+	if err != nil {
+		return index, nil
+	}
+	index.entries = entries
+	return index, nil
+}
+
+// Index maps file paths to their hashes along with the metadata they had when
+// they were hashed, so unchanged files can be detected without reading them.
+// It's safe to use concurrently.
+type Index struct {
+	path      string            // where the index is saved, if it is
+	entries   map[string]*Entry // previous upload
+	startedAt time.Time
+
+	mu   sync.Mutex
+	next map[string]*Entry // this upload
+}
+
+// Get the hash of a file if its metadata hasn't changed since it was last
+// hashed. Files without an inode are never found, since their size and
+// modification time can stay the same while their contents change.
+func (i *Index) Get(fpath string, info fs.FileInfo) (hash string, ok bool) {
+	entry, ok := i.entries[fpath]
+	if !ok {
+		return "", false
+	}
+	current, ok := newEntry(info, entry.Hash)
+	if !ok || *entry != *current {
+		return "", false
+	}
+	return entry.Hash, true
+}
+
+// Set the hash of a file. Files modified within a second of the upload
+// starting aren't recorded, since filesystems with coarse timestamps can
+// modify them again without changing their modification time.
+func (i *Index) Set(fpath string, info fs.FileInfo, hash string) {
+	if !info.ModTime().Before(i.startedAt.Truncate(time.Second)) {
+		return
+	}
+	entry, ok := newEntry(info, hash)
+	if !ok {
+		return
+	}
+	i.mu.Lock()
+	i.next[fpath] = entry
+	i.mu.Unlock()
+}
+
+// Save the files set during this upload to the cache, replacing the source's
+// previous index
+func (i *Index) Save(fsys repos.FS) error {
+	if i.path == "" {
+		return nil
+	}
+	i.mu.Lock()
+	data, err := pack(i.next)
+	i.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := fsys.MkdirAll(indexDir, 0755); err != nil {
+		return err
+	}
+	return fsys.WriteFile(i.path, data, 0644)
+}
+
+// newEntry returns false if the file doesn't have an inode
+func newEntry(info fs.FileInfo, hash string) (*Entry, bool) {
+	inode, ok := repos.FileInode(info)
+	if !ok {
+		return nil, false
+	}
+	return &Entry{
+		Mode:    info.Mode(),
+		Size:    info.Size(),
+		ModTime: info.ModTime().UnixNano(),
+		Inode:   inode,
+		Hash:    hash,
+	}, true
+}
+
+func pack(entries map[string]*Entry) ([]byte, error) {
+	out := new(bytes.Buffer)
+	writer, err := zstd.NewWriter(out)
+	if err != nil {
+		return nil, err
+	}
+	if err := gob.NewEncoder(writer).Encode(entries); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+func unpack(data []byte) (map[string]*Entry, error) {
+	reader, err := zstd.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	entries := map[string]*Entry{}
+	if err := gob.NewDecoder(reader).Decode(&entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
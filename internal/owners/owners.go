@@ -0,0 +1,98 @@
+package owners
+
+import (
+	"os/user"
+	"strconv"
+	"sync"
+
+	"github.com/matthewmueller/chunky/internal/packs"
+)
+
+// New lookup table for owners. Lookups are cached since the same handful of
+// users and groups tend to own every file in a tree.
+func New() *Lookup {
+	return &Lookup{
+		users:  map[int]string{},
+		groups: map[int]string{},
+		uids:   map[string]int{},
+		gids:   map[string]int{},
+	}
+}
+
+// Lookup translates between numeric ids and user and group names
+type Lookup struct {
+	mu     sync.Mutex
+	users  map[int]string
+	groups map[int]string
+	uids   map[string]int
+	gids   map[string]int
+}
+
+// Owner returns the owner for a uid and gid. The user and group names are
+// included when they can be found.
+func (l *Lookup) Owner(uid, gid int) *packs.Owner {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	username, ok := l.users[uid]
+	if !ok {
+		if u, err := user.LookupId(strconv.Itoa(uid)); err == nil {
+			username = u.Username
+		}
+		l.users[uid] = username
+	}
+	groupname, ok := l.groups[gid]
+	if !ok {
+		if g, err := user.LookupGroupId(strconv.Itoa(gid)); err == nil {
+			groupname = g.Name
+		}
+		l.groups[gid] = groupname
+	}
+	return &packs.Owner{
+		Uid:   uid,
+		Gid:   gid,
+		User:  username,
+		Group: groupname,
+	}
+}
+
+// IDs returns the uid and gid to use for an owner on this system. Names are
+// preferred over the numeric ids when they exist, unless numeric is set.
+func (l *Lookup) IDs(owner *packs.Owner, numeric bool) (uid, gid int) {
+	uid, gid = owner.Uid, owner.Gid
+	if numeric {
+		return uid, gid
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if owner.User != "" {
+		id, ok := l.uids[owner.User]
+		if !ok {
+			id = -1
+			if u, err := user.Lookup(owner.User); err == nil {
+				if n, err := strconv.Atoi(u.Uid); err == nil {
+					id = n
+				}
+			}
+			l.uids[owner.User] = id
+		}
+		if id >= 0 {
+			uid = id
+		}
+	}
+	if owner.Group != "" {
+		id, ok := l.gids[owner.Group]
+		if !ok {
+			id = -1
+			if g, err := user.LookupGroup(owner.Group); err == nil {
+				if n, err := strconv.Atoi(g.Gid); err == nil {
+					id = n
+				}
+			}
+			l.gids[owner.Group] = id
+		}
+		if id >= 0 {
+			gid = id
+		}
+	}
+	return uid, gid
+}
@@ -0,0 +1,135 @@
+package packs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/matthewmueller/chunky/repos"
+	"github.com/matthewmueller/logs"
+)
+
+// NewDiskCache creates a pack cache in a directory that's limited to maxBytes.
+// Packs are cached by their ID, so the directory must only hold the packs of
+// one repository.
+func NewDiskCache(log *slog.Logger, fsys repos.FS, maxBytes int) *DiskCache {
+	return &DiskCache{
+		log:      log,
+		fsys:     fsys,
+		maxBytes: maxBytes,
+	}
+}
+
+// DiskCache keeps downloaded packs on disk so they survive across runs. Each
+// pack is stored with a checksum of its data, so corrupt or partially written
+// packs are downloaded again. When the cache grows beyond its limit, the least
+// recently used packs are removed.
+type DiskCache struct {
+	log      *slog.Logger
+	fsys     repos.FS
+	maxBytes int
+	mu       sync.Mutex
+}
+
+// validPackId returns true if the pack ID names a file directly within the
+// cache directory, so a malicious repo can't read or write outside of it
+func validPackId(packId string) bool {
+	return packId != "" && packId != "." && !strings.Contains(packId, "..") && !strings.ContainsAny(packId, `/\`)
+}
+
+// Get the data of a pack from the cache
+func (c *DiskCache) Get(packId string) (data []byte, ok bool) {
+	log := logs.Scope(c.log)
+	if !validPackId(packId) {
+		log.Warn("ignoring invalid pack id", slog.String("pack", packId))
+		return nil, false
+	}
+	cached, err := fs.ReadFile(c.fsys, packId)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			log.Warn("unable to read cached pack", slog.String("pack", packId), slog.String("error", err.Error()))
+		}
+		return nil, false
+	}
+	if len(cached) < sha256.Size {
+		log.Warn("ignoring corrupt cached pack", slog.String("pack", packId))
+		return nil, false
+	}
+	sum, data := cached[:sha256.Size], cached[sha256.Size:]
+	if actual := sha256.Sum256(data); !bytes.Equal(sum, actual[:]) {
+		log.Warn("ignoring corrupt cached pack", slog.String("pack", packId))
+		return nil, false
+	}
+	// Mark the pack as recently used
+	now := time.Now()
+	if err := repos.Chtimes(c.fsys, packId, now, now); err != nil && !errors.Is(err, errors.ErrUnsupported) {
+		log.Debug("unable to touch cached pack", slog.String("pack", packId), slog.String("error", err.Error()))
+	}
+	return data, true
+}
+
+// Set the data of a pack in the cache, removing the least recently used packs
+// if the cache is full
+func (c *DiskCache) Set(packId string, data []byte) error {
+	if !validPackId(packId) {
+		return fmt.Errorf("packs: invalid pack id %q", packId)
+	}
+	sum := sha256.Sum256(data)
+	cached := make([]byte, 0, len(sum)+len(data))
+	cached = append(cached, sum[:]...)
+	cached = append(cached, data...)
+	if err := c.fsys.WriteFile(packId, cached, 0644); err != nil {
+		return fmt.Errorf("packs: unable to cache pack %s: %w", packId, err)
+	}
+	return c.evict()
+}
+
+// Remove the least recently used packs until the cache fits within its limit
+func (c *DiskCache) evict() error {
+	if c.maxBytes <= 0 {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	des, err := fs.ReadDir(c.fsys, ".")
+	if err != nil {
+		return fmt.Errorf("packs: unable to read pack cache: %w", err)
+	}
+	var infos []fs.FileInfo
+	usedBytes := 0
+	for _, de := range des {
+		if de.IsDir() {
+			continue
+		}
+		info, err := de.Info()
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return fmt.Errorf("packs: unable to stat cached pack %s: %w", de.Name(), err)
+		}
+		infos = append(infos, info)
+		usedBytes += int(info.Size())
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ModTime().Before(infos[j].ModTime())
+	})
+	for _, info := range infos {
+		if usedBytes <= c.maxBytes {
+			break
+		}
+		if err := c.fsys.RemoveAll(info.Name()); err != nil {
+			return fmt.Errorf("packs: unable to remove cached pack %s: %w", info.Name(), err)
+		}
+		logs.Scope(c.log).Debug("removed cached pack", slog.String("pack", info.Name()))
+		usedBytes -= int(info.Size())
+	}
+	return nil
+}
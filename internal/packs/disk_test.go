@@ -0,0 +1,84 @@
+package packs_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"github.com/matthewmueller/chunky/internal/packs"
+	"github.com/matthewmueller/logs"
+	"github.com/matthewmueller/virt"
+)
+
+func TestDiskCache(t *testing.T) {
+	is := is.New(t)
+	dir := t.TempDir()
+	cache := packs.NewDiskCache(logs.Discard(), virt.OS(dir), 0)
+
+	_, ok := cache.Get("a")
+	is.True(!ok)
+
+	is.NoErr(cache.Set("a", []byte("pack a")))
+	data, ok := cache.Get("a")
+	is.True(ok)
+	is.Equal(string(data), "pack a")
+
+	// Corrupt packs are ignored
+	cached, err := os.ReadFile(filepath.Join(dir, "a"))
+	is.NoErr(err)
+	cached[len(cached)-1] = 'b'
+	is.NoErr(os.WriteFile(filepath.Join(dir, "a"), cached, 0644))
+	_, ok = cache.Get("a")
+	is.True(!ok)
+	is.NoErr(os.WriteFile(filepath.Join(dir, "a"), cached[:4], 0644))
+	_, ok = cache.Get("a")
+	is.True(!ok)
+}
+
+func TestDiskCacheEviction(t *testing.T) {
+	is := is.New(t)
+	dir := t.TempDir()
+	data := bytes.Repeat([]byte("x"), 100)
+	// Each pack is stored with a 32 byte checksum
+	cache := packs.NewDiskCache(logs.Discard(), virt.OS(dir), 3*132)
+
+	past := time.Now().Add(-time.Hour)
+	for i, packId := range []string{"a", "b", "c"} {
+		is.NoErr(cache.Set(packId, data))
+		mtime := past.Add(time.Duration(i) * time.Minute)
+		is.NoErr(os.Chtimes(filepath.Join(dir, packId), mtime, mtime))
+	}
+
+	// Using a pack marks it as recently used
+	_, ok := cache.Get("a")
+	is.True(ok)
+
+	// Adding another pack removes the least recently used pack
+	is.NoErr(cache.Set("d", data))
+	_, ok = cache.Get("b")
+	is.True(!ok)
+	for _, packId := range []string{"a", "c", "d"} {
+		_, ok := cache.Get(packId)
+		is.True(ok)
+	}
+}
+
+func TestDiskCacheInvalidPackId(t *testing.T) {
+	is := is.New(t)
+	dir := filepath.Join(t.TempDir(), "cache")
+	is.NoErr(os.MkdirAll(dir, 0755))
+	cache := packs.NewDiskCache(logs.Discard(), virt.OS(dir), 0)
+
+	// Pack IDs can't escape the cache directory
+	is.NoErr(os.WriteFile(filepath.Join(dir, "..", "secret"), []byte("secret"), 0644))
+	for _, packId := range []string{"", ".", "..", "../secret", "a/b", `a\b`} {
+		_, ok := cache.Get(packId)
+		is.True(!ok)
+		is.True(cache.Set(packId, []byte("pack")) != nil)
+	}
+	_, err := os.Stat(filepath.Join(dir, "a"))
+	is.True(os.IsNotExist(err))
+}
@@ -24,16 +24,32 @@ type Chunk struct {
 	Size    int64       `json:"size,omitempty"`
 	Hash    string      `json:"hash,omitempty"`
 	ModTime int64       `json:"modtime,omitempty"`
+	// Major and Minor are the device numbers of device files
+	Major uint32 `json:"major,omitempty"`
+	Minor uint32 `json:"minor,omitempty"`
+	// Owner and Xattrs are only captured when requested
+	Owner  *Owner            `json:"owner,omitempty"`
+	Xattrs map[string][]byte `json:"xattrs,omitempty"`
 	// Data can be set if the file is small enough to fit in one chunk. Otherwise
 	// it will be nil and the file will be chunked with links to the blobs
 	Data []byte `json:"data,omitempty"`
 	Refs []*Ref `json:"refs,omitempty"`
 }
 
-// Ref is a reference to another blob chunk
+// Owner is the ownership of a file. The user and group names are optional.
+type Owner struct {
+	Uid   int    `json:"uid"`
+	Gid   int    `json:"gid"`
+	User  string `json:"user,omitempty"`
+	Group string `json:"group,omitempty"`
+}
+
+// Ref is a reference to another blob chunk or a hole in a sparse file
 type Ref struct {
 	Pack string
 	Hash string
+	// Hole is the length of a run of zeros. Holes aren't stored in a pack.
+	Hole int64
 }
 
 func (c *Chunk) Key() string {
@@ -57,6 +73,16 @@ func (c *Chunk) Link(pack string, chunk *Chunk) {
 	})
 }
 
+// AddHole adds a run of zeros to the file, extending the previous hole if
+// there is one
+func (c *Chunk) AddHole(size int64) {
+	if n := len(c.Refs); n > 0 && c.Refs[n-1].Hole > 0 {
+		c.Refs[n-1].Hole += size
+		return
+	}
+	c.Refs = append(c.Refs, &Ref{Hole: size})
+}
+
 func (c *Chunk) Links() []*Ref {
 	return c.Refs
 }
@@ -67,10 +93,21 @@ func (c *Chunk) Length() int {
 	n += 8 // Size (int64)
 	n += 8 // ModTime (int64)
 	n += 4 // Mode (uint32)
+	n += 8 // Major and Minor (uint32)
+	if c.Owner != nil {
+		n += 16 // Uid and Gid (int64)
+		n += len(c.Owner.User)
+		n += len(c.Owner.Group)
+	}
+	for attr, value := range c.Xattrs {
+		n += len(attr)
+		n += len(value)
+	}
 	n += len(c.Data)
 	for _, blob := range c.Refs {
 		n += len(blob.Pack)
 		n += len(blob.Hash)
+		n += 8 // Hole (int64)
 	}
 	return n
 }
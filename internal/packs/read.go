@@ -37,6 +37,8 @@ func NewCachedReader(log *slog.Logger, cache lru.Cache[*Pack]) *CachedReader {
 
 type CachedReader struct {
 	Limiter rate.Limiter
+	// Disk keeps downloaded packs across runs (optional)
+	Disk *DiskCache
 
 	log   *slog.Logger
 	cache lru.Cache[*Pack]
@@ -49,6 +51,14 @@ func (r *CachedReader) read(ctx context.Context, repo repos.Repo, packId string)
 	log := logs.Scope(r.log)
 	now := time.Now()
 
+	// Check the disk cache before downloading
+	if r.Disk != nil {
+		if data, ok := r.Disk.Get(packId); ok {
+			log.Debug("read pack from disk cache", slog.String("pack", packId))
+			return Unpack(data)
+		}
+	}
+
 	packFile, err := repos.Download(ctx, repo, path.Join("packs", packId))
 	if err != nil {
 		return nil, fmt.Errorf("packs: unable to download pack %s: %w", packId, err)
@@ -63,7 +73,16 @@ func (r *CachedReader) read(ctx context.Context, repo repos.Repo, packId string)
 		slog.Duration("time", time.Since(now)),
 	)
 
-	return Unpack(packFile.Data)
+	pack, err := Unpack(packFile.Data)
+	if err != nil {
+		return nil, err
+	}
+	if r.Disk != nil {
+		if err := r.Disk.Set(packId, packFile.Data); err != nil {
+			log.Warn("unable to cache pack on disk", slog.String("pack", packId), slog.String("error", err.Error()))
+		}
+	}
+	return pack, nil
 }
 
 func (r *CachedReader) Read(ctx context.Context, repo repos.Repo, packId string) (*Pack, error) {
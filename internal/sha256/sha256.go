@@ -6,7 +6,9 @@ import (
 	"hash"
 	"io"
 	"io/fs"
+	"sort"
 	"strconv"
+	"time"
 
 	"github.com/matthewmueller/chunky/internal/packs"
 )
@@ -43,6 +45,45 @@ func HashFile(fsys fs.FS, path string, chunkSize int) (string, error) {
 	return hex.EncodeToString(hash.Sum(nil)), nil
 }
 
+// HashBytes hashes file data that's already been read into memory. This
+// matches HashFile for the same mode and data.
+func HashBytes(mode fs.FileMode, data []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(stamp(mode, int64(len(data)))))
+	hash.Write(data)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// HashEmpty hashes an entry without any content, such as a directory or a
+// special file, so only the mode and modification time are considered. The
+// modification time has second precision, like the time stored in packs.
+func HashEmpty(mode fs.FileMode, modTime time.Time) string {
+	return Hash([]byte(stamp(mode, 0) + ":" + strconv.FormatInt(modTime.Unix(), 10)))
+}
+
+// HashAttrs hashes the ownership and extended attributes of a file. Returns an
+// empty string if there are none.
+func HashAttrs(owner *packs.Owner, xattrs map[string][]byte) string {
+	if owner == nil && len(xattrs) == 0 {
+		return ""
+	}
+	hash := sha256.New()
+	if owner != nil {
+		hash.Write([]byte("owner:" + strconv.Itoa(owner.Uid) + ":" + strconv.Itoa(owner.Gid) + ":" + owner.User + ":" + owner.Group + "\x00"))
+	}
+	attrs := make([]string, 0, len(xattrs))
+	for attr := range xattrs {
+		attrs = append(attrs, attr)
+	}
+	sort.Strings(attrs)
+	for _, attr := range attrs {
+		hash.Write([]byte("xattr:" + attr + "\x00"))
+		hash.Write(xattrs[attr])
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
 // Stamp helps quickly determine if a file has changed.
 func stamp(mode fs.FileMode, size int64) string {
 	return strconv.Itoa(int(size)) + ":" + strconv.Itoa(int(mode)) //+ ":" + strconv.Itoa(int(modTime))
@@ -66,6 +107,18 @@ func (h *Hasher) Write(bc *packs.Chunk) (n int, err error) {
 	return h.h.Write(bc.Data)
 }
 
+// Zeros hashes a run of zeros from a hole in a sparse file
+func (h *Hasher) Zeros(n int64) {
+	io.CopyN(h.h, zeroReader{}, n)
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
 func (h *Hasher) String() string {
 	return hex.EncodeToString(h.h.Sum(nil))
 }
@@ -0,0 +1,206 @@
+// Package signatures signs and verifies repository objects with ed25519 keys.
+// Signatures are stored next to the object they sign with a ".sig" suffix.
+package signatures
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/matthewmueller/chunky/repos"
+	"golang.org/x/crypto/ssh"
+)
+
+// ErrUnsigned is returned when an object doesn't have a trusted signature
+var ErrUnsigned = errors.New("signatures: missing trusted signature")
+
+const ext = ".sig"
+
+// Path returns the signature path for an object path
+func Path(objectPath string) string {
+	return objectPath + ext
+}
+
+// Is returns true if the path is a signature
+func Is(fpath string) bool {
+	return strings.HasSuffix(fpath, ext)
+}
+
+// Signature of an object
+type Signature struct {
+	Key ed25519.PublicKey
+	Sig []byte
+}
+
+// The object path is included in the message so a signature can't be reused
+// for a different object with the same contents (e.g. another tag).
+func message(objectPath string, data []byte) []byte {
+	msg := make([]byte, 0, len(objectPath)+1+len(data))
+	msg = append(msg, objectPath...)
+	msg = append(msg, 0)
+	msg = append(msg, data...)
+	return msg
+}
+
+// Sign an object, returning the signature file to upload alongside it
+func Sign(key ed25519.PrivateKey, object *repos.File) (*repos.File, error) {
+	signature := &Signature{
+		Key: key.Public().(ed25519.PublicKey),
+		Sig: ed25519.Sign(key, message(object.Path, object.Data)),
+	}
+	data, err := Format(signature)
+	if err != nil {
+		return nil, err
+	}
+	return &repos.File{
+		Path:    Path(object.Path),
+		Mode:    0644,
+		Data:    data,
+		ModTime: object.ModTime,
+	}, nil
+}
+
+// Verify that one of the signatures was made by a trusted key. Returns the
+// trusted key that signed the object.
+func Verify(objectPath string, data []byte, sigData []byte, trusted []ed25519.PublicKey) (ed25519.PublicKey, error) {
+	signatures, err := Parse(sigData)
+	if err != nil {
+		return nil, err
+	}
+	msg := message(objectPath, data)
+	// Keep checking after an invalid signature, another trusted key may have
+	// signed the object too
+	var invalid error
+	for _, signature := range signatures {
+		if !isTrusted(signature.Key, trusted) {
+			continue
+		}
+		if ed25519.Verify(signature.Key, msg, signature.Sig) {
+			return signature.Key, nil
+		}
+		if invalid == nil {
+			invalid = fmt.Errorf("signatures: invalid signature for %q by %s", objectPath, Fingerprint(signature.Key))
+		}
+	}
+	if invalid != nil {
+		return nil, invalid
+	}
+	return nil, fmt.Errorf("%w for %q", ErrUnsigned, objectPath)
+}
+
+func isTrusted(key ed25519.PublicKey, trusted []ed25519.PublicKey) bool {
+	for _, t := range trusted {
+		if key.Equal(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// Format signatures as "ssh-ed25519 <base64 key> <base64 signature>" lines
+func Format(signatures ...*Signature) ([]byte, error) {
+	b := new(bytes.Buffer)
+	for _, signature := range signatures {
+		pub, err := ssh.NewPublicKey(signature.Key)
+		if err != nil {
+			return nil, fmt.Errorf("signatures: invalid public key: %w", err)
+		}
+		b.WriteString(pub.Type())
+		b.WriteByte(' ')
+		b.WriteString(base64.StdEncoding.EncodeToString(pub.Marshal()))
+		b.WriteByte(' ')
+		b.WriteString(base64.StdEncoding.EncodeToString(signature.Sig))
+		b.WriteByte('\n')
+	}
+	return b.Bytes(), nil
+}
+
+// Parse a signature file
+func Parse(data []byte) (signatures []*Signature, err error) {
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if line == "" {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 || fields[0] != ssh.KeyAlgoED25519 {
+			return nil, fmt.Errorf("signatures: invalid signature line %q", line)
+		}
+		keyData, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("signatures: invalid key encoding: %w", err)
+		}
+		pub, err := ssh.ParsePublicKey(keyData)
+		if err != nil {
+			return nil, fmt.Errorf("signatures: invalid key: %w", err)
+		}
+		key, err := toEd25519(pub)
+		if err != nil {
+			return nil, err
+		}
+		sig, err := base64.StdEncoding.DecodeString(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("signatures: invalid signature encoding: %w", err)
+		}
+		signatures = append(signatures, &Signature{key, sig})
+	}
+	return signatures, nil
+}
+
+func toEd25519(pub ssh.PublicKey) (ed25519.PublicKey, error) {
+	cryptoKey, ok := pub.(ssh.CryptoPublicKey)
+	if !ok {
+		return nil, fmt.Errorf("signatures: unsupported key type %q", pub.Type())
+	}
+	key, ok := cryptoKey.CryptoPublicKey().(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("signatures: unsupported key type %q", pub.Type())
+	}
+	return key, nil
+}
+
+// ParsePrivateKey parses an unencrypted OpenSSH ed25519 private key, like the
+// ones created by `ssh-keygen -t ed25519`
+func ParsePrivateKey(data []byte) (ed25519.PrivateKey, error) {
+	raw, err := ssh.ParseRawPrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("signatures: unable to parse private key: %w", err)
+	}
+	switch key := raw.(type) {
+	case *ed25519.PrivateKey:
+		return *key, nil
+	case ed25519.PrivateKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("signatures: private key must be ed25519, not %T", raw)
+	}
+}
+
+// ParsePublicKeys parses ed25519 keys in authorized_keys format, like the
+// ".pub" files created by `ssh-keygen -t ed25519`
+func ParsePublicKeys(data []byte) (keys []ed25519.PublicKey, err error) {
+	for len(bytes.TrimSpace(data)) > 0 {
+		pub, _, _, rest, err := ssh.ParseAuthorizedKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("signatures: unable to parse public key: %w", err)
+		}
+		key, err := toEd25519(pub)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+		data = rest
+	}
+	return keys, nil
+}
+
+// Fingerprint returns the SHA256 fingerprint of a key, as shown by ssh-keygen
+func Fingerprint(key ed25519.PublicKey) string {
+	pub, err := ssh.NewPublicKey(key)
+	if err != nil {
+		return "invalid key"
+	}
+	return ssh.FingerprintSHA256(pub)
+}
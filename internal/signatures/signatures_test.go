@@ -0,0 +1,89 @@
+package signatures_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"testing"
+
+	"github.com/matryer/is"
+	"github.com/matthewmueller/chunky/internal/signatures"
+	"github.com/matthewmueller/chunky/repos"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestSignVerify(t *testing.T) {
+	is := is.New(t)
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	is.NoErr(err)
+	otherPub, _, err := ed25519.GenerateKey(rand.Reader)
+	is.NoErr(err)
+
+	object := &repos.File{Path: "tags/prod", Data: []byte("20241105033610\n")}
+	sigFile, err := signatures.Sign(key, object)
+	is.NoErr(err)
+	is.Equal(sigFile.Path, "tags/prod.sig")
+	is.True(signatures.Is(sigFile.Path))
+
+	signer, err := signatures.Verify(object.Path, object.Data, sigFile.Data, []ed25519.PublicKey{otherPub, pub})
+	is.NoErr(err)
+	is.True(signer.Equal(pub))
+
+	// Untrusted key
+	_, err = signatures.Verify(object.Path, object.Data, sigFile.Data, []ed25519.PublicKey{otherPub})
+	is.True(errors.Is(err, signatures.ErrUnsigned))
+
+	// Modified data
+	_, err = signatures.Verify(object.Path, []byte("20241105033612\n"), sigFile.Data, []ed25519.PublicKey{pub})
+	is.True(err != nil)
+
+	// Signature reused for another object
+	_, err = signatures.Verify("tags/staging", object.Data, sigFile.Data, []ed25519.PublicKey{pub})
+	is.True(err != nil)
+}
+
+func TestVerifyMultipleSignatures(t *testing.T) {
+	is := is.New(t)
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	is.NoErr(err)
+	otherPub, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	is.NoErr(err)
+
+	// A stale signature by one trusted key doesn't hide a valid signature by
+	// another trusted key
+	object := &repos.File{Path: "tags/prod", Data: []byte("20241105033610\n")}
+	stale, err := signatures.Sign(otherKey, &repos.File{Path: "tags/prod", Data: []byte("20241105033608\n")})
+	is.NoErr(err)
+	valid, err := signatures.Sign(key, object)
+	is.NoErr(err)
+	sigData := append(stale.Data, valid.Data...)
+	signer, err := signatures.Verify(object.Path, object.Data, sigData, []ed25519.PublicKey{otherPub, pub})
+	is.NoErr(err)
+	is.True(signer.Equal(pub))
+
+	// Only invalid signatures by trusted keys
+	_, err = signatures.Verify(object.Path, object.Data, stale.Data, []ed25519.PublicKey{otherPub, pub})
+	is.True(err != nil)
+	is.True(!errors.Is(err, signatures.ErrUnsigned))
+}
+
+func TestParseKeys(t *testing.T) {
+	is := is.New(t)
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	is.NoErr(err)
+
+	block, err := ssh.MarshalPrivateKey(key, "ci")
+	is.NoErr(err)
+	parsedKey, err := signatures.ParsePrivateKey(pem.EncodeToMemory(block))
+	is.NoErr(err)
+	is.True(parsedKey.Equal(key))
+
+	sshPub, err := ssh.NewPublicKey(pub)
+	is.NoErr(err)
+	authorized := append(ssh.MarshalAuthorizedKey(sshPub), ssh.MarshalAuthorizedKey(sshPub)...)
+	keys, err := signatures.ParsePublicKeys(authorized)
+	is.NoErr(err)
+	is.Equal(len(keys), 2)
+	is.True(keys[0].Equal(pub))
+}
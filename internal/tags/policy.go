@@ -0,0 +1,164 @@
+package tags
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+
+	"github.com/matthewmueller/chunky/repos"
+)
+
+// ErrProtected is returned when a change to a tag isn't allowed by the policy
+var ErrProtected = errors.New("tags: protected")
+
+// PolicyPath is where the tag policy is stored within the repository
+var PolicyPath = path.Join("policies", "tags")
+
+// Policy protects tags from being changed
+type Policy struct {
+	Rules []*Rule `json:"rules,omitempty"`
+}
+
+// Rule protects the tags matching a pattern
+type Rule struct {
+	// Pattern matches tag names using path.Match syntax (e.g. "v*")
+	Pattern string `json:"pattern,omitempty"`
+	// Immutable tags can't be changed once they're created
+	Immutable bool `json:"immutable,omitempty"`
+	// AppendOnly tags can be moved, but they can't be rolled back, deleted or
+	// have their history replaced
+	AppendOnly bool `json:"append_only,omitempty"`
+	// Users that are allowed to change the tag. Empty allows everyone.
+	Users []string `json:"users,omitempty"`
+}
+
+// Op is a change to a tag
+type Op string
+
+const (
+	OpMove     Op = "move"
+	OpRollback Op = "rollback"
+	OpDelete   Op = "delete"
+	// OpReplace replaces the tag's history, such as when copying a tag whose
+	// history differs
+	OpReplace Op = "replace"
+)
+
+func (r *Rule) match(name string) bool {
+	ok, err := path.Match(r.Pattern, name)
+	return err == nil && ok
+}
+
+func (r *Rule) check(tag *Tag, op Op, user string) error {
+	if len(r.Users) > 0 && !slices.Contains(r.Users, user) {
+		return fmt.Errorf("%w: %q can only be changed by %v", ErrProtected, tag.Name, r.Users)
+	}
+	// Creating a new tag is always allowed
+	if len(tag.Entries) == 0 {
+		return nil
+	}
+	if r.Immutable {
+		return fmt.Errorf("%w: %q is immutable", ErrProtected, tag.Name)
+	}
+	if r.AppendOnly && op != OpMove {
+		return fmt.Errorf("%w: %q is append-only and can't be %s", ErrProtected, tag.Name, pastTense(op))
+	}
+	return nil
+}
+
+func pastTense(op Op) string {
+	switch op {
+	case OpRollback:
+		return "rolled back"
+	case OpDelete:
+		return "deleted"
+	case OpReplace:
+		return "replaced"
+	default:
+		return "moved"
+	}
+}
+
+// Check that the user is allowed to change the tag. The tag should be in the
+// state before the change.
+func (p *Policy) Check(tag *Tag, op Op, user string) (err error) {
+	for _, rule := range p.Rules {
+		if !rule.match(tag.Name) {
+			continue
+		}
+		if err2 := rule.check(tag, op, user); err2 != nil {
+			err = errors.Join(err, err2)
+		}
+	}
+	return err
+}
+
+// Protected returns true if any rule matches the tag
+func (p *Policy) Protected(name string) bool {
+	for _, rule := range p.Rules {
+		if rule.match(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Set adds a rule, replacing any existing rule with the same pattern
+func (p *Policy) Set(rule *Rule) error {
+	if _, err := path.Match(rule.Pattern, ""); err != nil {
+		return fmt.Errorf("tags: invalid pattern %q: %w", rule.Pattern, err)
+	}
+	for i, existing := range p.Rules {
+		if existing.Pattern == rule.Pattern {
+			p.Rules[i] = rule
+			return nil
+		}
+	}
+	p.Rules = append(p.Rules, rule)
+	return nil
+}
+
+// Remove the rule with the pattern
+func (p *Policy) Remove(pattern string) bool {
+	for i, rule := range p.Rules {
+		if rule.Pattern == pattern {
+			p.Rules = slices.Delete(p.Rules, i, i+1)
+			return true
+		}
+	}
+	return false
+}
+
+// File returns a repo file for uploading
+func (p *Policy) File() (*repos.File, error) {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("tags: unable to encode policy: %w", err)
+	}
+	return &repos.File{
+		Path: PolicyPath,
+		Mode: 0644,
+		Data: data,
+	}, nil
+}
+
+// ReadPolicy reads the tag policy. An empty policy is returned if the
+// repository doesn't have one.
+func ReadPolicy(ctx context.Context, repo repos.Repo) (*Policy, error) {
+	file, err := repos.Download(ctx, repo, PolicyPath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return &Policy{}, nil
+		}
+		return nil, fmt.Errorf("tags: unable to read policy: %w", err)
+	}
+	policy := new(Policy)
+	if err := json.Unmarshal(file.Data, policy); err != nil {
+		return nil, fmt.Errorf("tags: unable to decode policy: %w", err)
+	}
+	return policy, nil
+}
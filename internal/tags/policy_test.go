@@ -0,0 +1,46 @@
+package tags_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"github.com/matthewmueller/chunky/internal/tags"
+)
+
+func TestPolicyImmutable(t *testing.T) {
+	is := is.New(t)
+	policy := &tags.Policy{}
+	is.NoErr(policy.Set(&tags.Rule{Pattern: "v*", Immutable: true}))
+
+	// Creating the tag is allowed
+	tag := &tags.Tag{Name: "v1.0.0"}
+	is.NoErr(policy.Check(tag, tags.OpMove, "alice"))
+	tag.Move("20241105033610", "alice", time.Now())
+
+	// Changing it afterwards is not
+	is.True(errors.Is(policy.Check(tag, tags.OpMove, "alice"), tags.ErrProtected))
+	is.True(errors.Is(policy.Check(tag, tags.OpDelete, "alice"), tags.ErrProtected))
+
+	// Other tags are unaffected
+	other := &tags.Tag{Name: "prod"}
+	other.Move("20241105033610", "alice", time.Now())
+	is.NoErr(policy.Check(other, tags.OpMove, "alice"))
+}
+
+func TestPolicyAppendOnlyUsers(t *testing.T) {
+	is := is.New(t)
+	policy := &tags.Policy{}
+	is.NoErr(policy.Set(&tags.Rule{Pattern: "prod", AppendOnly: true, Users: []string{"ci"}}))
+
+	tag := &tags.Tag{Name: "prod"}
+	tag.Move("20241105033610", "ci", time.Now())
+	is.NoErr(policy.Check(tag, tags.OpMove, "ci"))
+	is.True(errors.Is(policy.Check(tag, tags.OpMove, "alice"), tags.ErrProtected))
+	is.True(errors.Is(policy.Check(tag, tags.OpRollback, "ci"), tags.ErrProtected))
+
+	// Removing the rule lifts the protection
+	is.True(policy.Remove("prod"))
+	is.NoErr(policy.Check(tag, tags.OpRollback, "alice"))
+}
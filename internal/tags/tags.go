@@ -2,22 +2,144 @@ package tags
 
 import (
 	"context"
+	"fmt"
 	"io/fs"
 	"path"
 	"path/filepath"
+	"regexp"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/matthewmueller/chunky/internal/signatures"
 	"github.com/matthewmueller/chunky/repos"
 )
 
 type Tag struct {
 	Name    string
-	Commits []string
+	Entries []*Entry
+}
+
+// Entry records a commit the tag pointed to, along with who moved the tag
+// there and when. Tags written by older versions only contain the commit.
+type Entry struct {
+	Commit  string
+	User    string
+	MovedAt time.Time
+	// Forced is true if the move overrode the tag's protection policy
+	Forced bool
+	// Rollback is true if the move rolled the tag back to its previous commit
+	Rollback bool
+}
+
+// Commits returns the commits the tag has pointed to, oldest first
+func (t *Tag) Commits() []string {
+	commits := make([]string, len(t.Entries))
+	for i, entry := range t.Entries {
+		commits[i] = entry.Commit
+	}
+	return commits
 }
 
 func (t *Tag) Newest() string {
-	return t.Commits[len(t.Commits)-1]
+	return t.Entries[len(t.Entries)-1].Commit
+}
+
+// Move the tag to a commit, keeping the previous commits in the history
+func (t *Tag) Move(commit, user string, movedAt time.Time) *Entry {
+	entry := &Entry{
+		Commit:  commit,
+		User:    user,
+		MovedAt: movedAt,
+	}
+	t.Entries = append(t.Entries, entry)
+	return entry
+}
+
+// Extends returns true if the tag's history starts with the other tag's
+// history, so changing from the other tag only adds moves
+func (t *Tag) Extends(other *Tag) bool {
+	if len(other.Entries) > len(t.Entries) {
+		return false
+	}
+	for i, entry := range other.Entries {
+		e := t.Entries[i]
+		if e.Commit != entry.Commit || e.User != entry.User || !e.MovedAt.Equal(entry.MovedAt) || e.Forced != entry.Forced || e.Rollback != entry.Rollback {
+			return false
+		}
+	}
+	return true
+}
+
+// At returns the commit the tag pointed to n moves ago, where 0 is the newest
+func (t *Tag) At(n int) (string, bool) {
+	if n < 0 || n >= len(t.Entries) {
+		return "", false
+	}
+	return t.Entries[len(t.Entries)-1-n].Commit, true
+}
+
+// Rollback moves the tag back to the commit it pointed to before its newest
+// move. Rollbacks undo moves, so rolling back again steps further back rather
+// than undoing the rollback. The rollback is appended to the history like any
+// other move, so the move it undoes stays on record.
+func (t *Tag) Rollback(user string, movedAt time.Time) (*Entry, error) {
+	// Replay the history, undoing the moves that were rolled back
+	var live []string
+	for _, entry := range t.Entries {
+		if !entry.Rollback {
+			live = append(live, entry.Commit)
+			continue
+		}
+		if len(live) > 0 {
+			live = live[:len(live)-1]
+		}
+		// Keep histories that were edited by hand consistent
+		if len(live) == 0 || live[len(live)-1] != entry.Commit {
+			live = append(live, entry.Commit)
+		}
+	}
+	if len(live) < 2 {
+		return nil, fmt.Errorf("tags: %q has no previous commit to roll back to", t.Name)
+	}
+	entry := t.Move(live[len(live)-2], user, movedAt)
+	entry.Rollback = true
+	return entry, nil
+}
+
+// Each line is "<commit>\t<moved at>\t<user>\t<flags>", where flags is a
+// comma-separated list
+func (t *Tag) data() []byte {
+	b := new(strings.Builder)
+	for _, entry := range t.Entries {
+		b.WriteString(entry.Commit)
+		flags := entry.flags()
+		if !entry.MovedAt.IsZero() || entry.User != "" || len(flags) > 0 {
+			b.WriteString("\t")
+			if !entry.MovedAt.IsZero() {
+				b.WriteString(entry.MovedAt.UTC().Format(time.RFC3339))
+			}
+			b.WriteString("\t")
+			b.WriteString(entry.User)
+			if len(flags) > 0 {
+				b.WriteString("\t")
+				b.WriteString(strings.Join(flags, ","))
+			}
+		}
+		b.WriteString("\n")
+	}
+	return []byte(b.String())
+}
+
+func (e *Entry) flags() (flags []string) {
+	if e.Forced {
+		flags = append(flags, "forced")
+	}
+	if e.Rollback {
+		flags = append(flags, "rollback")
+	}
+	return flags
 }
 
 // Tree returns a virtual filesystem tree for uploading
@@ -25,7 +147,7 @@ func (t *Tag) Tree() repos.Tree {
 	return repos.Tree{
 		path.Join("tags", t.Name): &repos.File{
 			Mode: 0644,
-			Data: []byte(strings.Join(t.Commits, "\n") + "\n"),
+			Data: t.data(),
 		},
 	}
 }
@@ -35,7 +157,7 @@ func (t *Tag) File() *repos.File {
 	return &repos.File{
 		Path: path.Join("tags", t.Name),
 		Mode: 0644,
-		Data: []byte(strings.Join(t.Commits, "\n") + "\n"),
+		Data: t.data(),
 	}
 }
 
@@ -55,17 +177,85 @@ func New(name, ref string) *repos.File {
 	}
 }
 
+// Parse a tag file
+func Parse(name string, data []byte) (*Tag, error) {
+	tag := &Tag{Name: name}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if line == "" {
+			continue
+		}
+		fields := strings.SplitN(line, "\t", 4)
+		entry := &Entry{Commit: fields[0]}
+		if len(fields) > 1 && fields[1] != "" {
+			movedAt, err := time.Parse(time.RFC3339, fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("tags: invalid time in %q: %w", name, err)
+			}
+			entry.MovedAt = movedAt
+		}
+		if len(fields) > 2 {
+			entry.User = fields[2]
+		}
+		if len(fields) > 3 {
+			for _, flag := range strings.Split(fields[3], ",") {
+				switch flag {
+				case "forced":
+					entry.Forced = true
+				case "rollback":
+					entry.Rollback = true
+				}
+			}
+		}
+		tag.Entries = append(tag.Entries, entry)
+	}
+	if len(tag.Entries) == 0 {
+		return nil, fmt.Errorf("tags: %q is empty", name)
+	}
+	return tag, nil
+}
+
+var revisionPattern = regexp.MustCompile(`^(.+)@\{(\d+)\}$`)
+
+// ValidateName returns an error if a tag can't be stored under its name, since
+// it would replace another tag's signature or be stored outside of the tags
+func ValidateName(name string) error {
+	if signatures.Is(name) {
+		return fmt.Errorf("tag %q cannot end with '.sig'", name)
+	} else if strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		return fmt.Errorf("tag %q cannot be a path", name)
+	}
+	return nil
+}
+
+// ParseRevision splits a revision like "latest@{1}" into the tag name and the
+// number of moves to go back. Revisions without a suffix return 0.
+func ParseRevision(revision string) (name string, n int) {
+	matches := revisionPattern.FindStringSubmatch(revision)
+	if matches == nil {
+		return revision, 0
+	}
+	n, err := strconv.Atoi(matches[2])
+	if err != nil {
+		return revision, 0
+	}
+	return matches[1], n
+}
+
 // Read a tag by name
 func Read(ctx context.Context, repo repos.Repo, name string) (*Tag, error) {
 	tagFile, err := repos.Download(ctx, repo, filepath.Join("tags", name))
 	if err != nil {
 		return nil, err
 	}
-	commits := strings.Split(strings.TrimSpace(string(tagFile.Data)), "\n")
-	return &Tag{
-		Name:    name,
-		Commits: commits,
-	}, nil
+	return Parse(name, tagFile.Data)
+}
+
+// Delete a tag by name
+func Delete(ctx context.Context, repo repos.Repo, name string) error {
+	if err := repos.Remove(ctx, repo, path.Join("tags", name)); err != nil {
+		return fmt.Errorf("tags: unable to delete %q: %w", name, err)
+	}
+	return nil
 }
 
 // ReadAll reads all tags
@@ -73,7 +263,7 @@ func ReadAll(ctx context.Context, repo repos.Repo) (tags []*Tag, err error) {
 	if err := repo.Walk(ctx, "tags", func(fpath string, de fs.DirEntry, err error) error {
 		if err != nil {
 			return err
-		} else if de.IsDir() {
+		} else if de.IsDir() || signatures.Is(fpath) {
 			return nil
 		}
 		tag, err := Read(ctx, repo, filepath.Base(fpath))
@@ -96,7 +286,7 @@ func ReadMap(ctx context.Context, repo repos.Repo) (map[string][]*Tag, error) {
 	if err := repo.Walk(ctx, "tags", func(fpath string, de fs.DirEntry, err error) error {
 		if err != nil {
 			return err
-		} else if de.IsDir() {
+		} else if de.IsDir() || signatures.Is(fpath) {
 			return nil
 		}
 		tag, err := Read(ctx, repo, filepath.Base(fpath))
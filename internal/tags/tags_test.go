@@ -0,0 +1,114 @@
+package tags_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"github.com/matthewmueller/chunky/internal/tags"
+)
+
+func TestParseLegacy(t *testing.T) {
+	is := is.New(t)
+	tag, err := tags.Parse("prod", []byte("20241105033610\n20241105033612\n"))
+	is.NoErr(err)
+	is.Equal(tag.Commits(), []string{"20241105033610", "20241105033612"})
+	is.Equal(tag.Newest(), "20241105033612")
+	is.Equal(tag.Entries[0].User, "")
+	is.True(tag.Entries[0].MovedAt.IsZero())
+}
+
+func TestMoveRoundTrip(t *testing.T) {
+	is := is.New(t)
+	now := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	tag, err := tags.Parse("prod", []byte("20241105033610\n"))
+	is.NoErr(err)
+	tag.Move("20241105033612", "Jane Doe", now)
+
+	tag, err = tags.Parse("prod", tag.File().Data)
+	is.NoErr(err)
+	is.Equal(len(tag.Entries), 2)
+	is.Equal(tag.Entries[1].Commit, "20241105033612")
+	is.Equal(tag.Entries[1].User, "Jane Doe")
+	is.True(tag.Entries[1].MovedAt.Equal(now))
+
+	commit, ok := tag.At(0)
+	is.True(ok)
+	is.Equal(commit, "20241105033612")
+	commit, ok = tag.At(1)
+	is.True(ok)
+	is.Equal(commit, "20241105033610")
+	_, ok = tag.At(2)
+	is.True(!ok)
+}
+
+func TestRollbackRoundTrip(t *testing.T) {
+	is := is.New(t)
+	now := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	tag, err := tags.Parse("prod", []byte("20241105033610\n"))
+	is.NoErr(err)
+	_, err = tag.Rollback("Jane Doe", now)
+	is.True(err != nil)
+	tag.Move("20241105033612", "Jane Doe", now)
+	entry, err := tag.Rollback("John Doe", now)
+	is.NoErr(err)
+	entry.Forced = true
+
+	tag, err = tags.Parse("prod", tag.File().Data)
+	is.NoErr(err)
+	is.Equal(len(tag.Entries), 3)
+	is.Equal(tag.Entries[2].Commit, "20241105033610")
+	is.Equal(tag.Entries[2].User, "John Doe")
+	is.True(tag.Entries[2].Rollback)
+	is.True(tag.Entries[2].Forced)
+	is.True(!tag.Entries[1].Rollback)
+
+	commit, ok := tag.At(1)
+	is.True(ok)
+	is.Equal(commit, "20241105033612")
+}
+
+func TestRepeatedRollback(t *testing.T) {
+	is := is.New(t)
+	now := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	tag, err := tags.Parse("prod", []byte("20241105033610\n"))
+	is.NoErr(err)
+	tag.Move("20241105033612", "Jane Doe", now)
+	tag.Move("20241105033614", "Jane Doe", now)
+
+	// Each rollback steps further back
+	entry, err := tag.Rollback("John Doe", now)
+	is.NoErr(err)
+	is.Equal(entry.Commit, "20241105033612")
+	entry, err = tag.Rollback("John Doe", now)
+	is.NoErr(err)
+	is.Equal(entry.Commit, "20241105033610")
+	_, err = tag.Rollback("John Doe", now)
+	is.True(err != nil)
+
+	// Moving again starts from the commit the tag was rolled back to
+	tag.Move("20241105033616", "Jane Doe", now)
+	entry, err = tag.Rollback("John Doe", now)
+	is.NoErr(err)
+	is.Equal(entry.Commit, "20241105033610")
+
+	// The rollbacks survive a round trip
+	tag, err = tags.Parse("prod", tag.File().Data)
+	is.NoErr(err)
+	is.Equal(tag.Commits(), []string{"20241105033610", "20241105033612", "20241105033614", "20241105033612", "20241105033610", "20241105033616", "20241105033610"})
+	_, err = tag.Rollback("John Doe", now)
+	is.True(err != nil)
+}
+
+func TestParseRevision(t *testing.T) {
+	is := is.New(t)
+	name, n := tags.ParseRevision("latest@{2}")
+	is.Equal(name, "latest")
+	is.Equal(n, 2)
+	name, n = tags.ParseRevision("latest")
+	is.Equal(name, "latest")
+	is.Equal(n, 0)
+	name, n = tags.ParseRevision("v1@{x}")
+	is.Equal(name, "v1@{x}")
+	is.Equal(n, 0)
+}
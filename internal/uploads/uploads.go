@@ -2,11 +2,13 @@ package uploads
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"log/slog"
 	"path"
+	"sync"
 	"time"
 
 	"github.com/matthewmueller/chunky/internal/chunker"
@@ -38,6 +40,9 @@ func New(log *slog.Logger, uploadCh chan<- *repos.File) *Upload {
 	}
 }
 
+// Upload packs files and sends the packs to be uploaded. Add is safe to call
+// concurrently. Packs are compressed by the caller that fills them, so packs
+// are compressed in parallel while other callers keep adding to the next pack.
 type Upload struct {
 	log          *slog.Logger
 	uploadCh     chan<- *repos.File
@@ -48,6 +53,7 @@ type Upload struct {
 	Concurrency  int
 
 	// Current pack
+	mu      sync.Mutex
 	current *packFile
 }
 
@@ -58,6 +64,10 @@ type File struct {
 	Mode    fs.FileMode
 	Size    int64
 	ModTime time.Time
+	Major   uint32
+	Minor   uint32
+	Owner   *packs.Owner
+	Xattrs  map[string][]byte
 }
 
 func newPackFile() *packFile {
@@ -85,38 +95,132 @@ func (p *packFile) File() (*virt.File, error) {
 	}, nil
 }
 
+// Add a file to the pack, returning the ID of the pack that holds the file
+// chunk. Returns ErrModified if the data doesn't match the file's hash.
 func (u *Upload) Add(ctx context.Context, file *File) (packId string, err error) {
+	fileChunk, err := u.Chunk(ctx, file)
+	if err != nil {
+		return "", err
+	}
+	return u.add(ctx, fileChunk)
+}
+
+// Chunk reads a file's data, adding its blobs to the pack, and returns the
+// file chunk without adding it. If the file doesn't have a hash, it's hashed
+// while it's chunked. The blobs are added either way, so callers should check
+// whether the file was already uploaded before chunking it.
+func (u *Upload) Chunk(ctx context.Context, file *File) (*packs.Chunk, error) {
+	return u.chunk(file, func(fileChunk, blobChunk *packs.Chunk) error {
+		blobPackId, err := u.add(ctx, blobChunk)
+		if err != nil {
+			return err
+		}
+		fileChunk.Link(blobPackId, blobChunk)
+		return nil
+	})
+}
+
+// Pending is a file chunk whose blobs are held back until it's known whether
+// the file needs to be added
+type Pending struct {
+	Chunk *packs.Chunk
+	// blobs that haven't been added yet and the refs that point to them
+	blobs []*packs.Chunk
+	refs  []*packs.Ref
+	size  int
+}
+
+// ChunkPending reads a file's data like Chunk, but holds its blobs back until
+// AddPending is called, so nothing is added if the file was already uploaded.
+// It's for files that can't be read again once they've been hashed, like
+// files in a stream. Only up to a pack of blobs are held in memory, the rest
+// are added as they're read.
+func (u *Upload) ChunkPending(ctx context.Context, file *File) (*Pending, error) {
+	pending := new(Pending)
+	fileChunk, err := u.chunk(file, func(fileChunk, blobChunk *packs.Chunk) error {
+		if pending.size+blobChunk.Length() > u.MaxPackSize {
+			blobPackId, err := u.add(ctx, blobChunk)
+			if err != nil {
+				return err
+			}
+			fileChunk.Link(blobPackId, blobChunk)
+			return nil
+		}
+		fileChunk.Link("", blobChunk)
+		pending.blobs = append(pending.blobs, blobChunk)
+		pending.refs = append(pending.refs, fileChunk.Refs[len(fileChunk.Refs)-1])
+		pending.size += blobChunk.Length()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	pending.Chunk = fileChunk
+	return pending, nil
+}
+
+// AddPending adds the blobs held back by ChunkPending and then the file chunk
+// to the pack, returning the ID of the pack that holds the file chunk
+func (u *Upload) AddPending(ctx context.Context, pending *Pending) (packId string, err error) {
+	for i, blobChunk := range pending.blobs {
+		if pending.refs[i].Pack, err = u.add(ctx, blobChunk); err != nil {
+			return "", err
+		}
+	}
+	return u.add(ctx, pending.Chunk)
+}
+
+// Chunk a file, calling addBlob with each blob that's read
+func (u *Upload) chunk(file *File, addBlob func(fileChunk, blobChunk *packs.Chunk) error) (*packs.Chunk, error) {
 	fileChunk := &packs.Chunk{
 		Path:    file.Path,
 		Mode:    file.Mode,
 		Size:    file.Size,
 		Hash:    file.Hash,
 		ModTime: file.ModTime.Unix(),
+		Major:   file.Major,
+		Minor:   file.Minor,
+		Owner:   file.Owner,
+		Xattrs:  file.Xattrs,
+	}
+
+	// Directories and special files don't have any data
+	if file.Mode.IsDir() || repos.IsSpecial(file.Mode) {
+		return fileChunk, nil
 	}
 
 	// If the file data is less than one chunk, just add it directly to the pack
 	if fileChunk.Length()+int(file.Size) < u.MaxChunkSize {
 		data, err := io.ReadAll(file)
 		if err != nil {
-			return "", fmt.Errorf("reading file data: %w", err)
+			return nil, fmt.Errorf("reading file data: %w", err)
 		}
 		fileChunk.Data = data
-		if err := u.maybeFlush(ctx, fileChunk.Length()); err != nil {
-			return "", fmt.Errorf("flushing pack: %w", err)
+		if err := checkHash(file, fileChunk, int64(len(data)), sha256.New(fileChunk)); err != nil {
+			return nil, err
 		}
-		u.current.Add(fileChunk)
-		return u.current.ID, nil
+		return fileChunk, nil
 	}
 
-	// Chunk the file data
+	// Chunk the file data, hashing it along the way
+	hash := sha256.New(fileChunk)
 	chunker := chunker.New(file, uint(u.MinChunkSize), uint(u.MaxChunkSize))
+	var size int64
 	for {
 		chunk, err := chunker.Chunk()
 		if err != nil {
 			if err == io.EOF {
 				break
 			}
-			return "", fmt.Errorf("chunking file: %w", err)
+			return nil, fmt.Errorf("chunking file: %w", err)
+		}
+		size += int64(len(chunk.Data))
+
+		// Runs of zeros are recorded as holes rather than stored
+		if isZero(chunk.Data) {
+			fileChunk.AddHole(int64(len(chunk.Data)))
+			hash.Zeros(int64(len(chunk.Data)))
+			continue
 		}
 
 		// Create a blob chunk
@@ -124,46 +228,107 @@ func (u *Upload) Add(ctx context.Context, file *File) (packId string, err error)
 			Hash: sha256.Hash(chunk.Data),
 			Data: chunk.Data,
 		}
+		hash.Write(blobChunk)
 
-		// If adding the blob chunk exceeds the max pack size, upload the current
-		// pack and start a new pack
-		if err := u.maybeFlush(ctx, blobChunk.Length()); err != nil {
-			return "", fmt.Errorf("flushing pack: %w", err)
+		// Add the blob chunk and link it to the file chunk
+		if err := addBlob(fileChunk, blobChunk); err != nil {
+			return nil, err
 		}
+	}
 
-		// Link the blob chunk to the file chunk
-		fileChunk.Link(u.current.ID, blobChunk)
-
-		// Add the blob chunk to the current pack
-		u.current.Add(blobChunk)
+	// Don't return files that changed while they were read. The blobs that were
+	// already added stay in the pack, but nothing refers to them.
+	if err := checkHash(file, fileChunk, size, hash); err != nil {
+		return nil, err
 	}
 
-	// If adding the file chunk exceeds the max pack size, upload the current pack
-	// and start a new one
-	if err := u.maybeFlush(ctx, fileChunk.Length()); err != nil {
-		return "", fmt.Errorf("flushing pack: %w", err)
+	return fileChunk, nil
+}
+
+// AddChunk adds a file chunk returned by Chunk to the pack, returning the ID of
+// the pack that holds it
+func (u *Upload) AddChunk(ctx context.Context, fileChunk *packs.Chunk) (packId string, err error) {
+	return u.add(ctx, fileChunk)
+}
+
+// Add a chunk to the current pack, returning the pack's ID. If adding the chunk
+// would exceed the max pack size, the current pack is sent and the chunk is
+// added to a new pack.
+func (u *Upload) add(ctx context.Context, chunk *packs.Chunk) (packId string, err error) {
+	u.mu.Lock()
+	var full *packFile
+	if u.current.Length() > 0 && u.current.Length()+chunk.Length() >= u.MaxPackSize {
+		full = u.current
+		u.current = newPackFile()
 	}
-	u.current.Add(fileChunk)
+	u.current.Add(chunk)
+	packId = u.current.ID
+	u.mu.Unlock()
 
-	return u.current.ID, nil
+	if full != nil {
+		if err := u.send(ctx, full); err != nil {
+			return "", fmt.Errorf("flushing pack: %w", err)
+		}
+	}
+	return packId, nil
 }
 
-// Flush the current pack if adding the chunk would exceed the max pack size
-func (u *Upload) maybeFlush(ctx context.Context, chunkLength int) error {
-	if u.current.Length()+chunkLength < u.MaxPackSize {
+// ErrModified is returned when a file's data doesn't match its hash because
+// the file changed after it was hashed
+var ErrModified = errors.New("uploads: file modified during upload")
+
+// Check that the data read matches the file's size and hash, filling in the
+// hash of the file chunk if the file wasn't hashed beforehand. Symlinks are
+// skipped since their data is the link target.
+func checkHash(file *File, fileChunk *packs.Chunk, size int64, hash *sha256.Hasher) error {
+	if !file.Mode.IsRegular() {
+		if fileChunk.Hash == "" {
+			fileChunk.Hash = hash.String()
+		}
+		return nil
+	}
+	if size != file.Size {
+		return fmt.Errorf("%w: %q", ErrModified, file.Path)
+	}
+	if file.Hash == "" {
+		fileChunk.Hash = hash.String()
 		return nil
 	}
-	return u.Flush(ctx)
+	if hash.String() != file.Hash {
+		return fmt.Errorf("%w: %q", ErrModified, file.Path)
+	}
+	return nil
 }
 
+// isZero returns true if the data is all zeros
+func isZero(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Flush sends the current pack. It should be called once all the files have
+// been added.
 func (u *Upload) Flush(ctx context.Context) error {
+	u.mu.Lock()
 	if u.current.Length() == 0 {
+		u.mu.Unlock()
 		return nil
 	}
+	current := u.current
+	u.current = newPackFile()
+	u.mu.Unlock()
+	return u.send(ctx, current)
+}
 
+// Compress and send a pack to be uploaded
+func (u *Upload) send(ctx context.Context, pack *packFile) error {
 	log := logs.Scope(u.log)
 
-	packFile, err := u.current.File()
+	packFile, err := pack.File()
 	if err != nil {
 		return err
 	}
@@ -185,6 +350,5 @@ func (u *Upload) Flush(ctx context.Context) error {
 		slog.Duration("time", time.Since(now)),
 	)
 
-	u.current = newPackFile()
 	return nil
 }
@@ -3,6 +3,7 @@ package uploads_test
 import (
 	"bytes"
 	"context"
+	"errors"
 	"io/fs"
 	"path"
 	"strings"
@@ -15,6 +16,7 @@ import (
 	"github.com/matthewmueller/chunky/internal/uploads"
 	"github.com/matthewmueller/chunky/repos"
 	"github.com/matthewmueller/logs"
+	"github.com/matthewmueller/virt"
 )
 
 const kib = 1024
@@ -27,6 +29,16 @@ func makeData(amount int) []byte {
 	return data
 }
 
+// fileHash hashes the data of a 0644 file the way uploads are hashed
+func fileHash(data []byte) string {
+	fsys := virt.Tree{"file": &virt.File{Data: data, Mode: 0644}}
+	hash, err := sha256.HashFile(fsys, "file", 1024)
+	if err != nil {
+		panic(err)
+	}
+	return hash
+}
+
 func pullPackFile(uploadCh <-chan *repos.File) (*repos.File, bool) {
 	select {
 	case file := <-uploadCh:
@@ -66,7 +78,7 @@ func TestOneFileNoChunks(t *testing.T) {
 	packId, err := upload.Add(ctx, &uploads.File{
 		Reader:  bytes.NewReader(data),
 		Path:    "test.txt",
-		Hash:    sha256.Hash(data),
+		Hash:    fileHash(data),
 		Mode:    0644,
 		Size:    int64(len(data)),
 		ModTime: modTime,
@@ -101,7 +113,7 @@ func TestOneFileNoChunks(t *testing.T) {
 	is.Equal(chunk.Mode, fs.FileMode(0644))
 	is.Equal(chunk.Size, int64(len(data)))
 	is.Equal(chunk.ModTime, modTime.Unix())
-	is.Equal(chunk.Hash, sha256.Hash(data))
+	is.Equal(chunk.Hash, fileHash(data))
 	is.Equal(chunk.Data, data)
 	is.Equal(len(chunk.Refs), 0)
 }
@@ -122,7 +134,7 @@ func TestOneFileOneChunk(t *testing.T) {
 	packId, err := upload.Add(ctx, &uploads.File{
 		Reader:  bytes.NewReader(data),
 		Path:    "test.txt",
-		Hash:    sha256.Hash(data),
+		Hash:    fileHash(data),
 		Mode:    0644,
 		Size:    int64(len(data)),
 		ModTime: modTime,
@@ -157,7 +169,7 @@ func TestOneFileOneChunk(t *testing.T) {
 	is.Equal(chunk.Mode, fs.FileMode(0644))
 	is.Equal(chunk.Size, int64(len(data)))
 	is.Equal(chunk.ModTime, modTime.Unix())
-	is.Equal(chunk.Hash, sha256.Hash(data))
+	is.Equal(chunk.Hash, fileHash(data))
 	is.Equal(chunk.Data, nil)
 	is.Equal(len(chunk.Refs), 1)
 	is.True(chunk.Refs[0].Hash != "")
@@ -180,7 +192,7 @@ func TestOneFileTwoChunks(t *testing.T) {
 	packId, err := upload.Add(ctx, &uploads.File{
 		Reader:  bytes.NewReader(data),
 		Path:    "test.txt",
-		Hash:    sha256.Hash(data),
+		Hash:    fileHash(data),
 		Mode:    0644,
 		Size:    int64(len(data)),
 		ModTime: modTime,
@@ -216,7 +228,7 @@ func TestOneFileTwoChunks(t *testing.T) {
 	is.Equal(fchunk.Mode, fs.FileMode(0644))
 	is.Equal(fchunk.Size, int64(len(data)))
 	is.Equal(fchunk.ModTime, modTime.Unix())
-	is.Equal(fchunk.Hash, sha256.Hash(data))
+	is.Equal(fchunk.Hash, fileHash(data))
 	is.Equal(fchunk.Data, nil)
 	is.Equal(len(fchunk.Refs), 2)
 	is.True(fchunk.Refs[0].Hash != "")
@@ -248,7 +260,7 @@ func TestThreeFilesTwoPacks(t *testing.T) {
 	onePackId, err := upload.Add(ctx, &uploads.File{
 		Reader:  bytes.NewReader(oneData),
 		Path:    "one.txt",
-		Hash:    sha256.Hash(oneData),
+		Hash:    fileHash(oneData),
 		Mode:    0644,
 		Size:    int64(len(oneData)),
 		ModTime: oneModTime,
@@ -266,7 +278,7 @@ func TestThreeFilesTwoPacks(t *testing.T) {
 	twoPackId, err := upload.Add(ctx, &uploads.File{
 		Reader:  bytes.NewReader(twoData),
 		Path:    "two.txt",
-		Hash:    sha256.Hash(twoData),
+		Hash:    fileHash(twoData),
 		Mode:    0644,
 		Size:    int64(len(twoData)),
 		ModTime: twoModTime,
@@ -281,7 +293,7 @@ func TestThreeFilesTwoPacks(t *testing.T) {
 	threePackId, err := upload.Add(ctx, &uploads.File{
 		Reader:  bytes.NewReader(threeData),
 		Path:    "three.txt",
-		Hash:    sha256.Hash(threeData),
+		Hash:    fileHash(threeData),
 		Mode:    0644,
 		Size:    int64(len(threeData)),
 		ModTime: threeModTime,
@@ -311,7 +323,7 @@ func TestThreeFilesTwoPacks(t *testing.T) {
 	is.Equal(chunk.Mode, fs.FileMode(0644))
 	is.Equal(chunk.Size, int64(len(oneData)))
 	is.Equal(chunk.ModTime, oneModTime.Unix())
-	is.Equal(chunk.Hash, sha256.Hash(oneData))
+	is.Equal(chunk.Hash, fileHash(oneData))
 	is.Equal(chunk.Data, nil)
 	is.Equal(len(chunk.Refs), 1)
 	is.Equal(chunk.Refs[0].Pack, onePackId)
@@ -329,7 +341,7 @@ func TestThreeFilesTwoPacks(t *testing.T) {
 	is.Equal(chunk.Mode, fs.FileMode(0644))
 	is.Equal(chunk.Size, int64(len(twoData)))
 	is.Equal(chunk.ModTime, twoModTime.Unix())
-	is.Equal(chunk.Hash, sha256.Hash(twoData))
+	is.Equal(chunk.Hash, fileHash(twoData))
 	is.Equal(chunk.Data, nil)
 	is.Equal(len(chunk.Refs), 1)
 	// Fourth chunk
@@ -368,7 +380,7 @@ func TestThreeFilesTwoPacks(t *testing.T) {
 	is.Equal(fchunk.Mode, fs.FileMode(0644))
 	is.Equal(fchunk.Size, int64(len(threeData)))
 	is.Equal(fchunk.ModTime, threeModTime.Unix())
-	is.Equal(fchunk.Hash, sha256.Hash(threeData))
+	is.Equal(fchunk.Hash, fileHash(threeData))
 	is.Equal(fchunk.Data, nil)
 	// Second blob chunk
 	bchunk, ok := secondPack.Chunk(fchunk.Refs[0].Hash)
@@ -400,7 +412,7 @@ func TestBigFileThreePacks(t *testing.T) {
 	packId, err := upload.Add(ctx, &uploads.File{
 		Reader:  bytes.NewReader(oneData),
 		Path:    "bigfile.txt",
-		Hash:    sha256.Hash(oneData),
+		Hash:    fileHash(oneData),
 		Mode:    0644,
 		Size:    int64(len(oneData)),
 		ModTime: oneModTime,
@@ -464,3 +476,90 @@ func TestBigFileThreePacks(t *testing.T) {
 	// Ensure the returned pack id points to pack with the file chunk
 	is.Equal(packId, strings.TrimPrefix(fourthPackFile.Path, "packs/"))
 }
+
+func TestZeroChunksAreHoles(t *testing.T) {
+	ctx := context.Background()
+	is := is.New(t)
+	uploadCh := make(chan *repos.File, 1)
+
+	upload := uploads.New(logs.Discard(), uploadCh)
+	upload.MaxPackSize = 16 * kib
+	upload.MinChunkSize = 512
+	upload.MaxChunkSize = 1 * kib
+
+	data := append(make([]byte, 4*kib), makeData(1*kib)...)
+	packId, err := upload.Add(ctx, &uploads.File{
+		Reader:  bytes.NewReader(data),
+		Path:    "sparse.img",
+		Hash:    fileHash(data),
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	})
+	is.NoErr(err)
+	is.NoErr(upload.Flush(ctx))
+
+	file, ok := pullPackFile(uploadCh)
+	is.True(ok)
+	is.Equal(file.Path, path.Join("packs", packId))
+	pack, err := packs.Unpack(file.Data)
+	is.NoErr(err)
+
+	// The zeros are a single hole followed by the data blobs
+	fchunk, ok := pack.Chunk("sparse.img")
+	is.True(ok)
+	is.True(len(fchunk.Refs) > 1)
+	is.Equal(fchunk.Refs[0].Hole, int64(4*kib))
+	is.Equal(fchunk.Refs[0].Hash, "")
+	for _, ref := range fchunk.Refs[1:] {
+		is.Equal(ref.Hole, int64(0))
+		_, ok := pack.Chunk(ref.Hash)
+		is.True(ok)
+	}
+}
+
+func TestModifiedFile(t *testing.T) {
+	ctx := context.Background()
+	is := is.New(t)
+	uploadCh := make(chan *repos.File, 1)
+
+	upload := uploads.New(logs.Discard(), uploadCh)
+	upload.MaxPackSize = 8 * kib
+	upload.MinChunkSize = 512
+	upload.MaxChunkSize = 1 * kib
+
+	// Small files
+	data := makeData(512)
+	_, err := upload.Add(ctx, &uploads.File{
+		Reader:  bytes.NewReader(data),
+		Path:    "small.txt",
+		Hash:    fileHash(makeData(256)),
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	})
+	is.True(errors.Is(err, uploads.ErrModified))
+
+	// Chunked files
+	data = makeData(4 * kib)
+	_, err = upload.Add(ctx, &uploads.File{
+		Reader:  bytes.NewReader(data),
+		Path:    "large.txt",
+		Hash:    fileHash(makeData(3 * kib)),
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	})
+	is.True(errors.Is(err, uploads.ErrModified))
+
+	// Neither file was added to the pack
+	is.NoErr(upload.Flush(ctx))
+	file, ok := pullPackFile(uploadCh)
+	is.True(ok)
+	pack, err := packs.Unpack(file.Data)
+	is.NoErr(err)
+	_, ok = pack.Chunk("small.txt")
+	is.True(!ok)
+	_, ok = pack.Chunk("large.txt")
+	is.True(!ok)
+}
@@ -0,0 +1,242 @@
+package webui
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// maxDiffSize is the largest file that's diffed line by line
+	maxDiffSize = 1 << 20
+	// maxEdits is the most line edits between two files before giving up on
+	// the diff. Memory grows with the square of the edits.
+	maxEdits = 2000
+	// contextLines is the number of unchanged lines shown around a change
+	contextLines = 3
+)
+
+type lineOp byte
+
+const (
+	opEqual lineOp = iota
+	opDelete
+	opInsert
+)
+
+// line in a diff. Old and New are the 1-based line numbers in each file, or 0
+// if the line isn't in that file.
+type line struct {
+	Op   lineOp
+	Old  int
+	New  int
+	Text string
+}
+
+func (l *line) Class() string {
+	switch l.Op {
+	case opDelete:
+		return "del"
+	case opInsert:
+		return "ins"
+	default:
+		return ""
+	}
+}
+
+func (l *line) Prefix() string {
+	switch l.Op {
+	case opDelete:
+		return "-"
+	case opInsert:
+		return "+"
+	default:
+		return " "
+	}
+}
+
+// hunk is a group of changed lines with the unchanged lines around them
+type hunk struct {
+	Header string
+	Lines  []*line
+}
+
+// isText returns true if the data looks like text
+func isText(data []byte) bool {
+	return utf8.Valid(data) && bytes.IndexByte(data, 0) < 0
+}
+
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// diffText returns the hunks that turn a into b. It returns false if there are
+// too many edits to show.
+func diffText(a, b string) ([]*hunk, bool) {
+	lines, ok := diffLines(splitLines(a), splitLines(b))
+	if !ok {
+		return nil, false
+	}
+	return hunks(lines), true
+}
+
+// diffLines finds the shortest edit script between a and b using Myers'
+// algorithm
+func diffLines(a, b []string) ([]*line, bool) {
+	// Trim the common prefix and suffix, they're always equal
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	ma, mb := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	edits, ok := myers(ma, mb)
+	if !ok {
+		return nil, false
+	}
+	lines := make([]*line, 0, len(a)+len(b))
+	for i := 0; i < prefix; i++ {
+		lines = append(lines, &line{Op: opEqual, Old: i + 1, New: i + 1, Text: a[i]})
+	}
+	for _, l := range edits {
+		if l.Old > 0 {
+			l.Old += prefix
+		}
+		if l.New > 0 {
+			l.New += prefix
+		}
+		lines = append(lines, l)
+	}
+	for i := 0; i < suffix; i++ {
+		ai, bi := len(a)-suffix+i, len(b)-suffix+i
+		lines = append(lines, &line{Op: opEqual, Old: ai + 1, New: bi + 1, Text: a[ai]})
+	}
+	return lines, true
+}
+
+func myers(a, b []string) ([]*line, bool) {
+	n, m := len(a), len(b)
+	total := n + m
+	if total == 0 {
+		return nil, true
+	}
+	offset := total + 1
+	v := make([]int, 2*total+3)
+	// trace keeps the furthest reaching x for each diagonal before each round
+	var trace [][]int
+	found := false
+	for d := 0; d <= total && !found; d++ {
+		if d > maxEdits {
+			return nil, false
+		}
+		trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				found = true
+				break
+			}
+		}
+	}
+
+	// Walk back through the trace to recover the edits
+	var lines []*line
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		prev := trace[d]
+		at := func(k int) int { return prev[k+d] }
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := 0
+		if d > 0 {
+			prevX = at(prevK)
+		}
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			lines = append(lines, &line{Op: opEqual, Old: x, New: y, Text: a[x-1]})
+			x--
+			y--
+		}
+		if d > 0 {
+			if x == prevX {
+				lines = append(lines, &line{Op: opInsert, New: y, Text: b[y-1]})
+			} else {
+				lines = append(lines, &line{Op: opDelete, Old: x, Text: a[x-1]})
+			}
+		}
+		x, y = prevX, prevY
+	}
+	for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+		lines[i], lines[j] = lines[j], lines[i]
+	}
+	return lines, true
+}
+
+// hunks groups the changed lines with a few lines of context around them
+func hunks(lines []*line) (hunks []*hunk) {
+	start := -1
+	end := -1
+	flush := func() {
+		if start < 0 {
+			return
+		}
+		h := &hunk{Lines: lines[start:end]}
+		oldStart, newStart, oldCount, newCount := 0, 0, 0, 0
+		for _, l := range h.Lines {
+			if l.Old > 0 {
+				if oldStart == 0 {
+					oldStart = l.Old
+				}
+				oldCount++
+			}
+			if l.New > 0 {
+				if newStart == 0 {
+					newStart = l.New
+				}
+				newCount++
+			}
+		}
+		h.Header = fmt.Sprintf("@@ -%d,%d +%d,%d @@", oldStart, oldCount, newStart, newCount)
+		hunks = append(hunks, h)
+		start, end = -1, -1
+	}
+	for i, l := range lines {
+		if l.Op == opEqual {
+			continue
+		}
+		from := max(i-contextLines, 0)
+		to := min(i+contextLines+1, len(lines))
+		if start >= 0 && from > end {
+			flush()
+		}
+		if start < 0 {
+			start = from
+		}
+		end = to
+	}
+	flush()
+	return hunks
+}
@@ -0,0 +1,88 @@
+package webui
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+// Rebuild both sides from the diff
+func apply(lines []*line) (a, b []string) {
+	for _, l := range lines {
+		if l.Op != opInsert {
+			a = append(a, l.Text)
+		}
+		if l.Op != opDelete {
+			b = append(b, l.Text)
+		}
+	}
+	return a, b
+}
+
+func TestDiffLines(t *testing.T) {
+	is := is.New(t)
+	tests := []struct {
+		a, b  string
+		edits int
+	}{
+		{"", "", 0},
+		{"a", "", 1},
+		{"", "a", 1},
+		{"a b c", "a b c", 0},
+		{"a b c", "a x c", 2},
+		{"a b c a b b a", "c b a b a c", 5},
+		{"x a b c", "a b c y", 2},
+	}
+	for _, test := range tests {
+		a, b := strings.Fields(test.a), strings.Fields(test.b)
+		lines, ok := diffLines(a, b)
+		is.True(ok)
+		edits := 0
+		for _, l := range lines {
+			if l.Op != opEqual {
+				edits++
+			}
+		}
+		is.Equal(edits, test.edits)
+		gotA, gotB := apply(lines)
+		is.Equal(strings.Join(gotA, " "), test.a)
+		is.Equal(strings.Join(gotB, " "), test.b)
+	}
+}
+
+func TestDiffLinesRandom(t *testing.T) {
+	is := is.New(t)
+	rng := rand.New(rand.NewSource(1))
+	words := func() (lines []string) {
+		for i := rng.Intn(40); i > 0; i-- {
+			lines = append(lines, string(rune('a'+rng.Intn(4))))
+		}
+		return lines
+	}
+	for i := 0; i < 200; i++ {
+		a, b := words(), words()
+		lines, ok := diffLines(a, b)
+		is.True(ok)
+		gotA, gotB := apply(lines)
+		is.Equal(strings.Join(gotA, ""), strings.Join(a, ""))
+		is.Equal(strings.Join(gotB, ""), strings.Join(b, ""))
+	}
+}
+
+func TestHunks(t *testing.T) {
+	is := is.New(t)
+	var a, b []string
+	for i := 0; i < 20; i++ {
+		a = append(a, string(rune('a'+i)))
+	}
+	b = append(b, a...)
+	b[1], b[18] = "x", "y"
+	lines, ok := diffLines(a, b)
+	is.True(ok)
+	hunks := hunks(lines)
+	is.Equal(len(hunks), 2)
+	is.Equal(hunks[0].Header, "@@ -1,5 +1,5 @@")
+	is.Equal(hunks[1].Header, "@@ -16,5 +16,5 @@")
+}
@@ -0,0 +1,662 @@
+// Package webui serves a read-only web interface for browsing a repository.
+// Commits and tags are read straight from the repository and file data is read
+// from the packs through the pack reader as pages need it.
+package webui
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/matthewmueller/chunky/internal/commitfs"
+	"github.com/matthewmueller/chunky/internal/commits"
+	"github.com/matthewmueller/chunky/internal/humanize"
+	"github.com/matthewmueller/chunky/internal/packs"
+	"github.com/matthewmueller/chunky/internal/tags"
+	"github.com/matthewmueller/chunky/repos"
+)
+
+// maxInlineDiffs is the most file diffs shown on a revision's diff page. The
+// rest are linked to.
+const maxInlineDiffs = 50
+
+//go:embed views/*.gohtml
+var views embed.FS
+
+var funcs = template.FuncMap{
+	"commitURL": commitURL,
+	"treeURL":   treeURL,
+	"rawURL":    rawURL,
+	"diffURL":   diffURL,
+	"tagURL":    tagURL,
+	"bytes":     humanize.Bytes,
+	"relTime":   humanize.Time,
+	"date": func(t time.Time) string {
+		return t.Format(time.RFC1123)
+	},
+}
+
+// New creates a handler for browsing the repository. Packs are read through
+// the pack reader, so it should cache them.
+func New(log *slog.Logger, repo repos.Repo, pr packs.Reader) *Handler {
+	h := &Handler{
+		log:   log,
+		repo:  repo,
+		pr:    pr,
+		mux:   http.NewServeMux(),
+		pages: map[string]*template.Template{},
+	}
+	for _, page := range []string{"commits", "tags", "tag", "commit", "tree", "file", "diff"} {
+		h.pages[page] = template.Must(template.New(page).Funcs(funcs).ParseFS(views, "views/layout.gohtml", "views/"+page+".gohtml"))
+	}
+	h.mux.HandleFunc("GET /{$}", h.commits)
+	h.mux.HandleFunc("GET /tags", h.tags)
+	h.mux.HandleFunc("GET /tags/{tag}", h.tag)
+	h.mux.HandleFunc("GET /commits/{rev}", h.commit)
+	h.mux.HandleFunc("GET /tree/{rev}/{path...}", h.tree)
+	h.mux.HandleFunc("GET /raw/{rev}/{path...}", h.raw)
+	h.mux.HandleFunc("GET /diff/{rev}/{path...}", h.diff)
+	return h
+}
+
+// Handler serves the web interface
+type Handler struct {
+	log   *slog.Logger
+	repo  repos.Repo
+	pr    packs.Reader
+	mux   *http.ServeMux
+	pages map[string]*template.Template
+}
+
+var _ http.Handler = (*Handler)(nil)
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+// List every commit, newest first
+func (h *Handler) commits(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	tagMap, err := tags.ReadMap(ctx, h.repo)
+	if err != nil {
+		h.error(w, r, err)
+		return
+	}
+	all, err := commits.ReadAll(ctx, h.repo)
+	if err != nil {
+		h.error(w, r, err)
+		return
+	}
+	views := make([]*commitView, len(all))
+	for i, commit := range all {
+		views[i] = newCommitView(commit, tagMap)
+	}
+	h.render(w, r, "commits", "Commits", views)
+}
+
+type tagView struct {
+	Name    string
+	Moves   int
+	Newest  *commitView
+	Commits []string
+}
+
+// List the tags with the commit they point to
+func (h *Handler) tags(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	all, err := tags.ReadAll(ctx, h.repo)
+	if err != nil {
+		h.error(w, r, err)
+		return
+	}
+	tagMap, err := tags.ReadMap(ctx, h.repo)
+	if err != nil {
+		h.error(w, r, err)
+		return
+	}
+	views := make([]*tagView, len(all))
+	for i, tag := range all {
+		view := &tagView{Name: tag.Name, Moves: len(tag.Entries), Commits: tag.Commits()}
+		if len(tag.Entries) > 0 {
+			newest, err := commits.Read(ctx, h.repo, tag.Newest())
+			if err != nil {
+				h.error(w, r, err)
+				return
+			}
+			view.Newest = newCommitView(newest, tagMap)
+		}
+		views[i] = view
+	}
+	h.render(w, r, "tags", "Tags", views)
+}
+
+type tagEntryView struct {
+	Revision string
+	Commit   *commitView
+	User     string
+	MovedAt  time.Time
+	Forced   bool
+	Rollback bool
+}
+
+// Show every commit a tag has pointed to, newest first
+func (h *Handler) tag(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	name := r.PathValue("tag")
+	tag, err := tags.Read(ctx, h.repo, name)
+	if err != nil {
+		h.error(w, r, err)
+		return
+	}
+	tagMap, err := tags.ReadMap(ctx, h.repo)
+	if err != nil {
+		h.error(w, r, err)
+		return
+	}
+	var entries []*tagEntryView
+	for i := len(tag.Entries) - 1; i >= 0; i-- {
+		entry := tag.Entries[i]
+		commit, err := commits.Read(ctx, h.repo, entry.Commit)
+		if err != nil {
+			h.error(w, r, err)
+			return
+		}
+		view := &tagEntryView{
+			Revision: fmt.Sprintf("%s@{%d}", name, len(tag.Entries)-1-i),
+			Commit:   newCommitView(commit, tagMap),
+			User:     entry.User,
+			MovedAt:  entry.MovedAt,
+			Forced:   entry.Forced,
+			Rollback: entry.Rollback,
+		}
+		// Fallback to the commit for tags that didn't record who moved them
+		if view.User == "" {
+			view.User = commit.User()
+		}
+		if view.MovedAt.IsZero() {
+			view.MovedAt = commit.CreatedAt()
+		}
+		entries = append(entries, view)
+	}
+	h.render(w, r, "tag", "Tag "+name, struct {
+		Name    string
+		Entries []*tagEntryView
+	}{name, entries})
+}
+
+// Show a commit's details
+func (h *Handler) commit(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	commit, err := commits.Read(ctx, h.repo, r.PathValue("rev"))
+	if err != nil {
+		h.error(w, r, err)
+		return
+	}
+	tagMap, err := tags.ReadMap(ctx, h.repo)
+	if err != nil {
+		h.error(w, r, err)
+		return
+	}
+	view := newCommitView(commit, tagMap)
+	h.render(w, r, "commit", "Commit "+view.ID, view)
+}
+
+type crumb struct {
+	Name string
+	Path string
+}
+
+// Split a path into links for each of its directories
+func breadcrumbs(fpath string) (crumbs []*crumb) {
+	if fpath == "." {
+		return nil
+	}
+	dir := ""
+	for _, name := range strings.Split(fpath, "/") {
+		dir = path.Join(dir, name)
+		crumbs = append(crumbs, &crumb{name, dir})
+	}
+	return crumbs
+}
+
+type entryView struct {
+	Name string
+	Path string
+	Mode fs.FileMode
+	Size uint64
+	Link string
+}
+
+type treeView struct {
+	Commit  *commitView
+	Path    string
+	Crumbs  []*crumb
+	Entries []*entryView
+}
+
+type fileView struct {
+	Commit  *commitView
+	Path    string
+	Crumbs  []*crumb
+	Mode    fs.FileMode
+	Size    uint64
+	ModTime time.Time
+	Link    string
+	// Text of the file, if it's small enough to show
+	Text    string
+	Preview bool
+}
+
+// Browse the files in a revision. Directories list their entries and files
+// show their details and contents.
+func (h *Handler) tree(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	commit, err := commits.Read(ctx, h.repo, r.PathValue("rev"))
+	if err != nil {
+		h.error(w, r, err)
+		return
+	}
+	fpath := cleanPath(r.PathValue("path"))
+	fsys := commitfs.New(ctx, h.repo, commit, h.pr)
+	stat, err := fsys.Lstat(fpath)
+	if err != nil {
+		h.error(w, r, err)
+		return
+	}
+	tagMap, err := tags.ReadMap(ctx, h.repo)
+	if err != nil {
+		h.error(w, r, err)
+		return
+	}
+	view := newCommitView(commit, tagMap)
+	title := view.ID + ":/" + strings.TrimPrefix(fpath, ".")
+
+	if stat.IsDir() {
+		des, err := fsys.ReadDir(fpath)
+		if err != nil {
+			h.error(w, r, err)
+			return
+		}
+		tree := &treeView{Commit: view, Path: fpath, Crumbs: breadcrumbs(fpath)}
+		for _, de := range des {
+			info, err := de.Info()
+			if err != nil {
+				h.error(w, r, err)
+				return
+			}
+			entry := &entryView{
+				Name: de.Name(),
+				Path: path.Join(fpath, de.Name()),
+				Mode: info.Mode(),
+				Size: uint64(info.Size()),
+			}
+			if info.Mode()&fs.ModeSymlink != 0 {
+				if entry.Link, err = fsys.Readlink(entry.Path); err != nil {
+					h.error(w, r, err)
+					return
+				}
+			}
+			tree.Entries = append(tree.Entries, entry)
+		}
+		h.render(w, r, "tree", title, tree)
+		return
+	}
+
+	file := &fileView{
+		Commit:  view,
+		Path:    fpath,
+		Crumbs:  breadcrumbs(fpath),
+		Mode:    stat.Mode(),
+		Size:    uint64(stat.Size()),
+		ModTime: stat.ModTime(),
+	}
+	if stat.Mode()&fs.ModeSymlink != 0 {
+		if file.Link, err = fsys.Readlink(fpath); err != nil {
+			h.error(w, r, err)
+			return
+		}
+	} else if stat.Mode().IsRegular() && stat.Size() <= maxDiffSize {
+		data, err := fs.ReadFile(fsys, fpath)
+		if err != nil {
+			h.error(w, r, err)
+			return
+		}
+		if isText(data) {
+			file.Text, file.Preview = string(data), true
+		}
+	}
+	h.render(w, r, "file", title, file)
+}
+
+// Download a file's contents. Symlinks are followed.
+func (h *Handler) raw(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	commit, err := commits.Read(ctx, h.repo, r.PathValue("rev"))
+	if err != nil {
+		h.error(w, r, err)
+		return
+	}
+	fpath := cleanPath(r.PathValue("path"))
+	fsys := commitfs.New(ctx, h.repo, commit, h.pr)
+	file, err := fsys.Open(fpath)
+	if err != nil {
+		h.error(w, r, err)
+		return
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		h.error(w, r, err)
+		return
+	}
+	if !stat.Mode().IsRegular() {
+		http.Error(w, "not a regular file", http.StatusBadRequest)
+		return
+	}
+	// Don't let the repository's files run scripts on the UI's origin
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, stat.Name(), stat.ModTime(), file.(io.ReadSeeker))
+}
+
+type changeView struct {
+	Status string
+	Path   string
+	// Old and New are nil if the file was added or removed
+	Old *commits.File
+	New *commits.File
+	// Hunks are empty when the diff isn't shown, with the reason in Skipped
+	Hunks   []*hunk
+	Skipped string
+}
+
+// IsDir returns true if the change is to a directory, which has no contents
+// to diff
+func (c *changeView) IsDir() bool {
+	return (c.New != nil && c.New.IsDir()) || (c.Old != nil && c.Old.IsDir())
+}
+
+type diffView struct {
+	Commit  *commitView
+	Base    *commitView
+	Path    string
+	Changes []*changeView
+}
+
+// Show the changes between a revision and its parent or the revision in the
+// "base" query parameter. Without a path, every changed file is shown.
+func (h *Handler) diff(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	commit, err := commits.Read(ctx, h.repo, r.PathValue("rev"))
+	if err != nil {
+		h.error(w, r, err)
+		return
+	}
+	tagMap, err := tags.ReadMap(ctx, h.repo)
+	if err != nil {
+		h.error(w, r, err)
+		return
+	}
+	view := &diffView{Commit: newCommitView(commit, tagMap)}
+
+	// Diff against the parent by default
+	var base *commits.Commit
+	baseRev := r.URL.Query().Get("base")
+	if baseRev == "" {
+		baseRev = commit.Parent()
+	}
+	if baseRev != "" {
+		if base, err = commits.Read(ctx, h.repo, baseRev); err != nil {
+			h.error(w, r, err)
+			return
+		}
+		view.Base = newCommitView(base, tagMap)
+	}
+
+	fpath := cleanPath(r.PathValue("path"))
+	changes := diffCommits(base, commit)
+	if fpath != "." {
+		view.Path = fpath
+		changes = filterChanges(changes, fpath)
+	}
+
+	to := commitfs.New(ctx, h.repo, commit, h.pr)
+	var from *commitfs.FS
+	if base != nil {
+		from = commitfs.New(ctx, h.repo, base, h.pr)
+	}
+	for i, change := range changes {
+		if i >= maxInlineDiffs {
+			change.Skipped = "too many changed files to show"
+			continue
+		}
+		if err := diffChange(change, from, to); err != nil {
+			h.error(w, r, err)
+			return
+		}
+	}
+	view.Changes = changes
+	title := "Diff " + view.Commit.ID
+	if fpath != "." {
+		title += ":/" + fpath
+	}
+	h.render(w, r, "diff", title, view)
+}
+
+// diffCommits lists the files that were added, removed or modified between two
+// commits, sorted by path. Base may be nil.
+func diffCommits(base, commit *commits.Commit) (changes []*changeView) {
+	for _, file := range commit.Files() {
+		var old *commits.File
+		if base != nil {
+			old, _ = base.File(file.Path)
+		}
+		switch {
+		case old == nil:
+			changes = append(changes, &changeView{Status: "added", Path: file.Path, New: file})
+		case old.Id != file.Id || old.Mode != file.Mode || old.Link != file.Link:
+			changes = append(changes, &changeView{Status: "modified", Path: file.Path, Old: old, New: file})
+		}
+	}
+	if base != nil {
+		for _, file := range base.Files() {
+			if _, ok := commit.File(file.Path); !ok {
+				changes = append(changes, &changeView{Status: "removed", Path: file.Path, Old: file})
+			}
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+	return changes
+}
+
+// Only keep the changes within a file or directory
+func filterChanges(changes []*changeView, fpath string) (filtered []*changeView) {
+	for _, change := range changes {
+		if change.Path == fpath || strings.HasPrefix(change.Path, fpath+"/") {
+			filtered = append(filtered, change)
+		}
+	}
+	return filtered
+}
+
+// Fill in the line diff of a change. From is nil if there's no base.
+func diffChange(change *changeView, from, to *commitfs.FS) error {
+	oldText, ok, err := readText(from, change.Old)
+	if err != nil {
+		return err
+	} else if !ok {
+		change.Skipped = skipReason(change.Old)
+		return nil
+	}
+	newText, ok, err := readText(to, change.New)
+	if err != nil {
+		return err
+	} else if !ok {
+		change.Skipped = skipReason(change.New)
+		return nil
+	}
+	hunks, ok := diffText(oldText, newText)
+	if !ok {
+		change.Skipped = "too many changes to show"
+		return nil
+	}
+	change.Hunks = hunks
+	return nil
+}
+
+// Read the text of a file to diff. Symlinks are diffed by their target.
+// Returns false if the file can't be diffed.
+func readText(fsys *commitfs.FS, file *commits.File) (string, bool, error) {
+	if file == nil || file.IsDir() {
+		return "", true, nil
+	}
+	if file.Mode&fs.ModeSymlink != 0 {
+		link, err := fsys.Readlink(file.Path)
+		if err != nil {
+			return "", false, err
+		}
+		return link + "\n", true, nil
+	}
+	if !file.Mode.IsRegular() || file.Size > maxDiffSize {
+		return "", false, nil
+	}
+	data, err := fs.ReadFile(fsys, file.Path)
+	if err != nil {
+		return "", false, err
+	}
+	if !isText(data) {
+		return "", false, nil
+	}
+	return string(data), true, nil
+}
+
+func skipReason(file *commits.File) string {
+	switch {
+	case !file.Mode.IsRegular():
+		return "special file"
+	case file.Size > maxDiffSize:
+		return "file too large to diff"
+	default:
+		return "binary file"
+	}
+}
+
+type page struct {
+	Title string
+	Data  any
+}
+
+// Render a page into a buffer first, so errors can still be reported
+func (h *Handler) render(w http.ResponseWriter, r *http.Request, name, title string, data any) {
+	buf := new(bytes.Buffer)
+	if err := h.pages[name].ExecuteTemplate(buf, "layout", &page{title, data}); err != nil {
+		h.error(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+func (h *Handler) error(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, context.Canceled):
+		// The client went away
+	default:
+		h.log.Error("webui: unable to serve page",
+			slog.String("path", r.URL.Path),
+			slog.String("error", err.Error()),
+		)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+type commitView struct {
+	ID        string
+	Tags      []string
+	Size      uint64
+	User      string
+	CreatedAt time.Time
+	Summary   string
+	Message   string
+	Meta      []string
+	Parent    string
+}
+
+// newCommitView has the same details as the CLI's commit listings
+func newCommitView(commit *commits.Commit, tagMap map[string][]*tags.Tag) *commitView {
+	view := &commitView{
+		ID:        commit.ID(),
+		Size:      commit.Size(),
+		User:      commit.User(),
+		CreatedAt: commit.CreatedAt(),
+		Message:   commit.Message(),
+		Parent:    commit.Parent(),
+	}
+	view.Summary, _, _ = strings.Cut(view.Message, "\n")
+	for _, tag := range tagMap[view.ID] {
+		view.Tags = append(view.Tags, tag.Name)
+	}
+	meta := commit.Meta()
+	for key, value := range meta {
+		view.Meta = append(view.Meta, key+"="+value)
+	}
+	sort.Strings(view.Meta)
+	return view
+}
+
+// Clean a path from the URL into a path within the commit
+func cleanPath(fpath string) string {
+	fpath = strings.Trim(path.Clean("/"+fpath), "/")
+	if fpath == "" {
+		return "."
+	}
+	return fpath
+}
+
+// Escape each segment of a path for a URL
+func escapePath(fpath string) string {
+	if fpath == "." || fpath == "" {
+		return ""
+	}
+	segments := strings.Split(fpath, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+func commitURL(rev string) string {
+	return "/commits/" + url.PathEscape(rev)
+}
+
+func treeURL(rev, fpath string) string {
+	return "/tree/" + url.PathEscape(rev) + "/" + escapePath(fpath)
+}
+
+func rawURL(rev, fpath string) string {
+	return "/raw/" + url.PathEscape(rev) + "/" + escapePath(fpath)
+}
+
+func diffURL(rev, fpath string) string {
+	return "/diff/" + url.PathEscape(rev) + "/" + escapePath(fpath)
+}
+
+func tagURL(name string) string {
+	return "/tags/" + url.PathEscape(name)
+}
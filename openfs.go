@@ -0,0 +1,123 @@
+package chunky
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"io/fs"
+
+	"github.com/dustin/go-humanize"
+	"github.com/matthewmueller/chunky/internal/commitfs"
+	"github.com/matthewmueller/chunky/internal/commits"
+	"github.com/matthewmueller/chunky/internal/lru"
+	"github.com/matthewmueller/chunky/internal/packs"
+	"github.com/matthewmueller/chunky/internal/rate"
+	"github.com/matthewmueller/chunky/repos"
+)
+
+type OpenFS struct {
+	Repo     repos.Repo
+	Revision string
+
+	// MaxCacheSize is the maximum size of the LRU for caching packs (default: 512MiB)
+	MaxCacheSize string
+	maxCacheSize int
+
+	// CacheDir keeps downloaded packs on disk, so later runs don't download them
+	// again. Packs are cached by their ID, so each repository needs its own
+	// directory (optional)
+	CacheDir repos.FS
+
+	// MaxCacheDirSize is the maximum size of the packs in CacheDir (default: 2GiB)
+	MaxCacheDirSize string
+	maxCacheDirSize int
+
+	// LimitDownload is the maximum download speed per second (default: unlimited)
+	LimitDownload string
+	limitDownload int
+
+	// TrustedKeys, when set, refuse revisions that aren't signed by one of the
+	// keys
+	TrustedKeys []ed25519.PublicKey
+}
+
+func (in *OpenFS) validate() (err error) {
+	// Required fields
+	if in.Repo == nil {
+		err = errors.Join(err, errors.New("missing 'repo'"))
+	}
+	if in.Revision == "" {
+		err = errors.Join(err, errors.New("missing 'revision'"))
+	}
+
+	if in.MaxCacheSize != "" {
+		maxCacheSize, err2 := humanize.ParseBytes(in.MaxCacheSize)
+		if err2 != nil {
+			err = errors.Join(err, errors.New("invalid max cache size"))
+		} else {
+			in.maxCacheSize = int(maxCacheSize)
+		}
+	} else {
+		in.maxCacheSize = 512 * miB
+	}
+
+	if in.MaxCacheDirSize != "" {
+		maxCacheDirSize, err2 := humanize.ParseBytes(in.MaxCacheDirSize)
+		if err2 != nil {
+			err = errors.Join(err, errors.New("invalid max cache dir size"))
+		} else {
+			in.maxCacheDirSize = int(maxCacheDirSize)
+		}
+	} else {
+		in.maxCacheDirSize = DefaultMaxCacheDirSize
+	}
+
+	if in.LimitDownload != "" {
+		limitDownload, err2 := humanize.ParseBytes(in.LimitDownload)
+		if err2 != nil {
+			err = errors.Join(err, errors.New("invalid limit download"))
+		} else {
+			in.limitDownload = int(limitDownload)
+		}
+	} else {
+		in.limitDownload = 0
+	}
+
+	return err
+}
+
+// OpenFS opens a revision as a read-only filesystem. The returned filesystem
+// also implements fs.ReadDirFS and fs.StatFS, and its files implement
+// io.Seeker and io.ReaderAt. Packs are downloaded as files are read and shared
+// through one cache, so reading a file again is cheap. The filesystem stops
+// reading once the context is canceled.
+func (c *Client) OpenFS(ctx context.Context, in *OpenFS) (fs.FS, error) {
+	if err := in.validate(); err != nil {
+		return nil, err
+	}
+
+	// Create a cached pack reader with the specified max cache size
+	pr := packs.NewCachedReader(c.log, lru.New[*packs.Pack](c.log, in.maxCacheSize))
+	if in.CacheDir != nil {
+		pr.Disk = packs.NewDiskCache(c.log, in.CacheDir, in.maxCacheDirSize)
+	}
+
+	// Set the download limit if provided
+	if in.LimitDownload != "" {
+		pr.Limiter = rate.New(in.limitDownload)
+	}
+
+	// Verify the revision first if we have trusted keys
+	var commit *commits.Commit
+	var err error
+	if len(in.TrustedKeys) > 0 {
+		commit, err = verifyRevision(ctx, in.Repo, in.Revision, in.TrustedKeys)
+	} else {
+		commit, err = commits.Read(ctx, in.Repo, in.Revision)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return commitfs.New(ctx, in.Repo, commit, pr), nil
+}
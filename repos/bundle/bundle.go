@@ -0,0 +1,314 @@
+// Package bundle stores the commits, packs and tags of a repository in a
+// single file, so they can be moved to hosts that can't reach the repository.
+// Bundles are zip files with a manifest that lists the checksum of every file
+// and the commits a repository needs before the bundle can be imported.
+package bundle
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/matthewmueller/chunky/repos"
+)
+
+// ManifestPath is where the manifest is stored within the bundle
+const ManifestPath = "bundle.json"
+
+// version of the bundle format
+const version = 1
+
+// ErrReadOnly is returned when changing a bundle that's been created
+var ErrReadOnly = errors.New("bundle: read-only")
+
+// Manifest describes the contents of a bundle
+type Manifest struct {
+	Version int `json:"version"`
+	// Prerequisites are the commits a repository must have to import the
+	// bundle. They're empty unless the bundle was created against a base.
+	Prerequisites []string `json:"prerequisites,omitempty"`
+	// Files maps the path of each file to the sha256 of its data
+	Files map[string]string `json:"files"`
+	// CreatedAt is when the bundle was created
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Create a bundle that writes to w. Files are uploaded to the bundle like they
+// would be to a repository. The bundle isn't complete until it's closed.
+func Create(w io.Writer) *Writer {
+	return &Writer{
+		zw:    zip.NewWriter(w),
+		files: map[string]string{},
+	}
+}
+
+// Writer writes files to a bundle. Bundles are write-only while they're being
+// created, so downloads and walks behave as though the bundle is empty.
+type Writer struct {
+	// Prerequisites are recorded in the manifest when the bundle is closed
+	Prerequisites []string
+
+	mu    sync.Mutex
+	zw    *zip.Writer
+	files map[string]string
+}
+
+var _ repos.Repo = (*Writer)(nil)
+var _ repos.Remover = (*Writer)(nil)
+
+func (w *Writer) Upload(ctx context.Context, fromCh <-chan *repos.File) error {
+	for file := range fromCh {
+		if err := w.write(file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *Writer) write(file *repos.File) error {
+	if file.IsDir() {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.files[file.Path]; ok {
+		return fmt.Errorf("bundle: %q is already in the bundle", file.Path)
+	}
+	// Packs are already compressed, so files are stored as they are
+	header := &zip.FileHeader{
+		Name:     file.Path,
+		Method:   zip.Store,
+		Modified: file.ModTime,
+	}
+	header.SetMode(file.Mode)
+	writer, err := w.zw.CreateHeader(header)
+	if err != nil {
+		return fmt.Errorf("bundle: unable to add %q: %w", file.Path, err)
+	}
+	if _, err := writer.Write(file.Data); err != nil {
+		return fmt.Errorf("bundle: unable to write %q: %w", file.Path, err)
+	}
+	w.files[file.Path] = checksum(file.Data)
+	return nil
+}
+
+func (w *Writer) Download(ctx context.Context, toCh chan<- *repos.File, paths ...string) error {
+	return &fs.PathError{Op: "download", Path: path.Join(paths...), Err: fs.ErrNotExist}
+}
+
+func (w *Writer) Walk(ctx context.Context, dir string, fn fs.WalkDirFunc) error {
+	err := fn(dir, nil, &fs.PathError{Op: "walk", Path: dir, Err: fs.ErrNotExist})
+	if err == fs.SkipDir || err == fs.SkipAll {
+		return nil
+	}
+	return err
+}
+
+// Remove only reports files that aren't in the bundle, since files can't be
+// removed once they're written
+func (w *Writer) Remove(ctx context.Context, paths ...string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, fpath := range paths {
+		if _, ok := w.files[fpath]; ok {
+			return fmt.Errorf("bundle: unable to remove %q: %w", fpath, ErrReadOnly)
+		}
+	}
+	return &fs.PathError{Op: "remove", Path: path.Join(paths...), Err: fs.ErrNotExist}
+}
+
+// Close writes the manifest and finishes the bundle. It doesn't close the
+// underlying writer.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	manifest := &Manifest{
+		Version:       version,
+		Prerequisites: w.Prerequisites,
+		Files:         w.files,
+		CreatedAt:     time.Now().UTC(),
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("bundle: unable to encode manifest: %w", err)
+	}
+	writer, err := w.zw.CreateHeader(&zip.FileHeader{
+		Name:     ManifestPath,
+		Method:   zip.Deflate,
+		Modified: manifest.CreatedAt,
+	})
+	if err != nil {
+		return fmt.Errorf("bundle: unable to add manifest: %w", err)
+	}
+	if _, err := writer.Write(data); err != nil {
+		return fmt.Errorf("bundle: unable to write manifest: %w", err)
+	}
+	return w.zw.Close()
+}
+
+// Open a bundle file as a read-only repository
+func Open(fpath string) (*Repo, error) {
+	file, err := os.Open(fpath)
+	if err != nil {
+		return nil, fmt.Errorf("bundle: unable to open %q: %w", fpath, err)
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("bundle: unable to stat %q: %w", fpath, err)
+	}
+	repo, err := Read(file, stat.Size())
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	repo.closer = file
+	return repo, nil
+}
+
+// Read a bundle as a read-only repository
+func Read(r io.ReaderAt, size int64) (*Repo, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("bundle: unable to read bundle: %w", err)
+	}
+	data, err := fs.ReadFile(zr, ManifestPath)
+	if err != nil {
+		return nil, fmt.Errorf("bundle: unable to read manifest: %w", err)
+	}
+	manifest := new(Manifest)
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("bundle: unable to parse manifest: %w", err)
+	}
+	if manifest.Version != version {
+		return nil, fmt.Errorf("bundle: unsupported version %d", manifest.Version)
+	}
+	files := map[string]*zip.File{}
+	for _, file := range zr.File {
+		files[file.Name] = file
+	}
+	for fpath := range manifest.Files {
+		if _, ok := files[fpath]; !ok {
+			return nil, fmt.Errorf("bundle: %q is missing from the bundle", fpath)
+		}
+	}
+	return &Repo{
+		Manifest: manifest,
+		zr:       zr,
+		files:    files,
+	}, nil
+}
+
+// Repo is a bundle that's read like a repository
+type Repo struct {
+	Manifest *Manifest
+	zr       *zip.Reader
+	files    map[string]*zip.File
+	closer   io.Closer
+}
+
+var _ repos.Repo = (*Repo)(nil)
+var _ repos.Remover = (*Repo)(nil)
+
+func (r *Repo) Upload(ctx context.Context, fromCh <-chan *repos.File) error {
+	return ErrReadOnly
+}
+
+// Download files from the bundle, checking them against the manifest
+func (r *Repo) Download(ctx context.Context, toCh chan<- *repos.File, paths ...string) error {
+	target := path.Join(paths...)
+	if target == "" {
+		target = "."
+	}
+	return r.Walk(ctx, target, func(fpath string, de fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		} else if de.IsDir() {
+			return nil
+		}
+		file, err := r.read(fpath)
+		if err != nil {
+			return err
+		}
+		toCh <- file
+		return nil
+	})
+}
+
+func (r *Repo) read(fpath string) (*repos.File, error) {
+	zf, ok := r.files[fpath]
+	if !ok {
+		return nil, &fs.PathError{Op: "read", Path: fpath, Err: fs.ErrNotExist}
+	}
+	rc, err := zf.Open()
+	if err != nil {
+		return nil, fmt.Errorf("bundle: unable to open %q: %w", fpath, err)
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, fmt.Errorf("bundle: unable to read %q: %w", fpath, err)
+	}
+	if checksum(data) != r.Manifest.Files[fpath] {
+		return nil, fmt.Errorf("bundle: %q doesn't match the manifest", fpath)
+	}
+	return &repos.File{
+		Path:    fpath,
+		Data:    data,
+		Mode:    zf.Mode(),
+		ModTime: zf.Modified,
+	}, nil
+}
+
+// Walk the files in the bundle. The manifest isn't part of the repository, so
+// it's skipped.
+func (r *Repo) Walk(ctx context.Context, dir string, fn fs.WalkDirFunc) error {
+	return fs.WalkDir(r.zr, dir, func(fpath string, de fs.DirEntry, err error) error {
+		if fpath == ManifestPath {
+			return nil
+		}
+		return fn(fpath, de, err)
+	})
+}
+
+func (r *Repo) Remove(ctx context.Context, paths ...string) error {
+	return ErrReadOnly
+}
+
+// Verify that every file in the bundle matches the manifest
+func (r *Repo) Verify() error {
+	paths := make([]string, 0, len(r.Manifest.Files))
+	for fpath := range r.Manifest.Files {
+		paths = append(paths, fpath)
+	}
+	sort.Strings(paths)
+	for _, fpath := range paths {
+		if _, err := r.read(fpath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Repo) Close() error {
+	if r.closer == nil {
+		return nil
+	}
+	return r.closer.Close()
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
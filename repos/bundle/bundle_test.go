@@ -0,0 +1,77 @@
+package bundle_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"github.com/matthewmueller/chunky/repos"
+	"github.com/matthewmueller/chunky/repos/bundle"
+)
+
+func TestCreateRead(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	buf := new(bytes.Buffer)
+	writer := bundle.Create(buf)
+	writer.Prerequisites = []string{"20240101000000"}
+	now := time.Now().Truncate(time.Second)
+	is.NoErr(repos.Upload(ctx, writer, &repos.File{Path: "commits/a", Data: []byte("commit"), Mode: 0644, ModTime: now}))
+	is.NoErr(repos.Upload(ctx, writer, &repos.File{Path: "packs/b", Data: []byte("pack"), Mode: 0644, ModTime: now}))
+	// Files can only be added once
+	is.True(repos.Upload(ctx, writer, &repos.File{Path: "packs/b", Data: []byte("pack"), Mode: 0644}) != nil)
+	// Nothing can be read while the bundle is being created
+	_, err := repos.Download(ctx, writer, "packs/b")
+	is.True(errors.Is(err, fs.ErrNotExist))
+	is.NoErr(writer.Close())
+
+	repo, err := bundle.Read(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	is.NoErr(err)
+	is.Equal(repo.Manifest.Prerequisites, []string{"20240101000000"})
+	is.NoErr(repo.Verify())
+	file, err := repos.Download(ctx, repo, "packs/b")
+	is.NoErr(err)
+	is.Equal(string(file.Data), "pack")
+	is.True(file.ModTime.Equal(now))
+	_, err = repos.Download(ctx, repo, "packs/c")
+	is.True(errors.Is(err, fs.ErrNotExist))
+
+	// The manifest isn't part of the repository
+	var paths []string
+	err = repo.Walk(ctx, ".", func(fpath string, de fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		} else if !de.IsDir() {
+			paths = append(paths, fpath)
+		}
+		return nil
+	})
+	is.NoErr(err)
+	is.Equal(paths, []string{"commits/a", "packs/b"})
+
+	// Bundles are read-only
+	is.True(errors.Is(repos.Upload(ctx, repo, &repos.File{Path: "packs/c"}), bundle.ErrReadOnly))
+}
+
+func TestTampered(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	buf := new(bytes.Buffer)
+	writer := bundle.Create(buf)
+	is.NoErr(repos.Upload(ctx, writer, &repos.File{Path: "packs/b", Data: []byte("secret"), Mode: 0644}))
+	is.NoErr(writer.Close())
+
+	// Change the pack's data, which is stored uncompressed
+	data := bytes.Replace(buf.Bytes(), []byte("secret"), []byte("evil!!"), 1)
+	repo, err := bundle.Read(bytes.NewReader(data), int64(len(data)))
+	is.NoErr(err)
+	is.True(repo.Verify() != nil)
+	_, err = repos.Download(ctx, repo, "packs/b")
+	is.True(err != nil)
+}
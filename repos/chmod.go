@@ -0,0 +1,28 @@
+package repos
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/matthewmueller/virt"
+)
+
+// ChmodFS is implemented by filesystems that can change file modes
+type ChmodFS interface {
+	Chmod(name string, mode fs.FileMode) error
+}
+
+// Chmod changes the mode of a file. Returns errors.ErrUnsupported if the
+// filesystem doesn't support changing modes.
+func Chmod(fsys FS, name string, mode fs.FileMode) error {
+	switch fsys := fsys.(type) {
+	case ChmodFS:
+		return fsys.Chmod(name, mode)
+	case virt.OS:
+		return os.Chmod(filepath.Join(string(fsys), name), mode)
+	default:
+		return errors.ErrUnsupported
+	}
+}
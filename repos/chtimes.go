@@ -0,0 +1,48 @@
+package repos
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/matthewmueller/virt"
+)
+
+// ChtimesFS is implemented by filesystems that can change file times
+type ChtimesFS interface {
+	Chtimes(name string, atime, mtime time.Time) error
+}
+
+// LchtimesFS is implemented by filesystems that can change the times of a
+// symlink itself, rather than the file it points to
+type LchtimesFS interface {
+	Lchtimes(name string, atime, mtime time.Time) error
+}
+
+// Chtimes changes the access and modification times of a file. Returns
+// errors.ErrUnsupported if the filesystem doesn't support changing times.
+func Chtimes(fsys FS, name string, atime, mtime time.Time) error {
+	switch fsys := fsys.(type) {
+	case ChtimesFS:
+		return fsys.Chtimes(name, atime, mtime)
+	case virt.OS:
+		return os.Chtimes(filepath.Join(string(fsys), name), atime, mtime)
+	default:
+		return errors.ErrUnsupported
+	}
+}
+
+// Lchtimes changes the access and modification times of a symlink. Returns
+// errors.ErrUnsupported if the filesystem doesn't support changing symlink
+// times.
+func Lchtimes(fsys FS, name string, atime, mtime time.Time) error {
+	switch fsys := fsys.(type) {
+	case LchtimesFS:
+		return fsys.Lchtimes(name, atime, mtime)
+	case virt.OS:
+		return lchtimes(filepath.Join(string(fsys), name), atime, mtime)
+	default:
+		return errors.ErrUnsupported
+	}
+}
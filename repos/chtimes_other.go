@@ -0,0 +1,12 @@
+//go:build !unix
+
+package repos
+
+import (
+	"errors"
+	"time"
+)
+
+func lchtimes(path string, atime, mtime time.Time) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,53 @@
+package repos
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+
+	"github.com/matthewmueller/virt"
+)
+
+// IsSpecial returns true for FIFOs, sockets, devices and other files that
+// aren't regular files, directories or symlinks
+func IsSpecial(mode fs.FileMode) bool {
+	return mode&(fs.ModeNamedPipe|fs.ModeSocket|fs.ModeDevice|fs.ModeCharDevice|fs.ModeIrregular) != 0
+}
+
+// DeviceInfo is implemented by file infos that carry device numbers without a
+// system stat, such as entries in an archive
+type DeviceInfo interface {
+	DeviceNumber() (major, minor uint32)
+}
+
+// DeviceNumber returns the major and minor numbers of a device file. Ok is
+// false if the file isn't a device or the file info doesn't carry them.
+func DeviceNumber(info fs.FileInfo) (major, minor uint32, ok bool) {
+	if info.Mode()&fs.ModeDevice == 0 {
+		return 0, 0, false
+	}
+	if info, ok := info.(DeviceInfo); ok {
+		major, minor = info.DeviceNumber()
+		return major, minor, true
+	}
+	return deviceNumber(info)
+}
+
+// MknodFS is implemented by filesystems that can create FIFOs, sockets and
+// devices
+type MknodFS interface {
+	Mknod(name string, mode fs.FileMode, major, minor uint32) error
+}
+
+// Mknod creates a FIFO, socket or device file. Returns errors.ErrUnsupported if
+// the filesystem doesn't support special files.
+func Mknod(fsys FS, name string, mode fs.FileMode, major, minor uint32) error {
+	switch fsys := fsys.(type) {
+	case MknodFS:
+		return fsys.Mknod(name, mode, major, minor)
+	case virt.OS:
+		return mknod(filepath.Join(string(fsys), name), mode, major, minor)
+	default:
+		return errors.ErrUnsupported
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !(linux || darwin || dragonfly || netbsd || openbsd)
+
+package repos
+
+import (
+	"errors"
+	"io/fs"
+)
+
+func deviceNumber(info fs.FileInfo) (major, minor uint32, ok bool) {
+	return 0, 0, false
+}
+
+func mknod(path string, mode fs.FileMode, major, minor uint32) error {
+	return errors.ErrUnsupported
+}
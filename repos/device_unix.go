@@ -0,0 +1,40 @@
+//go:build linux || darwin || dragonfly || netbsd || openbsd
+
+package repos
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+func deviceNumber(info fs.FileInfo) (major, minor uint32, ok bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return unix.Major(uint64(stat.Rdev)), unix.Minor(uint64(stat.Rdev)), true
+}
+
+func mknod(path string, mode fs.FileMode, major, minor uint32) error {
+	perm := uint32(mode.Perm())
+	switch {
+	case mode&fs.ModeNamedPipe != 0:
+		perm |= unix.S_IFIFO
+	case mode&fs.ModeSocket != 0:
+		perm |= unix.S_IFSOCK
+	case mode&fs.ModeCharDevice != 0:
+		perm |= unix.S_IFCHR
+	case mode&fs.ModeDevice != 0:
+		perm |= unix.S_IFBLK
+	default:
+		return errors.ErrUnsupported
+	}
+	if err := unix.Mknod(path, perm, int(unix.Mkdev(major, minor))); err != nil {
+		return &os.PathError{Op: "mknod", Path: path, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package repos
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/matthewmueller/virt"
+)
+
+// LinkFS is implemented by filesystems that can create hard links
+type LinkFS interface {
+	Link(oldname, newname string) error
+}
+
+// Link creates newname as a hard link to oldname. Returns
+// errors.ErrUnsupported if the filesystem doesn't support hard links.
+func Link(fsys FS, oldname, newname string) error {
+	switch fsys := fsys.(type) {
+	case LinkFS:
+		return fsys.Link(oldname, newname)
+	case virt.OS:
+		return os.Link(filepath.Join(string(fsys), oldname), filepath.Join(string(fsys), newname))
+	default:
+		return errors.ErrUnsupported
+	}
+}
+
+// FileID identifies a file on a device. Files with the same ID are hard links
+// to each other.
+type FileID struct {
+	Dev uint64
+	Ino uint64
+}
+
+// LinkInfo is implemented by file infos that identify hard links without a
+// system stat, such as entries in an archive. Files may be identified before
+// it's known whether anything links to them.
+type LinkInfo interface {
+	FileID() (id FileID, ok bool)
+}
+
+// HardLink returns the ID of a file with more than one hard link. Ok is false
+// if the file isn't hard linked or the file info doesn't carry an inode.
+func HardLink(info fs.FileInfo) (id FileID, ok bool) {
+	if !info.Mode().IsRegular() {
+		return id, false
+	}
+	if info, ok := info.(LinkInfo); ok {
+		return info.FileID()
+	}
+	return hardLink(info)
+}
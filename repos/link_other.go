@@ -0,0 +1,11 @@
+//go:build !unix
+
+package repos
+
+import (
+	"io/fs"
+)
+
+func hardLink(info fs.FileInfo) (id FileID, ok bool) {
+	return id, false
+}
@@ -0,0 +1,16 @@
+//go:build unix
+
+package repos
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+func hardLink(info fs.FileInfo) (id FileID, ok bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || stat.Nlink <= 1 {
+		return id, false
+	}
+	return FileID{Dev: uint64(stat.Dev), Ino: uint64(stat.Ino)}, true
+}
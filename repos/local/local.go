@@ -23,6 +23,7 @@ type Repo struct {
 }
 
 var _ repos.Repo = (*Repo)(nil)
+var _ repos.Remover = (*Repo)(nil)
 
 func (r *Repo) Upload(ctx context.Context, fromCh <-chan *repos.File) error {
 	eg := new(errgroup.Group)
@@ -77,6 +78,18 @@ func (r *Repo) Walk(ctx context.Context, dir string, fn fs.WalkDirFunc) error {
 	return fs.WalkDir(r.fsys, dir, fn)
 }
 
+func (r *Repo) Remove(ctx context.Context, paths ...string) error {
+	for _, path := range paths {
+		if _, err := r.fsys.Lstat(path); err != nil {
+			return fmt.Errorf("repo: unable to remove %q: %w", path, err)
+		}
+		if err := r.fsys.RemoveAll(path); err != nil {
+			return fmt.Errorf("repo: unable to remove %q: %w", path, err)
+		}
+	}
+	return nil
+}
+
 func (r *Repo) Close() error {
 	return nil
 }
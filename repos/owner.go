@@ -0,0 +1,35 @@
+package repos
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/matthewmueller/virt"
+)
+
+// LchownFS is implemented by filesystems that can change the ownership of a
+// file without following symlinks
+type LchownFS interface {
+	Lchown(name string, uid, gid int) error
+}
+
+// Lchown changes the numeric uid and gid of a file. Returns
+// errors.ErrUnsupported if the filesystem doesn't support changing ownership.
+func Lchown(fsys FS, name string, uid, gid int) error {
+	switch fsys := fsys.(type) {
+	case LchownFS:
+		return fsys.Lchown(name, uid, gid)
+	case virt.OS:
+		return os.Lchown(filepath.Join(string(fsys), name), uid, gid)
+	default:
+		return errors.ErrUnsupported
+	}
+}
+
+// FileOwner returns the numeric uid and gid of a file from its file info. Ok
+// is false if the file info doesn't carry ownership.
+func FileOwner(info fs.FileInfo) (uid, gid int, ok bool) {
+	return fileOwner(info)
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package repos
+
+import (
+	"io/fs"
+)
+
+func fileOwner(info fs.FileInfo) (uid, gid int, ok bool) {
+	return 0, 0, false
+}
@@ -2,6 +2,7 @@ package repos
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io/fs"
 	"net/url"
@@ -54,6 +55,20 @@ type Repo interface {
 	Close() error
 }
 
+// Remover is implemented by repositories that can remove paths
+type Remover interface {
+	Remove(ctx context.Context, paths ...string) error
+}
+
+// Remove paths from the repository. Returns errors.ErrUnsupported if the
+// repository can't remove paths.
+func Remove(ctx context.Context, repo Repo, paths ...string) error {
+	if repo, ok := repo.(Remover); ok {
+		return repo.Remove(ctx, paths...)
+	}
+	return errors.ErrUnsupported
+}
+
 // Download a single file from the repository.
 func Download(ctx context.Context, repo Repo, path string) (*File, error) {
 	fileCh := make(chan *File, 1)
@@ -63,3 +78,14 @@ func Download(ctx context.Context, repo Repo, path string) (*File, error) {
 	close(fileCh)
 	return <-fileCh, nil
 }
+
+// Upload a single file to the repository, returning once it's been uploaded
+func Upload(ctx context.Context, repo Repo, file *File) error {
+	fileCh := make(chan *File, 1)
+	fileCh <- file
+	close(fileCh)
+	if err := repo.Upload(ctx, fileCh); err != nil {
+		return fmt.Errorf("repos: unable to upload file %q: %w", file.Path, err)
+	}
+	return nil
+}
@@ -2,10 +2,12 @@ package sftp
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io/fs"
 	"os"
 	"path"
+	"time"
 
 	"github.com/matthewmueller/chunky/repos"
 	"github.com/matthewmueller/virt"
@@ -111,6 +113,52 @@ func writeFile(sftp *sftp.Client, name string, data []byte, mode fs.FileMode) er
 	return nil
 }
 
+var _ repos.ChtimesFS = (*Repo)(nil)
+
+func (r *Repo) Chtimes(name string, atime, mtime time.Time) error {
+	if err := r.sftp.Chtimes(path.Join(r.dir, name), atime, mtime); err != nil {
+		return fmt.Errorf("sftp: unable to change times of %q: %w", name, err)
+	}
+	return nil
+}
+
+var _ repos.ChmodFS = (*Repo)(nil)
+
+func (r *Repo) Chmod(name string, mode fs.FileMode) error {
+	if err := r.sftp.Chmod(path.Join(r.dir, name), mode); err != nil {
+		return fmt.Errorf("sftp: unable to change mode of %q: %w", name, err)
+	}
+	return nil
+}
+
+var _ repos.LchownFS = (*Repo)(nil)
+
+// Lchown changes the ownership of a file. SFTP follows symlinks when changing
+// ownership, so symlinks aren't supported.
+func (r *Repo) Lchown(name string, uid, gid int) error {
+	fpath := path.Join(r.dir, name)
+	info, err := r.sftp.Lstat(fpath)
+	if err != nil {
+		return fmt.Errorf("sftp: unable to stat %q: %w", name, err)
+	}
+	if info.Mode()&fs.ModeSymlink != 0 {
+		return errors.ErrUnsupported
+	}
+	if err := r.sftp.Chown(fpath, uid, gid); err != nil {
+		return fmt.Errorf("sftp: unable to change owner of %q: %w", name, err)
+	}
+	return nil
+}
+
+var _ repos.LinkFS = (*Repo)(nil)
+
+func (r *Repo) Link(oldname, newname string) error {
+	if err := r.sftp.Link(path.Join(r.dir, oldname), path.Join(r.dir, newname)); err != nil {
+		return fmt.Errorf("sftp: unable to link %q to %q: %w", newname, oldname, err)
+	}
+	return nil
+}
+
 func (r *Repo) RemoveAll(name string) error {
 	return r.sftp.RemoveAll(path.Join(r.dir, name))
 }
@@ -61,6 +61,7 @@ type Repo struct {
 }
 
 var _ repos.Repo = (*Repo)(nil)
+var _ repos.Remover = (*Repo)(nil)
 
 func (c *Repo) Close() (err error) {
 	return c.closer()
@@ -152,6 +153,16 @@ func (c *Repo) downloadFile(toCh chan<- *repos.File, path string) error {
 	return nil
 }
 
+func (c *Repo) Remove(ctx context.Context, paths ...string) error {
+	for _, path := range paths {
+		remotePath := filepath.Join(c.dir, path)
+		if err := c.sftp.Remove(remotePath); err != nil {
+			return fmt.Errorf("sftp: unable to remove %q: %w", remotePath, err)
+		}
+	}
+	return nil
+}
+
 func (c *Repo) Walk(ctx context.Context, dir string, fn fs.WalkDirFunc) error {
 	walker := c.sftp.Walk(filepath.Join(c.dir, dir))
 	for walker.Step() {
@@ -0,0 +1,19 @@
+package repos
+
+import (
+	"io/fs"
+)
+
+// Inode is the part of a file's status that changes whenever the file does,
+// beyond its size and modification time
+type Inode struct {
+	Dev   uint64
+	Ino   uint64
+	Ctime int64 // status change time in nanoseconds
+}
+
+// FileInode returns the inode of a file from its file info. Ok is false if the
+// file info doesn't carry an inode.
+func FileInode(info fs.FileInfo) (inode Inode, ok bool) {
+	return fileInode(info)
+}
@@ -0,0 +1,20 @@
+//go:build linux || openbsd || dragonfly || solaris || illumos || aix
+
+package repos
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+func fileInode(info fs.FileInfo) (inode Inode, ok bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return inode, false
+	}
+	return Inode{
+		Dev:   uint64(stat.Dev),
+		Ino:   uint64(stat.Ino),
+		Ctime: stat.Ctim.Nano(),
+	}, true
+}
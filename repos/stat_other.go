@@ -0,0 +1,11 @@
+//go:build !(linux || openbsd || dragonfly || solaris || illumos || aix || darwin || ios || freebsd || netbsd)
+
+package repos
+
+import (
+	"io/fs"
+)
+
+func fileInode(info fs.FileInfo) (inode Inode, ok bool) {
+	return inode, false
+}
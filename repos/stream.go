@@ -0,0 +1,22 @@
+package repos
+
+import (
+	"errors"
+	"io/fs"
+)
+
+// StreamFS is implemented by filesystems that are read in order, such as
+// compressed tarballs. Their files are only known once they've been streamed.
+type StreamFS interface {
+	Stream(fn func(fpath string, info fs.FileInfo) error) error
+}
+
+// Stream calls fn with each file in the order they're stored. Files can only be
+// opened while fn is called with them. Returns errors.ErrUnsupported if the
+// filesystem can be walked instead.
+func Stream(fsys ReadFS, fn func(fpath string, info fs.FileInfo) error) error {
+	if fsys, ok := fsys.(StreamFS); ok {
+		return fsys.Stream(fn)
+	}
+	return errors.ErrUnsupported
+}
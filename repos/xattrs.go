@@ -0,0 +1,41 @@
+package repos
+
+import (
+	"errors"
+	"path/filepath"
+
+	"github.com/matthewmueller/virt"
+)
+
+// XattrFS is implemented by filesystems that can read and write extended
+// attributes without following symlinks
+type XattrFS interface {
+	Lxattrs(name string) (map[string][]byte, error)
+	Lsetxattr(name, attr string, value []byte) error
+}
+
+// Lxattrs returns the extended attributes of a file. Returns
+// errors.ErrUnsupported if the filesystem doesn't support extended attributes.
+func Lxattrs(fsys ReadFS, name string) (map[string][]byte, error) {
+	switch fsys := fsys.(type) {
+	case XattrFS:
+		return fsys.Lxattrs(name)
+	case virt.OS:
+		return lxattrs(filepath.Join(string(fsys), name))
+	default:
+		return nil, errors.ErrUnsupported
+	}
+}
+
+// Lsetxattr sets an extended attribute on a file. Returns
+// errors.ErrUnsupported if the filesystem doesn't support extended attributes.
+func Lsetxattr(fsys FS, name, attr string, value []byte) error {
+	switch fsys := fsys.(type) {
+	case XattrFS:
+		return fsys.Lsetxattr(name, attr, value)
+	case virt.OS:
+		return lsetxattr(filepath.Join(string(fsys), name), attr, value)
+	default:
+		return errors.ErrUnsupported
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !(linux || darwin)
+
+package repos
+
+import (
+	"errors"
+)
+
+func lxattrs(path string) (map[string][]byte, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func lsetxattr(path, attr string, value []byte) error {
+	return errors.ErrUnsupported
+}
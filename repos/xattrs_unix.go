@@ -0,0 +1,60 @@
+//go:build linux || darwin
+
+package repos
+
+import (
+	"bytes"
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+func lxattrs(path string) (map[string][]byte, error) {
+	size, err := unix.Llistxattr(path, nil)
+	if err != nil {
+		return nil, xattrError("llistxattr", path, err)
+	}
+	if size == 0 {
+		return nil, nil
+	}
+	names := make([]byte, size)
+	size, err = unix.Llistxattr(path, names)
+	if err != nil {
+		return nil, xattrError("llistxattr", path, err)
+	}
+	xattrs := map[string][]byte{}
+	for _, name := range bytes.Split(names[:size], []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+		attr := string(name)
+		size, err := unix.Lgetxattr(path, attr, nil)
+		if err != nil {
+			return nil, xattrError("lgetxattr", path, err)
+		}
+		value := make([]byte, size)
+		if size > 0 {
+			if size, err = unix.Lgetxattr(path, attr, value); err != nil {
+				return nil, xattrError("lgetxattr", path, err)
+			}
+		}
+		xattrs[attr] = value[:size]
+	}
+	return xattrs, nil
+}
+
+func lsetxattr(path, attr string, value []byte) error {
+	if err := unix.Lsetxattr(path, attr, value, 0); err != nil {
+		return xattrError("lsetxattr", path, err)
+	}
+	return nil
+}
+
+// Map unsupported filesystems onto errors.ErrUnsupported
+func xattrError(op, path string, err error) error {
+	if errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.EOPNOTSUPP) {
+		return errors.ErrUnsupported
+	}
+	return &os.PathError{Op: op, Path: path, Err: err}
+}
@@ -0,0 +1,434 @@
+package chunky
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os/user"
+	"path"
+	"time"
+
+	"github.com/matthewmueller/chunky/internal/commits"
+	"github.com/matthewmueller/chunky/internal/signatures"
+	"github.com/matthewmueller/chunky/internal/tags"
+	"github.com/matthewmueller/chunky/repos"
+	"github.com/matthewmueller/logs"
+)
+
+// ErrProtected is returned when a tag change isn't allowed by the repository's
+// tag policy
+var ErrProtected = tags.ErrProtected
+
+// Default to the current user
+func defaultUser(name *string) error {
+	if *name != "" {
+		return nil
+	}
+	user, err := user.Current()
+	if err != nil {
+		return fmt.Errorf("missing user and getting current user failed with: %w", err)
+	}
+	*name = user.Username
+	return nil
+}
+
+type TagRevision struct {
+	Repo     repos.Repo
+	Tag      string
+	Revision string
+	// User moving the tag (default: current user)
+	User string
+	// Force moves the tag even if it's protected. The override is recorded in
+	// the tag's history.
+	Force bool
+	// SigningKey signs the tag (optional)
+	SigningKey ed25519.PrivateKey
+}
+
+func (in *TagRevision) validate() (err error) {
+	if in.Repo == nil {
+		err = errors.Join(err, errors.New("missing 'repo'"))
+	}
+	if in.Tag == "" {
+		err = errors.Join(err, errors.New("missing 'tag'"))
+	} else if err2 := tags.ValidateName(in.Tag); err2 != nil {
+		err = errors.Join(err, err2)
+	}
+	if in.Revision == "" {
+		err = errors.Join(err, errors.New("missing 'revision'"))
+	}
+	if err2 := defaultUser(&in.User); err2 != nil {
+		err = errors.Join(err, err2)
+	}
+	return err
+}
+
+// TagRevision tags a revision
+func (c *Client) TagRevision(ctx context.Context, in *TagRevision) error {
+	if err := in.validate(); err != nil {
+		return err
+	}
+
+	// Check that the commit exists
+	commit, err := commits.Read(ctx, in.Repo, in.Revision)
+	if err != nil {
+		return fmt.Errorf("cli: unable to read commit for %s: %w", in.Revision, err)
+	}
+
+	policy, err := tags.ReadPolicy(ctx, in.Repo)
+	if err != nil {
+		return err
+	}
+
+	// Move the tag to the commit
+	tag, err := c.moveTag(ctx, in.Repo, policy, &moveTag{
+		Name:     in.Tag,
+		CommitID: commit.ID(),
+		User:     in.User,
+		MovedAt:  time.Now().UTC(),
+		Force:    in.Force,
+	})
+	if err != nil {
+		return err
+	}
+
+	// Upload the tag file
+	return uploadTag(ctx, in.Repo, tag, in.SigningKey)
+}
+
+type moveTag struct {
+	Name     string
+	CommitID string
+	User     string
+	MovedAt  time.Time
+	Force    bool
+}
+
+// Move a tag to a commit, enforcing the tag policy. If the tag already exists,
+// the commit is appended to the tag's history.
+func (c *Client) moveTag(ctx context.Context, repo repos.Repo, policy *tags.Policy, in *moveTag) (*tags.Tag, error) {
+	tag, err := tags.Read(ctx, repo, in.Name)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("cli: unable to read tag for %s: %w", in.Name, err)
+		}
+		tag = &tags.Tag{
+			Name: in.Name,
+		}
+	}
+	forced, err := c.checkPolicy(policy, tag, tags.OpMove, in.User, in.Force)
+	if err != nil {
+		return nil, err
+	}
+	entry := tag.Move(in.CommitID, in.User, in.MovedAt)
+	entry.Forced = forced
+	return tag, nil
+}
+
+// Check the policy, returning true if the policy was overridden
+func (c *Client) checkPolicy(policy *tags.Policy, tag *tags.Tag, op tags.Op, user string, force bool) (forced bool, err error) {
+	if err := policy.Check(tag, op, user); err != nil {
+		if !force {
+			return false, err
+		}
+		logs.Scope(c.log).Warn("overriding tag protection",
+			slog.String("tag", tag.Name),
+			slog.String("op", string(op)),
+			slog.String("user", user),
+		)
+		return true, nil
+	}
+	return false, nil
+}
+
+type DeleteTag struct {
+	Repo repos.Repo
+	Tag  string
+	// User deleting the tag (default: current user)
+	User string
+	// Force deletes the tag even if it's protected
+	Force bool
+}
+
+func (in *DeleteTag) validate() (err error) {
+	if in.Repo == nil {
+		err = errors.Join(err, errors.New("missing 'repo'"))
+	}
+	if in.Tag == "" {
+		err = errors.Join(err, errors.New("missing 'tag'"))
+	} else if in.Tag == "latest" {
+		err = errors.Join(err, errors.New("tag cannot be 'latest'"))
+	}
+	if err2 := defaultUser(&in.User); err2 != nil {
+		err = errors.Join(err, err2)
+	}
+	return err
+}
+
+// DeleteTag deletes a tag along with its history
+func (c *Client) DeleteTag(ctx context.Context, in *DeleteTag) error {
+	if err := in.validate(); err != nil {
+		return err
+	}
+	tag, err := tags.Read(ctx, in.Repo, in.Tag)
+	if err != nil {
+		return fmt.Errorf("chunky: unable to read tag %q: %w", in.Tag, err)
+	}
+	policy, err := tags.ReadPolicy(ctx, in.Repo)
+	if err != nil {
+		return err
+	}
+	if _, err := c.checkPolicy(policy, tag, tags.OpDelete, in.User, in.Force); err != nil {
+		return err
+	}
+	if err := tags.Delete(ctx, in.Repo, in.Tag); err != nil {
+		return err
+	}
+	return removeTagSignature(ctx, in.Repo, in.Tag)
+}
+
+type TagLog struct {
+	Repo repos.Repo
+	Tag  string
+}
+
+func (in *TagLog) validate() (err error) {
+	if in.Repo == nil {
+		err = errors.Join(err, errors.New("missing 'repo'"))
+	}
+	if in.Tag == "" {
+		err = errors.Join(err, errors.New("missing 'tag'"))
+	}
+	return err
+}
+
+// TagEntry is a commit that a tag pointed to, along with who moved the tag
+// there and when. User and MovedAt may be empty for older tags.
+type TagEntry struct {
+	Commit  *Commit
+	User    string
+	MovedAt time.Time
+	// Forced is true if the move overrode the tag's protection
+	Forced bool
+	// Rollback is true if the move rolled the tag back to its previous commit
+	Rollback bool
+}
+
+// TagLog returns every commit a tag has pointed to, newest first
+func (c *Client) TagLog(ctx context.Context, in *TagLog) (entries []*TagEntry, err error) {
+	if err := in.validate(); err != nil {
+		return nil, err
+	}
+	tag, err := tags.Read(ctx, in.Repo, in.Tag)
+	if err != nil {
+		return nil, fmt.Errorf("chunky: unable to read tag %q: %w", in.Tag, err)
+	}
+	for i := len(tag.Entries) - 1; i >= 0; i-- {
+		entry := tag.Entries[i]
+		commit, err := commits.Read(ctx, in.Repo, entry.Commit)
+		if err != nil {
+			return nil, fmt.Errorf("chunky: unable to read commit %q of tag %q: %w", entry.Commit, in.Tag, err)
+		}
+		entries = append(entries, &TagEntry{
+			Commit:   commit,
+			User:     entry.User,
+			MovedAt:  entry.MovedAt,
+			Forced:   entry.Forced,
+			Rollback: entry.Rollback,
+		})
+	}
+	return entries, nil
+}
+
+type RollbackTag struct {
+	Repo repos.Repo
+	Tag  string
+	// User rolling back the tag (default: current user)
+	User string
+	// Force rolls back the tag even if it's protected. The override is recorded
+	// in the tag's history.
+	Force bool
+	// SigningKey signs the tag (optional)
+	SigningKey ed25519.PrivateKey
+}
+
+func (in *RollbackTag) validate() (err error) {
+	if in.Repo == nil {
+		err = errors.Join(err, errors.New("missing 'repo'"))
+	}
+	if in.Tag == "" {
+		err = errors.Join(err, errors.New("missing 'tag'"))
+	}
+	if err2 := defaultUser(&in.User); err2 != nil {
+		err = errors.Join(err, err2)
+	}
+	return err
+}
+
+// RollbackTag moves a tag back to the commit it previously pointed to and
+// returns that commit. Rolling back again steps further back. The rollback is appended to the tag's history along with
+// who rolled it back and when.
+func (c *Client) RollbackTag(ctx context.Context, in *RollbackTag) (*Commit, error) {
+	if err := in.validate(); err != nil {
+		return nil, err
+	}
+	tag, err := tags.Read(ctx, in.Repo, in.Tag)
+	if err != nil {
+		return nil, fmt.Errorf("chunky: unable to read tag %q: %w", in.Tag, err)
+	}
+	policy, err := tags.ReadPolicy(ctx, in.Repo)
+	if err != nil {
+		return nil, err
+	}
+	forced, err := c.checkPolicy(policy, tag, tags.OpRollback, in.User, in.Force)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := tag.Rollback(in.User, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	entry.Forced = forced
+	commit, err := commits.Read(ctx, in.Repo, entry.Commit)
+	if err != nil {
+		return nil, fmt.Errorf("chunky: unable to read commit %q: %w", entry.Commit, err)
+	}
+	if err := uploadTag(ctx, in.Repo, tag, in.SigningKey); err != nil {
+		return nil, err
+	}
+	return commit, nil
+}
+
+// Upload a tag file to the repository, signing it if there's a key. Without a
+// key, the tag's old signature is removed since it no longer matches.
+func uploadTag(ctx context.Context, repo repos.Repo, tag *tags.Tag, key ed25519.PrivateKey) error {
+	files, err := signFiles(key, tag.File())
+	if err != nil {
+		return err
+	}
+	fromCh := make(chan *repos.File, len(files))
+	for _, file := range files {
+		fromCh <- file
+	}
+	close(fromCh)
+	if err := repo.Upload(ctx, fromCh); err != nil {
+		return err
+	}
+	if key == nil {
+		return removeTagSignature(ctx, repo, tag.Name)
+	}
+	return nil
+}
+
+// Remove a tag's signature, if it was signed. Repositories that can't remove
+// paths keep the old signature, which no longer verifies.
+func removeTagSignature(ctx context.Context, repo repos.Repo, name string) error {
+	if err := repos.Remove(ctx, repo, signatures.Path(path.Join("tags", name))); err != nil && !errors.Is(err, fs.ErrNotExist) && !errors.Is(err, errors.ErrUnsupported) {
+		return fmt.Errorf("chunky: unable to remove signature for %q: %w", name, err)
+	}
+	return nil
+}
+
+// TagRule protects the tags matching a pattern
+type TagRule = tags.Rule
+
+type ProtectTags struct {
+	Repo repos.Repo
+	Rule *TagRule
+}
+
+func (in *ProtectTags) validate() (err error) {
+	if in.Repo == nil {
+		err = errors.Join(err, errors.New("missing 'repo'"))
+	}
+	if in.Rule == nil {
+		err = errors.Join(err, errors.New("missing 'rule'"))
+	} else if in.Rule.Pattern == "" {
+		err = errors.Join(err, errors.New("missing 'rule.pattern'"))
+	}
+	return err
+}
+
+// ProtectTags adds a rule to the repository's tag policy, replacing any rule
+// with the same pattern
+func (c *Client) ProtectTags(ctx context.Context, in *ProtectTags) error {
+	if err := in.validate(); err != nil {
+		return err
+	}
+	policy, err := tags.ReadPolicy(ctx, in.Repo)
+	if err != nil {
+		return err
+	}
+	if err := policy.Set(in.Rule); err != nil {
+		return err
+	}
+	return uploadPolicy(ctx, in.Repo, policy)
+}
+
+type UnprotectTags struct {
+	Repo    repos.Repo
+	Pattern string
+}
+
+func (in *UnprotectTags) validate() (err error) {
+	if in.Repo == nil {
+		err = errors.Join(err, errors.New("missing 'repo'"))
+	}
+	if in.Pattern == "" {
+		err = errors.Join(err, errors.New("missing 'pattern'"))
+	}
+	return err
+}
+
+// UnprotectTags removes a rule from the repository's tag policy
+func (c *Client) UnprotectTags(ctx context.Context, in *UnprotectTags) error {
+	if err := in.validate(); err != nil {
+		return err
+	}
+	policy, err := tags.ReadPolicy(ctx, in.Repo)
+	if err != nil {
+		return err
+	}
+	if !policy.Remove(in.Pattern) {
+		return fmt.Errorf("chunky: no rule for %q: %w", in.Pattern, fs.ErrNotExist)
+	}
+	return uploadPolicy(ctx, in.Repo, policy)
+}
+
+type ListTagRules struct {
+	Repo repos.Repo
+}
+
+func (in *ListTagRules) validate() (err error) {
+	if in.Repo == nil {
+		err = errors.Join(err, errors.New("missing 'repo'"))
+	}
+	return err
+}
+
+// ListTagRules lists the rules in the repository's tag policy
+func (c *Client) ListTagRules(ctx context.Context, in *ListTagRules) ([]*TagRule, error) {
+	if err := in.validate(); err != nil {
+		return nil, err
+	}
+	policy, err := tags.ReadPolicy(ctx, in.Repo)
+	if err != nil {
+		return nil, err
+	}
+	return policy.Rules, nil
+}
+
+// Upload the tag policy to the repository
+func uploadPolicy(ctx context.Context, repo repos.Repo, policy *tags.Policy) error {
+	file, err := policy.File()
+	if err != nil {
+		return err
+	}
+	fromCh := make(chan *repos.File, 1)
+	fromCh <- file
+	close(fromCh)
+	return repo.Upload(ctx, fromCh)
+}
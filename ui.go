@@ -0,0 +1,98 @@
+package chunky
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/dustin/go-humanize"
+	"github.com/matthewmueller/chunky/internal/lru"
+	"github.com/matthewmueller/chunky/internal/packs"
+	"github.com/matthewmueller/chunky/internal/rate"
+	"github.com/matthewmueller/chunky/internal/webui"
+	"github.com/matthewmueller/chunky/repos"
+)
+
+type UI struct {
+	Repo repos.Repo
+
+	// MaxCacheSize is the maximum size of the LRU for caching packs (default: 512MiB)
+	MaxCacheSize string
+	maxCacheSize int
+
+	// CacheDir keeps downloaded packs on disk, so later runs don't download them
+	// again. Packs are cached by their ID, so each repository needs its own
+	// directory (optional)
+	CacheDir repos.FS
+
+	// MaxCacheDirSize is the maximum size of the packs in CacheDir (default: 2GiB)
+	MaxCacheDirSize string
+	maxCacheDirSize int
+
+	// LimitDownload is the maximum download speed per second (default: unlimited)
+	LimitDownload string
+	limitDownload int
+}
+
+func (in *UI) validate() (err error) {
+	// Required fields
+	if in.Repo == nil {
+		err = errors.Join(err, errors.New("missing 'repo'"))
+	}
+
+	if in.MaxCacheSize != "" {
+		maxCacheSize, err2 := humanize.ParseBytes(in.MaxCacheSize)
+		if err2 != nil {
+			err = errors.Join(err, errors.New("invalid max cache size"))
+		} else {
+			in.maxCacheSize = int(maxCacheSize)
+		}
+	} else {
+		in.maxCacheSize = 512 * miB
+	}
+
+	if in.MaxCacheDirSize != "" {
+		maxCacheDirSize, err2 := humanize.ParseBytes(in.MaxCacheDirSize)
+		if err2 != nil {
+			err = errors.Join(err, errors.New("invalid max cache dir size"))
+		} else {
+			in.maxCacheDirSize = int(maxCacheDirSize)
+		}
+	} else {
+		in.maxCacheDirSize = DefaultMaxCacheDirSize
+	}
+
+	if in.LimitDownload != "" {
+		limitDownload, err2 := humanize.ParseBytes(in.LimitDownload)
+		if err2 != nil {
+			err = errors.Join(err, errors.New("invalid limit download"))
+		} else {
+			in.limitDownload = int(limitDownload)
+		}
+	} else {
+		in.limitDownload = 0
+	}
+
+	return err
+}
+
+// UI returns a read-only web interface for browsing a repository. It lists the
+// commits and tags, browses the files in any revision and shows the diffs
+// between revisions. Packs are shared through one cache across requests.
+func (c *Client) UI(in *UI) (http.Handler, error) {
+	if err := in.validate(); err != nil {
+		return nil, err
+	}
+
+	// Create a cached pack reader with the specified max cache size
+	pr := packs.NewCachedReader(c.log, lru.New[*packs.Pack](c.log, in.maxCacheSize))
+	if in.CacheDir != nil {
+		pr.Disk = packs.NewDiskCache(c.log, in.CacheDir, in.maxCacheDirSize)
+	}
+
+	// Set the download limit if provided
+	if in.LimitDownload != "" {
+		pr.Limiter = rate.New(in.limitDownload)
+	}
+
+	return webui.New(c.log, in.Repo, pr), nil
+}
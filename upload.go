@@ -1,7 +1,9 @@
 package chunky
 
 import (
+	"bytes"
 	"context"
+	"crypto/ed25519"
 	"errors"
 	"fmt"
 	"io"
@@ -16,8 +18,13 @@ import (
 	"github.com/matthewmueller/chunky/internal/caches"
 	"github.com/matthewmueller/chunky/internal/chunkyignore"
 	"github.com/matthewmueller/chunky/internal/commits"
+	"github.com/matthewmueller/chunky/internal/githead"
+	"github.com/matthewmueller/chunky/internal/indexes"
+	"github.com/matthewmueller/chunky/internal/owners"
+	"github.com/matthewmueller/chunky/internal/packs"
 	"github.com/matthewmueller/chunky/internal/rate"
 	"github.com/matthewmueller/chunky/internal/sha256"
+	"github.com/matthewmueller/chunky/internal/tags"
 	"github.com/matthewmueller/chunky/internal/uploads"
 	"github.com/matthewmueller/chunky/repos"
 	"github.com/matthewmueller/logs"
@@ -25,6 +32,38 @@ import (
 	"golang.org/x/sync/errgroup"
 )
 
+// SpecialFiles is the policy for FIFOs, sockets, devices and other files that
+// aren't regular files, directories or symlinks
+type SpecialFiles string
+
+const (
+	// SkipSpecialFiles skips special files with a warning
+	SkipSpecialFiles SpecialFiles = "skip"
+	// FailSpecialFiles fails the upload when there's a special file
+	FailSpecialFiles SpecialFiles = "fail"
+	// RecordSpecialFiles records the metadata of special files, including the
+	// device numbers of devices, so they can be recreated on download
+	RecordSpecialFiles SpecialFiles = "record"
+)
+
+// ModifiedFiles is the policy for files that change while they're being
+// uploaded, such as logs that are being appended to
+type ModifiedFiles string
+
+const (
+	// RetryModifiedFiles reads modified files again, failing the upload if they
+	// keep changing
+	RetryModifiedFiles ModifiedFiles = "retry"
+	// SkipModifiedFiles skips modified files with a warning
+	SkipModifiedFiles ModifiedFiles = "skip"
+	// FailModifiedFiles fails the upload when a file is modified
+	FailModifiedFiles ModifiedFiles = "fail"
+)
+
+// maxModifiedRetries is the number of times a modified file is read before
+// giving up
+const maxModifiedRetries = 3
+
 type Upload struct {
 	From   repos.ReadFS
 	To     repos.Repo
@@ -34,6 +73,44 @@ type Upload struct {
 	Paths  []string
 	Ignore func(string) bool
 
+	// Parent is the revision this upload builds on (default: latest)
+	Parent string
+
+	// Message describes the upload
+	Message string
+
+	// Meta is arbitrary key/value metadata stored in the commit. The source's
+	// git commit and branch are captured by default under "git.commit" and
+	// "git.branch" when the source is a git repository.
+	Meta map[string]string
+
+	// Force moves protected tags. The override is recorded in the tag's history.
+	Force bool
+
+	// SigningKey signs the commit and tags (optional)
+	SigningKey ed25519.PrivateKey
+
+	// PreserveOwner captures the uid and gid of each file along with the user and
+	// group names
+	PreserveOwner bool
+
+	// NumericOwner only captures the uid and gid, not the user and group names
+	NumericOwner bool
+
+	// PreserveXattrs captures the extended attributes of each file
+	PreserveXattrs bool
+
+	// SpecialFiles is the policy for FIFOs, sockets and devices (default: skip)
+	SpecialFiles SpecialFiles
+
+	// ModifiedFiles is the policy for files that change while they're being
+	// uploaded (default: retry)
+	ModifiedFiles ModifiedFiles
+
+	// ForceRehash reads and hashes every file, even files whose size,
+	// modification time and inode haven't changed since the last upload
+	ForceRehash bool
+
 	// MaxPackSize is the maximum pack size (default: 32MiB)
 	MaxPackSize string
 	maxPackSize int
@@ -82,9 +159,36 @@ func (in *Upload) validate() (err error) {
 			err = errors.Join(err, errors.New("tag cannot be 'latest'"))
 		} else if tag == "" {
 			err = errors.Join(err, errors.New("tag cannot be empty"))
+		} else if err2 := tags.ValidateName(tag); err2 != nil {
+			err = errors.Join(err, err2)
 		}
 	}
 
+	// Validate the metadata
+	for key := range in.Meta {
+		if key == "" {
+			err = errors.Join(err, errors.New("meta key cannot be empty"))
+		}
+	}
+
+	// Skip special files by default
+	switch in.SpecialFiles {
+	case "":
+		in.SpecialFiles = SkipSpecialFiles
+	case SkipSpecialFiles, FailSpecialFiles, RecordSpecialFiles:
+	default:
+		err = errors.Join(err, fmt.Errorf("invalid special files policy %q", in.SpecialFiles))
+	}
+
+	// Retry modified files by default
+	switch in.ModifiedFiles {
+	case "":
+		in.ModifiedFiles = RetryModifiedFiles
+	case RetryModifiedFiles, SkipModifiedFiles, FailModifiedFiles:
+	default:
+		err = errors.Join(err, fmt.Errorf("invalid modified files policy %q", in.ModifiedFiles))
+	}
+
 	// Default to the current directory
 	if len(in.Paths) == 0 {
 		in.Paths = []string{"."}
@@ -183,9 +287,73 @@ func (c *Client) Upload(ctx context.Context, in *Upload) error {
 
 	ignore := in.Ignore
 	createdAt := time.Now().UTC()
+
+	// Load the hashes of the files from the last upload of this source
+	root, err := in.From.Lstat(".")
+	if err != nil {
+		return err
+	}
+	index, err := indexes.Load(in.Cache, root, createdAt)
+	if err != nil {
+		return err
+	}
 	commit := commits.New(in.User, createdAt)
 	commitId := commit.ID()
 
+	// Link the commit to its parent
+	parent, err := findParent(ctx, in.To, in.Parent)
+	if err != nil {
+		return err
+	}
+	// Commit IDs have second precision, so uploads within the same second
+	// replace the previous commit. In that case, inherit its parent instead.
+	if parent != nil && parent.ID() == commitId {
+		if parent.Parent() == "" {
+			parent = nil
+		} else if parent, err = findParent(ctx, in.To, parent.Parent()); err != nil {
+			return err
+		}
+	}
+	if parent != nil {
+		commit.SetParent(parent)
+	}
+
+	// Attach the message and metadata
+	commit.SetMessage(in.Message)
+	for key, value := range gitMeta(log, in.From) {
+		commit.SetMeta(key, value)
+	}
+	for key, value := range in.Meta {
+		commit.SetMeta(key, value)
+	}
+
+	// Move the latest tag and any other tags to the commit, keeping their
+	// history. This happens upfront so protected tags fail the upload before any
+	// data is uploaded.
+	policy, err := tags.ReadPolicy(ctx, in.To)
+	if err != nil {
+		return err
+	}
+	var tagFiles []*repos.File
+	tagNames := append([]string{"latest"}, in.Tags...)
+	for _, name := range tagNames {
+		tag, err := c.moveTag(ctx, in.To, policy, &moveTag{
+			Name:     name,
+			CommitID: commitId,
+			User:     in.User,
+			MovedAt:  createdAt,
+			Force:    in.Force,
+		})
+		if err != nil {
+			return err
+		}
+		files, err := signFiles(in.SigningKey, tag.File())
+		if err != nil {
+			return err
+		}
+		tagFiles = append(tagFiles, files...)
+	}
+
 	uploadCh := make(chan *repos.File, in.concurrency)
 	// Start the upload workers
 	eg := new(errgroup.Group)
@@ -201,82 +369,171 @@ func (c *Client) Upload(ctx context.Context, in *Upload) error {
 	upload.MaxChunkSize = in.maxChunkSize
 	upload.Limiter = rate.New(in.limitUpload)
 
+	packer := &packer{
+		log:    log,
+		fsys:   in.From,
+		upload: upload,
+		cache:  cache,
+		index:  index,
+		attrs: &attrReader{
+			fsys:    in.From,
+			owners:  owners.New(),
+			owner:   in.PreserveOwner,
+			numeric: in.NumericOwner,
+			xattrs:  in.PreserveXattrs,
+		},
+		maxChunkSize:  in.maxChunkSize,
+		modifiedFiles: in.ModifiedFiles,
+		forceRehash:   in.ForceRehash,
+	}
+
+	// Files are hashed, chunked and packed concurrently while walking. Each file
+	// gets an entry in walk order, so the commit's files are in the same order
+	// no matter which file finishes first.
+	var entries []*entry
+	// Track hard linked files by their inode
+	links := map[repos.FileID]*entry{}
+	process, processCtx := errgroup.WithContext(ctx)
+	process.SetLimit(in.concurrency)
+
+	// Create the entry of a file that isn't a directory. Returns a nil entry if
+	// the file is skipped. Regular files and symlinks are packed by the caller.
+	fileEntry := func(fpath string, lstat fs.FileInfo) (e *entry, pack bool, err error) {
+		// Special files can't be read like regular files
+		if repos.IsSpecial(lstat.Mode()) {
+			switch in.SpecialFiles {
+			case RecordSpecialFiles:
+				file, err := packer.Special(processCtx, fpath, lstat)
+				if err != nil {
+					return nil, false, err
+				}
+				return &entry{file: file}, false, nil
+			case FailSpecialFiles:
+				return nil, false, fmt.Errorf("unable to upload special file %q with mode %s", fpath, lstat.Mode())
+			default:
+				log.Warn("skipping special file", slog.String("path", fpath), slog.String("mode", lstat.Mode().String()))
+				return nil, false, nil
+			}
+		}
+
+		// Hard links to a file we've already added share its data
+		linkId, isLink := repos.HardLink(lstat)
+		if isLink {
+			if target, ok := links[linkId]; ok {
+				log.Debug("adding hard link", slog.String("path", fpath), slog.String("link", target.path))
+				return &entry{path: fpath, link: target}, false, nil
+			}
+		}
+
+		e = &entry{path: fpath}
+		if isLink {
+			links[linkId] = e
+		}
+		return e, true, nil
+	}
+
+	// Streamed filesystems, like compressed tarballs, can only be read in order,
+	// so their files are packed as they're streamed. They're added to the commit
+	// once the whole tree is known, in the same order as walked files.
+	packer.stream = true
+	streamed := map[string]*entry{}
+	err = repos.Stream(in.From, func(fpath string, lstat fs.FileInfo) error {
+		if lstat.IsDir() || !inPaths(in.Paths, fpath) {
+			return nil
+		} else if isIgnored(ignore, fpath) {
+			log.Debug("ignoring file", slog.String("path", fpath))
+			return nil
+		}
+		// Later files replace earlier files with the same path
+		delete(streamed, fpath)
+		e, pack, err := fileEntry(fpath, lstat)
+		if err != nil || e == nil {
+			return err
+		}
+		if pack {
+			if e.file, err = packer.File(processCtx, fpath, lstat); err != nil {
+				return err
+			}
+		}
+		streamed[fpath] = e
+		return nil
+	})
+	switch {
+	case errors.Is(err, errors.ErrUnsupported):
+		// Walk the files instead
+		packer.stream, streamed = false, nil
+	case err != nil:
+		close(uploadCh)
+		return err
+	}
+
 	// Walk over the files, chunk them and add them to the file system we're going
 	// to upload. We'll also add each file to the commit object.
+	var walkErr error
 	for _, p := range in.Paths {
-		if err := fs.WalkDir(in.From, p, func(fpath string, d fs.DirEntry, err error) error {
+		if walkErr = fs.WalkDir(in.From, p, func(fpath string, d fs.DirEntry, err error) error {
 			if err != nil {
 				return err
+			} else if err := processCtx.Err(); err != nil {
+				// Stop walking when a file failed to process
+				return err
 			} else if d.IsDir() {
 				if ignore(fpath) {
 					log.Debug("ignoring directory", slog.String("path", fpath))
 					return fs.SkipDir
 				}
+				file, err := packer.Dir(processCtx, fpath)
+				if err != nil {
+					return err
+				}
+				entries = append(entries, &entry{file: file})
+				return nil
+			} else if streamed != nil {
+				// Streamed files were already packed
+				if e, ok := streamed[fpath]; ok {
+					entries = append(entries, e)
+				}
 				return nil
 			} else if ignore(fpath) {
 				log.Debug("ignoring file", slog.String("path", fpath))
 				return nil
 			}
 
-			// Hash the file into a sha256 hash, this reads the file in chunks, rather
-			// than loading the entire file into memory.
-			fileHash, err := sha256.HashFile(in.From, fpath, in.maxChunkSize)
-			if err != nil {
-				return fmt.Errorf("unable to hash file %q: %w", fpath, err)
-			}
-
-			// Check if the file is already in the pack. This will duplicate content
-			// if the file path in the pack is different from the file path in the
-			// commit. To fix this, we also ensure the file paths are the same.
-			// TODO: We should add a way to alias files in the pack to other packs.
-			if cacheFile, ok := cache.Get(fpath, fileHash); ok {
-				log.Debug("file already in cache", slog.String("path", fpath))
-				commit.Add(cacheFile)
-				return nil
-			}
-
 			// Get the file lstat
 			lstat, err := in.From.Lstat(fpath)
 			if err != nil {
 				return err
 			}
 
-			// Create a reader for the file data, handling symlinks
-			reader, err := openReader(in.From, fpath, lstat)
-			if err != nil {
+			e, pack, err := fileEntry(fpath, lstat)
+			if err != nil || e == nil {
 				return err
 			}
-
-			// Add the file to the pack
-			packId, err := upload.Add(ctx, &uploads.File{
-				Reader:  reader,
-				Path:    fpath,
-				Hash:    fileHash,
-				Mode:    lstat.Mode(),
-				Size:    lstat.Size(),
-				ModTime: lstat.ModTime(),
-			})
-			if err != nil {
-				return err
+			entries = append(entries, e)
+			if pack {
+				process.Go(func() (err error) {
+					e.file, err = packer.File(processCtx, fpath, lstat)
+					return err
+				})
 			}
-
-			log.Debug("added file to pack",
-				slog.String("path", fpath),
-				slog.String("pack_id", packId),
-			)
-
-			// Add the file to the commit
-			commit.Add(&commits.File{
-				Path:   fpath,
-				Id:     fileHash,
-				PackId: packId,
-				Size:   uint64(lstat.Size()),
-			})
-
 			return nil
-		}); err != nil {
-			close(uploadCh)
-			return err
+		}); walkErr != nil {
+			break
+		}
+	}
+
+	// Wait for the files to be packed. Errors from packing take precedence since
+	// they cancel the walk.
+	if err := process.Wait(); err != nil {
+		close(uploadCh)
+		return err
+	} else if walkErr != nil {
+		close(uploadCh)
+		return walkErr
+	}
+	for _, e := range entries {
+		if file := e.File(log); file != nil {
+			commit.Add(file)
 		}
 	}
 
@@ -292,11 +549,18 @@ func (c *Client) Upload(ctx context.Context, in *Upload) error {
 		close(uploadCh)
 		return err
 	}
-	uploadCh <- &repos.File{
+	commitFiles, err := signFiles(in.SigningKey, &repos.File{
 		Path:    path.Join("commits", commitId),
 		Data:    commitData,
 		Mode:    0644,
 		ModTime: createdAt,
+	})
+	if err != nil {
+		close(uploadCh)
+		return err
+	}
+	for _, commitFile := range commitFiles {
+		uploadCh <- commitFile
 	}
 
 	// Add the commit to the cache
@@ -305,24 +569,431 @@ func (c *Client) Upload(ctx context.Context, in *Upload) error {
 		return err
 	}
 
-	// Add the latest ref
-	uploadCh <- &repos.File{
-		Path: path.Join("tags", "latest"),
-		Data: []byte(commitId),
-		Mode: 0644,
+	// Save the file hashes for the next upload
+	if err := index.Save(in.Cache); err != nil {
+		close(uploadCh)
+		return err
 	}
 
-	// Tag the revision
-	for _, tag := range in.Tags {
-		uploadCh <- &repos.File{
-			Path: path.Join("tags", tag),
-			Data: []byte(commitId),
-			Mode: 0644,
-		}
+	// Move the tags to the commit
+	for _, tagFile := range tagFiles {
+		uploadCh <- tagFile
 	}
 
 	close(uploadCh)
-	return eg.Wait()
+	if err := eg.Wait(); err != nil {
+		return err
+	}
+
+	// Unsigned moves remove the tags' old signatures, which no longer match
+	if in.SigningKey == nil {
+		for _, name := range tagNames {
+			if err := removeTagSignature(ctx, in.To, name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// entry is a file in the commit. Files are packed concurrently, so the file
+// is filled in once it's been packed.
+type entry struct {
+	path string
+	file *commits.File
+	// link is the entry this file is hard linked to
+	link *entry
+}
+
+// File returns the file to add to the commit or nil if the file was skipped
+func (e *entry) File(log *slog.Logger) *commits.File {
+	if e.link == nil {
+		return e.file
+	}
+	target := e.link.file
+	if target == nil {
+		log.Warn("skipping hard link to skipped file", slog.String("path", e.path), slog.String("link", e.link.path))
+		return nil
+	}
+	return &commits.File{
+		Path:    e.path,
+		Id:      target.Id,
+		PackId:  target.PackId,
+		Size:    target.Size,
+		Mode:    target.Mode,
+		Attrs:   target.Attrs,
+		Link:    target.Path,
+		ModTime: target.ModTime,
+	}
+}
+
+// packer adds files to packs, returning the files to add to the commit. It's
+// safe to use concurrently.
+type packer struct {
+	log           *slog.Logger
+	fsys          repos.ReadFS
+	upload        *uploads.Upload
+	cache         *caches.Local
+	index         *indexes.Index
+	attrs         *attrReader
+	maxChunkSize  int
+	modifiedFiles ModifiedFiles
+	forceRehash   bool
+	// stream is true if files can only be read once, as they're streamed
+	stream bool
+}
+
+// Dir adds a directory, so empty directories and directory modes are restored
+// on download. The root of the upload is skipped.
+func (p *packer) Dir(ctx context.Context, dir string) (*commits.File, error) {
+	if dir == "." {
+		return nil, nil
+	}
+	lstat, err := p.fsys.Lstat(dir)
+	if err != nil {
+		return nil, err
+	}
+	mode := lstat.Mode()
+	dirHash := sha256.HashEmpty(mode, lstat.ModTime())
+	owner, xattrs, err := p.attrs.Read(dir, lstat)
+	if err != nil {
+		return nil, err
+	}
+	attrsHash := sha256.HashAttrs(owner, xattrs)
+
+	// Check if the directory is already in a pack
+	if cacheFile, ok := p.cache.Get(dir, dirHash, attrsHash); ok {
+		p.log.Debug("directory already in cache", slog.String("path", dir))
+		return withModTime(cacheFile, lstat), nil
+	}
+
+	packId, err := p.upload.Add(ctx, &uploads.File{
+		Path:    dir,
+		Hash:    dirHash,
+		Mode:    mode,
+		ModTime: lstat.ModTime(),
+		Owner:   owner,
+		Xattrs:  xattrs,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	p.log.Debug("added directory to pack",
+		slog.String("path", dir),
+		slog.String("pack_id", packId),
+	)
+
+	return &commits.File{
+		Path:    dir,
+		Id:      dirHash,
+		PackId:  packId,
+		Mode:    mode,
+		Attrs:   attrsHash,
+		ModTime: lstat.ModTime().Unix(),
+	}, nil
+}
+
+// Special adds a FIFO, socket or device. Only the metadata is stored since
+// special files don't have any data to read.
+func (p *packer) Special(ctx context.Context, fpath string, lstat fs.FileInfo) (*commits.File, error) {
+	mode := lstat.Mode()
+	fileHash := sha256.HashEmpty(mode, lstat.ModTime())
+	major, minor, _ := repos.DeviceNumber(lstat)
+	owner, xattrs, err := p.attrs.Read(fpath, lstat)
+	if err != nil {
+		return nil, err
+	}
+
+	packId, err := p.upload.Add(ctx, &uploads.File{
+		Path:    fpath,
+		Hash:    fileHash,
+		Mode:    mode,
+		ModTime: lstat.ModTime(),
+		Major:   major,
+		Minor:   minor,
+		Owner:   owner,
+		Xattrs:  xattrs,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	p.log.Debug("added special file to pack",
+		slog.String("path", fpath),
+		slog.String("pack_id", packId),
+	)
+
+	return &commits.File{
+		Path:    fpath,
+		Id:      fileHash,
+		PackId:  packId,
+		Mode:    mode,
+		Attrs:   sha256.HashAttrs(owner, xattrs),
+		ModTime: lstat.ModTime().Unix(),
+	}, nil
+}
+
+// File adds a regular file or symlink, handling files that change while
+// they're being read. Returns a nil file if the file was skipped.
+func (p *packer) File(ctx context.Context, fpath string, lstat fs.FileInfo) (*commits.File, error) {
+	for attempt := 1; ; attempt++ {
+		file, err := p.addFile(ctx, fpath, lstat)
+		if err == nil {
+			return file, nil
+		} else if !errors.Is(err, uploads.ErrModified) {
+			return nil, err
+		}
+		switch {
+		case p.modifiedFiles == SkipModifiedFiles:
+			p.log.Warn("skipping file modified during upload", slog.String("path", fpath))
+			return nil, nil
+		case p.modifiedFiles == RetryModifiedFiles && attempt < maxModifiedRetries:
+			p.log.Debug("retrying file modified during upload", slog.String("path", fpath), slog.Int("attempt", attempt))
+			if lstat, err = p.fsys.Lstat(fpath); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("unable to upload %q: %w", fpath, err)
+		}
+	}
+}
+
+// Add a file to the pack. Returns uploads.ErrModified if the file changed
+// while it was read.
+func (p *packer) addFile(ctx context.Context, fpath string, lstat fs.FileInfo) (*commits.File, error) {
+	// Capture the ownership and extended attributes if requested
+	owner, xattrs, err := p.attrs.Read(fpath, lstat)
+	if err != nil {
+		return nil, err
+	}
+	attrsHash := sha256.HashAttrs(owner, xattrs)
+
+	// Files that haven't changed since the last upload don't need to be read
+	if !p.forceRehash {
+		if fileHash, ok := p.index.Get(fpath, lstat); ok {
+			if cacheFile, ok := p.cache.Get(fpath, fileHash, attrsHash); ok && cacheFile.Link == "" {
+				p.log.Debug("file unchanged since last upload", slog.String("path", fpath))
+				p.index.Set(fpath, lstat, fileHash)
+				return withModTime(cacheFile, lstat), nil
+			}
+		}
+	}
+
+	// Create a reader for the file data, handling symlinks
+	reader, err := openReader(p.fsys, fpath, lstat)
+	if err != nil {
+		return nil, err
+	}
+	if closer, ok := reader.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	file := &uploads.File{
+		Reader:  reader,
+		Path:    fpath,
+		Mode:    lstat.Mode(),
+		Size:    lstat.Size(),
+		ModTime: lstat.ModTime(),
+		Owner:   owner,
+		Xattrs:  xattrs,
+	}
+
+	// Small files are read into memory and hashed. Larger files that may have
+	// been uploaded before are hashed first, so their blobs aren't added again.
+	// Streamed files can't be read twice, so their blobs are held back instead.
+	// Otherwise they're hashed while they're chunked, so their data is only
+	// read once.
+	var fileChunk *packs.Chunk
+	var pending *uploads.Pending
+	switch {
+	case file.Size < int64(p.maxChunkSize):
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read file %q: %w", fpath, err)
+		}
+		file.Hash = sha256.HashBytes(lstat.Mode(), data)
+		file.Size = int64(len(data))
+		file.Reader = bytes.NewReader(data)
+	case p.cache.Has(fpath) && p.stream:
+		if pending, err = p.upload.ChunkPending(ctx, file); err != nil {
+			return nil, err
+		}
+		file.Hash = pending.Chunk.Hash
+	case p.cache.Has(fpath):
+		if file.Hash, err = sha256.HashFile(p.fsys, fpath, p.maxChunkSize); err != nil {
+			return nil, fmt.Errorf("unable to hash file %q: %w", fpath, err)
+		}
+	default:
+		if fileChunk, err = p.upload.Chunk(ctx, file); err != nil {
+			return nil, err
+		}
+		file.Hash = fileChunk.Hash
+	}
+
+	// Without a second read, changes while the file was read are noticed by
+	// its metadata changing
+	if err := p.checkModified(fpath, lstat); err != nil {
+		return nil, err
+	}
+
+	// Check if the file is already in the pack. This will duplicate content
+	// if the file path in the pack is different from the file path in the
+	// commit. To fix this, we also ensure the file paths are the same.
+	// TODO: We should add a way to alias files in the pack to other packs.
+	fileHash := file.Hash
+	if cacheFile, ok := p.cache.Get(fpath, fileHash, attrsHash); ok && cacheFile.Link == "" {
+		p.log.Debug("file already in cache", slog.String("path", fpath))
+		p.index.Set(fpath, lstat, fileHash)
+		return withModTime(cacheFile, lstat), nil
+	}
+
+	// Add the file to the pack
+	var packId string
+	switch {
+	case pending != nil:
+		packId, err = p.upload.AddPending(ctx, pending)
+	case fileChunk != nil:
+		packId, err = p.upload.AddChunk(ctx, fileChunk)
+	default:
+		packId, err = p.upload.Add(ctx, file)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	p.log.Debug("added file to pack",
+		slog.String("path", fpath),
+		slog.String("pack_id", packId),
+	)
+	p.index.Set(fpath, lstat, fileHash)
+
+	return &commits.File{
+		Path:    fpath,
+		Id:      fileHash,
+		PackId:  packId,
+		Size:    uint64(file.Size),
+		Mode:    lstat.Mode(),
+		Attrs:   attrsHash,
+		ModTime: lstat.ModTime().Unix(),
+	}, nil
+}
+
+// withModTime returns a copy of a cached file with the current modification
+// time, since the cached file may have been uploaded before it was touched
+func withModTime(cacheFile *commits.File, lstat fs.FileInfo) *commits.File {
+	file := *cacheFile
+	file.ModTime = lstat.ModTime().Unix()
+	return &file
+}
+
+// checkModified returns uploads.ErrModified if a regular file's metadata
+// changed since it was stat'd before reading it
+func (p *packer) checkModified(fpath string, before fs.FileInfo) error {
+	if !before.Mode().IsRegular() {
+		return nil
+	}
+	after, err := p.fsys.Lstat(fpath)
+	if err != nil {
+		return err
+	}
+	inodeBefore, _ := repos.FileInode(before)
+	inodeAfter, _ := repos.FileInode(after)
+	if after.Mode() != before.Mode() || after.Size() != before.Size() ||
+		!after.ModTime().Equal(before.ModTime()) || inodeAfter != inodeBefore {
+		return fmt.Errorf("%w: %q", uploads.ErrModified, fpath)
+	}
+	return nil
+}
+
+// attrReader captures the ownership and extended attributes of files when
+// they've been requested
+type attrReader struct {
+	fsys    repos.ReadFS
+	owners  *owners.Lookup
+	owner   bool
+	numeric bool
+	xattrs  bool
+}
+
+func (a *attrReader) Read(fpath string, info fs.FileInfo) (owner *packs.Owner, xattrs map[string][]byte, err error) {
+	if a.owner {
+		if uid, gid, ok := repos.FileOwner(info); ok {
+			if a.numeric {
+				owner = &packs.Owner{Uid: uid, Gid: gid}
+			} else {
+				owner = a.owners.Owner(uid, gid)
+			}
+		}
+	}
+	if a.xattrs {
+		xattrs, err = repos.Lxattrs(a.fsys, fpath)
+		if err != nil && !errors.Is(err, errors.ErrUnsupported) {
+			return nil, nil, fmt.Errorf("unable to read extended attributes of %q: %w", fpath, err)
+		}
+	}
+	return owner, xattrs, nil
+}
+
+// inPaths returns true if a file is within one of the paths being uploaded
+func inPaths(paths []string, fpath string) bool {
+	for _, p := range paths {
+		p = path.Clean(p)
+		if p == "." || p == fpath || strings.HasPrefix(fpath, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// isIgnored returns true if a streamed file or any of the directories it's in
+// are ignored, like they are when walking
+func isIgnored(ignore func(string) bool, fpath string) bool {
+	for dir := fpath; ; dir = path.Dir(dir) {
+		if ignore(dir) {
+			return true
+		} else if dir == "." {
+			return false
+		}
+	}
+}
+
+// Find the parent commit. When no revision is provided, the latest commit is
+// used if there is one.
+func findParent(ctx context.Context, repo repos.Repo, revision string) (*commits.Commit, error) {
+	if revision != "" {
+		parent, err := commits.Read(ctx, repo, revision)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read parent %q: %w", revision, err)
+		}
+		return parent, nil
+	}
+	parent, err := commits.Read(ctx, repo, "latest")
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("unable to read latest commit: %w", err)
+	}
+	return parent, nil
+}
+
+// Capture the git commit and branch of the source tree, if there is one
+func gitMeta(log *slog.Logger, fsys fs.FS) map[string]string {
+	head, err := githead.Read(fsys)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			log.Debug("unable to read git head", slog.String("error", err.Error()))
+		}
+		return nil
+	}
+	meta := map[string]string{
+		"git.commit": head.Commit,
+	}
+	if head.Branch != "" {
+		meta["git.branch"] = head.Branch
+	}
+	return meta
 }
 
 // Open a file from the filesystem, handling symlinks. For symlinks, the
@@ -0,0 +1,124 @@
+package chunky
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+
+	"github.com/matthewmueller/chunky/internal/commits"
+	"github.com/matthewmueller/chunky/internal/signatures"
+	"github.com/matthewmueller/chunky/internal/tags"
+	"github.com/matthewmueller/chunky/repos"
+)
+
+// ErrUnsigned is returned when a revision isn't signed by a trusted key
+var ErrUnsigned = signatures.ErrUnsigned
+
+type Verify struct {
+	Repo     repos.Repo
+	Revision string
+	// TrustedKeys that may sign revisions
+	TrustedKeys []ed25519.PublicKey
+}
+
+func (in *Verify) validate() (err error) {
+	if in.Repo == nil {
+		err = errors.Join(err, errors.New("missing 'repo'"))
+	}
+	if in.Revision == "" {
+		err = errors.Join(err, errors.New("missing 'revision'"))
+	}
+	if len(in.TrustedKeys) == 0 {
+		err = errors.Join(err, errors.New("missing 'trusted keys'"))
+	}
+	return err
+}
+
+// Verify checks that a revision was signed by one of the trusted keys. When the
+// revision is a tag, the tag must also be signed by a trusted key.
+func (c *Client) Verify(ctx context.Context, in *Verify) (*Commit, error) {
+	if err := in.validate(); err != nil {
+		return nil, err
+	}
+	return verifyRevision(ctx, in.Repo, in.Revision, in.TrustedKeys)
+}
+
+// Resolve a revision into a commit, verifying the signatures along the way
+func verifyRevision(ctx context.Context, repo repos.Repo, revision string, trusted []ed25519.PublicKey) (*commits.Commit, error) {
+	commitId := revision
+	commitFile, err := repos.Download(ctx, repo, path.Join("commits", revision))
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("chunky: unable to download commit %q: %w", revision, err)
+		}
+		// Resolve the revision as a tag, verifying the tag's signature first
+		name, n := tags.ParseRevision(revision)
+		tagFile, err := verifyFile(ctx, repo, path.Join("tags", name), trusted)
+		if err != nil {
+			return nil, err
+		}
+		tag, err := tags.Parse(name, tagFile.Data)
+		if err != nil {
+			return nil, err
+		}
+		var ok bool
+		commitId, ok = tag.At(n)
+		if !ok {
+			return nil, fmt.Errorf("chunky: revision not found: %s: %w", revision, fs.ErrNotExist)
+		}
+		commitFile, err = repos.Download(ctx, repo, path.Join("commits", commitId))
+		if err != nil {
+			return nil, fmt.Errorf("chunky: unable to download commit %q: %w", commitId, err)
+		}
+	}
+	if err := verifySignature(ctx, repo, commitFile, trusted); err != nil {
+		return nil, err
+	}
+	return commits.Unpack(commitFile.Data)
+}
+
+// Download a file and verify its signature
+func verifyFile(ctx context.Context, repo repos.Repo, fpath string, trusted []ed25519.PublicKey) (*repos.File, error) {
+	file, err := repos.Download(ctx, repo, fpath)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(ctx, repo, file, trusted); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func verifySignature(ctx context.Context, repo repos.Repo, file *repos.File, trusted []ed25519.PublicKey) error {
+	sigFile, err := repos.Download(ctx, repo, signatures.Path(file.Path))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("%w for %q", ErrUnsigned, file.Path)
+		}
+		return fmt.Errorf("chunky: unable to download signature for %q: %w", file.Path, err)
+	}
+	if _, err := signatures.Verify(file.Path, file.Data, sigFile.Data, trusted); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Sign the files with the key, returning the files along with their signatures.
+// Files are returned as-is when there's no key.
+func signFiles(key ed25519.PrivateKey, files ...*repos.File) ([]*repos.File, error) {
+	if key == nil {
+		return files, nil
+	}
+	signed := make([]*repos.File, 0, len(files)*2)
+	for _, file := range files {
+		sigFile, err := signatures.Sign(key, file)
+		if err != nil {
+			return nil, err
+		}
+		signed = append(signed, file, sigFile)
+	}
+	return signed, nil
+}